@@ -0,0 +1,13 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile shares src's extents with dst via the FICLONE ioctl (btrfs, XFS,
+// bcachefs, ...). It fails when the filesystem cannot reflink.
+func cloneFile(dst, src *os.File) error {
+	return unix.IoctlFileClone(int(dst.Fd()), int(src.Fd()))
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// defaultShell runs string commands the way whq always has.
+var defaultShell = []string{"bash", "-lc"}
+
+// Values for a command's stdin option.
+const (
+	stdinNull    = "null"
+	stdinInherit = "inherit"
+)
+
+// commandEntry is one configured command. It is written as a string (run
+// through the configured shell), as an argv array (executed directly), or as
+// an object with "run" plus options.
+type commandEntry struct {
+	// Name identifies the step in output and in other steps' Needs.
+	Name   string
+	Needs  []string
+	When   *whenCondition
+	Script string
+	Argv   []string
+	// Cwd is relative to the hook's working directory.
+	Cwd   string
+	Env   map[string]string
+	Stdin string
+}
+
+type commandObject struct {
+	Name  string            `json:"name"`
+	Needs []string          `json:"needs"`
+	When  *whenCondition    `json:"when"`
+	Run   json.RawMessage   `json:"run"`
+	Cwd   string            `json:"cwd"`
+	Env   map[string]string `json:"env"`
+	Stdin string            `json:"stdin"`
+}
+
+func (e *commandEntry) UnmarshalJSON(data []byte) error {
+	*e = commandEntry{}
+	if err := e.setRun(data); err == nil {
+		return nil
+	}
+	var obj commandObject
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return errors.New("command must be a string, an argv array or an object with \"run\"")
+	}
+	if len(obj.Run) == 0 {
+		return errors.New("command object requires \"run\"")
+	}
+	if err := e.setRun(obj.Run); err != nil {
+		return err
+	}
+	e.Name, e.Needs, e.When = strings.TrimSpace(obj.Name), obj.Needs, obj.When
+	e.Cwd, e.Env, e.Stdin = obj.Cwd, obj.Env, obj.Stdin
+	return nil
+}
+
+func (e *commandEntry) setRun(data []byte) error {
+	var script string
+	if err := json.Unmarshal(data, &script); err == nil {
+		e.Script = script
+		return nil
+	}
+	var argv []string
+	if err := json.Unmarshal(data, &argv); err == nil {
+		e.Argv = argv
+		return nil
+	}
+	return errors.New("\"run\" must be a string or an argv array")
+}
+
+// label is how the command is echoed in progress output and errors.
+func (e commandEntry) label() string {
+	if e.Argv == nil {
+		return strings.TrimSpace(e.Script)
+	}
+	quoted := make([]string, len(e.Argv))
+	for i, a := range e.Argv {
+		if a == "" || strings.ContainsAny(a, " \t\n\"'\\$`") {
+			quoted[i] = fmt.Sprintf("%q", a)
+		} else {
+			quoted[i] = a
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// commandRunner executes command entries for a hook.
+type commandRunner struct {
+	shell []string
+	hc    hookContext
+	// isolate starts each command in its own process group so cancelling a
+	// parallel run also stops grandchildren. Commands reading the terminal
+	// are never isolated, since a background group cannot read it.
+	isolate bool
+}
+
+// build prepares e for execution in r.hc.Dir. Output is wired by the caller.
+func (r commandRunner) build(ctx context.Context, e commandEntry) (*exec.Cmd, error) {
+	shell := r.shell
+	var argv []string
+	if e.Argv != nil {
+		if len(e.Argv) == 0 || e.Argv[0] == "" {
+			return nil, errors.New("argv command is empty")
+		}
+		argv = e.Argv
+	} else {
+		script := strings.TrimSpace(e.Script)
+		if script == "" {
+			return nil, errors.New("command is empty")
+		}
+		if len(shell) == 0 {
+			shell = defaultShell
+		}
+		argv = append(append([]string{}, shell...), script)
+	}
+
+	dir := r.hc.Dir
+	if cwd := strings.TrimSpace(e.Cwd); cwd != "" && filepath.Clean(cwd) != "." {
+		var err error
+		if dir, err = safeJoin(r.hc.Dir, cwd); err != nil {
+			return nil, fmt.Errorf("invalid cwd %q: %w", cwd, err)
+		}
+	}
+
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), r.hc.environ()...)
+	if len(e.Env) > 0 {
+		keys := make([]string, 0, len(e.Env))
+		for k := range e.Env {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			cmd.Env = append(cmd.Env, k+"="+e.Env[k])
+		}
+	}
+	switch e.Stdin {
+	case "", stdinNull:
+	case stdinInherit:
+		cmd.Stdin = os.Stdin
+	default:
+		return nil, fmt.Errorf("invalid stdin %q (want %q or %q)", e.Stdin, stdinInherit, stdinNull)
+	}
+	if r.isolate && cmd.Stdin == nil {
+		setProcessGroup(cmd)
+	}
+	return cmd, nil
+}
+
+// run executes e, streaming output to stdout and stderr. Cancelling ctx
+// terminates the command.
+func (r commandRunner) run(ctx context.Context, e commandEntry, stdout, stderr io.Writer) error {
+	cmd, err := r.build(ctx, e)
+	if err != nil {
+		return err
+	}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	return cmd.Run()
+}
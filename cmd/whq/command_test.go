@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCommandEntryUnmarshal(t *testing.T) {
+	var entries []commandEntry
+	data := `[
+		"pnpm install",
+		["go", "mod", "download"],
+		{"run": ["make", "seed"], "cwd": "db", "env": {"RAILS_ENV": "test"}, "stdin": "inherit"}
+	]`
+	if err := json.Unmarshal([]byte(data), &entries); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	want := []commandEntry{
+		{Script: "pnpm install"},
+		{Argv: []string{"go", "mod", "download"}},
+		{Argv: []string{"make", "seed"}, Cwd: "db", Env: map[string]string{"RAILS_ENV": "test"}, Stdin: "inherit"},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Fatalf("entries mismatch:\n got %#v\nwant %#v", entries, want)
+	}
+
+	var bad commandEntry
+	if err := json.Unmarshal([]byte(`{"cwd": "x"}`), &bad); err == nil {
+		t.Fatalf("expected error for object without run")
+	}
+}
+
+func TestRunPostAddActionsArgvShellCwdEnv(t *testing.T) {
+	repoRoot := t.TempDir()
+	worktreeRoot := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(worktreeRoot, "sub"), 0o755); err != nil {
+		t.Fatalf("mkdir failed: %v", err)
+	}
+
+	writeFile(t, filepath.Join(repoRoot, ".whq.json"), `{
+		"post_add": {
+			"shell": ["sh", "-c"],
+			"commands": [
+				"printf \"$0\" > shell.txt",
+				["sh", "-c", "printf '%s' \"$GREETING\" > env.txt"],
+				{"run": ["touch", "it's here.txt"], "cwd": "sub", "env": {"GREETING": "unused"}},
+				{"run": "printf '%s' \"$GREETING\" > env2.txt", "env": {"GREETING": "hi"}}
+			]
+		}
+	}`)
+	t.Setenv("GREETING", "inherited")
+
+	if err := runPostAddActions(repoRoot, worktreeRoot, "demo"); err != nil {
+		t.Fatalf("expected success, got error: %v", err)
+	}
+
+	for file, want := range map[string]string{
+		"shell.txt": "sh",
+		"env.txt":   "inherited",
+		"env2.txt":  "hi",
+	} {
+		got, err := os.ReadFile(filepath.Join(worktreeRoot, file))
+		if err != nil || string(got) != want {
+			t.Fatalf("%s mismatch: %q, %v", file, got, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(worktreeRoot, "sub", "it's here.txt")); err != nil {
+		t.Fatalf("expected argv command to run in cwd: %v", err)
+	}
+}
+
+func TestRunPostAddActionsRejectsEscapingCwd(t *testing.T) {
+	repoRoot := t.TempDir()
+	worktreeRoot := t.TempDir()
+	writeFile(t, filepath.Join(repoRoot, ".whq.json"), `{
+		"post_add": {"commands": [{"run": ["true"], "cwd": "../elsewhere"}]}
+	}`)
+
+	err := runPostAddActions(repoRoot, worktreeRoot, "demo")
+	if err == nil || !strings.Contains(err.Error(), "invalid cwd") {
+		t.Fatalf("expected cwd error, got %v", err)
+	}
+}
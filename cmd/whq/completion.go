@@ -0,0 +1,254 @@
+package main
+
+import (
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// registerCompletions wires dynamic completion into the commands. It runs
+// from main, after every init has registered its flags.
+func registerCompletions() {
+	for _, c := range []*cobra.Command{pathCmd, cdCmd, rmCmd, execCmd} {
+		c.ValidArgsFunction = completeWorktrees
+	}
+	addCmd.ValidArgsFunction = completeBranches
+	trustCmd.ValidArgsFunction = completeBranches
+	runCmd.ValidArgsFunction = completeRun
+	shellCmd.ValidArgsFunction = completeShell
+	_ = foreachCmd.RegisterFlagCompletionFunc("filter", fixedCompletions("dirty"))
+	_ = addCmd.RegisterFlagCompletionFunc("config-source", fixedCompletions(configSourceMain, configSourceNew, configSourceBase))
+
+	for _, c := range []*cobra.Command{configGetCmd, configSetCmd, configAddCmd, configRemoveCmd} {
+		c.ValidArgsFunction = completeConfigKeys
+		_ = c.RegisterFlagCompletionFunc("scope", fixedCompletions(layerUser, layerRepo, layerLocal, layerEnv))
+	}
+	configSetCmd.ValidArgsFunction = completeConfigSet
+	for _, c := range []*cobra.Command{configMigrateCmd, configValidateCmd} {
+		c.ValidArgsFunction = completeConfigFiles
+	}
+}
+
+func fixedCompletions(values ...string) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+		return values, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// isCompletionCmd reports whether cmd is `whq completion <shell>` or the
+// hidden command shells call to request completions.
+func isCompletionCmd(cmd *cobra.Command) bool {
+	if cmd.Name() == cobra.ShellCompRequestCmd || cmd.Name() == cobra.ShellCompNoDescRequestCmd {
+		return true
+	}
+	parent := cmd.Parent()
+	return parent != nil && parent.Name() == "completion" && parent.HasParent() && !parent.Parent().HasParent()
+}
+
+// completionEnv detects the repository for completion requests, which skip
+// the usual setup so that completing outside a repository stays quiet.
+func completionEnv() bool {
+	if env.RepoRoot != "" {
+		return true
+	}
+	return initEnv() == nil
+}
+
+// completeShell offers worktrees, or branches for --scratch.
+func completeShell(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if shellScratch {
+		return completeBranches(cmd, args, toComplete)
+	}
+	return completeWorktrees(cmd, args, toComplete)
+}
+
+// completeWorktrees offers @ and the worktrees under repo_whq_root, named as
+// `whq list` prints them.
+func completeWorktrees(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) != 0 || !completionEnv() {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	wts, err := listWorktrees(env.RepoRoot)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	out := []string{"@\tmain worktree"}
+	for _, p := range wts[min(1, len(wts)):] {
+		rel := tryRel(env.RepoWHQRoot, p)
+		if rel == "" || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		out = append(out, rel+"\t"+p)
+	}
+	return filterCompletions(out, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// filterCompletions keeps the candidates starting with toComplete; the part
+// after a tab is a description.
+func filterCompletions(comps []string, toComplete string) []string {
+	var out []string
+	for _, c := range comps {
+		if strings.HasPrefix(c, toComplete) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// completeBranches offers local branches, then branches that exist only on
+// a remote (without the remote's prefix, as `whq add` takes them).
+func completeBranches(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) != 0 || !completionEnv() {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	c := exec.Command("git", "for-each-ref", "--format=%(refname)", "refs/heads", "refs/remotes")
+	c.Dir = env.RepoRoot
+	out, err := c.Output()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	var local, remote []string
+	seen := map[string]bool{}
+	for _, ref := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if name, ok := strings.CutPrefix(ref, "refs/heads/"); ok {
+			local = append(local, name)
+			seen[name] = true
+			continue
+		}
+		rest, ok := strings.CutPrefix(ref, "refs/remotes/")
+		if !ok {
+			continue
+		}
+		remoteName, name, ok := strings.Cut(rest, "/")
+		if !ok || name == "HEAD" {
+			continue
+		}
+		remote = append(remote, name+"\t"+remoteName+"/"+name)
+	}
+	comps := local
+	for _, r := range remote {
+		name, _, _ := strings.Cut(r, "\t")
+		if !seen[name] {
+			seen[name] = true
+			comps = append(comps, r)
+		}
+	}
+	return filterCompletions(comps, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeRun offers the scripts, then the worktree to run one in.
+func completeRun(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	switch len(args) {
+	case 0:
+	case 1:
+		return completeWorktrees(cmd, nil, toComplete)
+	default:
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	if !completionEnv() {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	cfg, err := loadWHQConfig(env.RepoRoot)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	var out []string
+	for _, name := range cfg.scriptNames() {
+		desc := cfg.Scripts[name].Description
+		if desc == "" {
+			desc = cfg.Scripts[name].label()
+		}
+		out = append(out, name+"\t"+desc)
+	}
+	return filterCompletions(out, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// configKey is a dotted configuration key and the schema of its value.
+type configKey struct {
+	name   string
+	schema *schema
+}
+
+// configKeys lists every key the schema allows, parents before children.
+func configKeys() []configKey {
+	defs := configDefs()
+	var keys []configKey
+	var walk func(prefix string, s *schema)
+	walk = func(prefix string, s *schema) {
+		if s.Ref != "" {
+			s = defs[strings.TrimPrefix(s.Ref, "#/$defs/")]
+		}
+		for _, alt := range s.AnyOf {
+			if !alt.appendList {
+				walk(prefix, alt)
+			}
+		}
+		names := make([]string, 0, len(s.Properties))
+		for name := range s.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			key := joinConfigPath(prefix, name)
+			keys = append(keys, configKey{name: key, schema: s.Properties[name]})
+			walk(key, s.Properties[name])
+		}
+	}
+	walk("", configSchema())
+	return keys
+}
+
+func completeConfigKeys(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) != 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	var out []string
+	for _, k := range configKeys() {
+		if desc := keyDescription(k.schema); desc != "" {
+			out = append(out, k.name+"\t"+desc)
+		} else {
+			out = append(out, k.name)
+		}
+	}
+	return filterCompletions(out, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeConfigSet completes the key, then the values an enum or boolean
+// key accepts.
+func completeConfigSet(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) == 0 {
+		return completeConfigKeys(cmd, args, toComplete)
+	}
+	if len(args) != 1 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	for _, k := range configKeys() {
+		if k.name != args[0] {
+			continue
+		}
+		switch {
+		case len(k.schema.Enum) > 0:
+			return filterCompletions(k.schema.Enum, toComplete), cobra.ShellCompDirectiveNoFileComp
+		case k.schema.Type == "boolean":
+			return filterCompletions([]string{"true", "false"}, toComplete), cobra.ShellCompDirectiveNoFileComp
+		}
+	}
+	return nil, cobra.ShellCompDirectiveNoFileComp
+}
+
+func keyDescription(s *schema) string {
+	if s.Description != "" || len(s.AnyOf) == 0 {
+		return s.Description
+	}
+	return s.AnyOf[0].Description
+}
+
+func completeConfigFiles(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	exts := make([]string, len(configExtensions))
+	for i, ext := range configExtensions {
+		exts[i] = strings.TrimPrefix(ext, ".")
+	}
+	return exts, cobra.ShellCompDirectiveFilterFileExt
+}
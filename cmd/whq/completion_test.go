@@ -0,0 +1,63 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestCompleteConfigKeys(t *testing.T) {
+	var names []string
+	for _, k := range configKeys() {
+		names = append(names, k.name)
+	}
+	for _, want := range []string{"version", "post_add.copy_options.on_tracked", "pre_rm.commands", "post_prune.parallelism"} {
+		if !slices.Contains(names, want) {
+			t.Errorf("missing key %s in %v", want, names)
+		}
+	}
+	if slices.Contains(names, "post_add.copy.$append") {
+		t.Errorf("the $append form is not a key")
+	}
+
+	got, _ := completeConfigKeys(configGetCmd, nil, "post_add.copy_options.on")
+	if len(got) != 1 || got[0] != "post_add.copy_options.on_tracked\tWhat to do when a copy overwrites tracked files" {
+		t.Fatalf("unexpected completions: %q", got)
+	}
+	got, _ = completeConfigSet(configSetCmd, []string{"config_source"}, "")
+	if !slices.Equal(got, []string{configSourceMain, configSourceNew, configSourceBase}) {
+		t.Fatalf("unexpected values: %q", got)
+	}
+	got, _ = completeConfigSet(configSetCmd, []string{"post_add.copy_options.dereference"}, "t")
+	if !slices.Equal(got, []string{"true"}) {
+		t.Fatalf("unexpected values: %q", got)
+	}
+}
+
+func TestCompleteWorktreesAndBranches(t *testing.T) {
+	repo := initGitRepo(t)
+	runGit(t, repo, "branch", "topic")
+	runGit(t, repo, "update-ref", "refs/remotes/origin/topic", "HEAD")
+	runGit(t, repo, "update-ref", "refs/remotes/origin/remote-only", "HEAD")
+	whqRoot := t.TempDir()
+	dest := filepath.Join(whqRoot, "feature", "x")
+	runGit(t, repo, "worktree", "add", "-q", "-b", "feature/x", dest)
+	origEnv := env
+	env.RepoRoot, env.RepoWHQRoot = repo, whqRoot
+	t.Cleanup(func() { env = origEnv })
+
+	got, _ := completeWorktrees(pathCmd, nil, "")
+	if want := []string{"@\tmain worktree", "feature/x\t" + dest}; !slices.Equal(got, want) {
+		t.Fatalf("worktrees: got %q, want %q", got, want)
+	}
+	if got, _ := completeWorktrees(pathCmd, []string{"feature/x"}, ""); len(got) != 0 {
+		t.Fatalf("expected no completions for a second argument, got %q", got)
+	}
+
+	branch := runGit(t, repo, "branch", "--show-current")
+	got, _ = completeBranches(addCmd, nil, "")
+	want := []string{"feature/x", branch, "topic", "remote-only\torigin/remote-only"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("branches: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,515 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	configShowOrigin bool
+
+	configCmd = &cobra.Command{
+		Use:   "config",
+		Short: "Inspect whq configuration",
+	}
+
+	configShowCmd = &cobra.Command{
+		Use:   "show [--origin]",
+		Short: "Print the merged configuration",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 0 {
+				return errors.New("Usage: whq config show [--origin]")
+			}
+			layers, err := configLayers(env.RepoRoot, nil)
+			if err != nil {
+				return err
+			}
+			merged, err := mergeConfigLayers(layers)
+			if err != nil {
+				return err
+			}
+			if configShowOrigin {
+				return merged.writeOrigins(os.Stdout)
+			}
+			return merged.writeJSON(os.Stdout)
+		},
+	}
+)
+
+var configSchemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Print the JSON Schema of whq configuration files",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 0 {
+			return errors.New("Usage: whq config schema")
+		}
+		doc, err := configSchemaDocument()
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(os.Stdout, "%s\n", doc)
+		return err
+	},
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate [file...]",
+	Short: "Check configuration files (default: every layer of this repository)",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) > 0 {
+			return validateConfigFiles(args, os.Stdout)
+		}
+		return validateRepoConfig(env.RepoRoot, os.Stdout)
+	},
+}
+
+func init() {
+	configShowCmd.Flags().BoolVar(&configShowOrigin, "origin", false, "Show which file each value came from")
+	configCmd.AddCommand(configShowCmd)
+	configCmd.AddCommand(configSchemaCmd)
+	configCmd.AddCommand(configValidateCmd)
+}
+
+// validateConfigFiles checks each file on its own, reporting every failure
+// before returning.
+func validateConfigFiles(paths []string, stdout io.Writer) error {
+	var errs []error
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			var layer *configLayer
+			if layer, err = newConfigLayer(layerEnv, path, data); err == nil {
+				err = checkLayers([]*configLayer{layer})
+			}
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		fmt.Fprintf(stdout, "%s: ok\n", path)
+	}
+	return errors.Join(errs...)
+}
+
+// validateRepoConfig checks every layer of repoRoot and their merge.
+func validateRepoConfig(repoRoot string, stdout io.Writer) error {
+	layers, err := configLayers(repoRoot, nil)
+	if err != nil {
+		return err
+	}
+	if len(layers) == 0 {
+		fmt.Fprintln(stdout, "No configuration files found")
+		return nil
+	}
+	if err := checkLayers(layers); err != nil {
+		return err
+	}
+	for _, layer := range layers {
+		fmt.Fprintf(stdout, "%s: ok\n", layer.Path)
+	}
+	return nil
+}
+
+// checkLayers merges layers and runs the checks whq otherwise only does
+// right before a hook runs: step graphs, conditions and copy settings.
+func checkLayers(layers []*configLayer) error {
+	merged, err := mergeConfigLayers(layers)
+	if err != nil {
+		return err
+	}
+	cfg, err := merged.decode()
+	if err != nil {
+		return err
+	}
+	if _, err := configSource(cfg); err != nil {
+		return err
+	}
+	for _, key := range trustedHooks {
+		if h := cfg.hook(key); h != nil {
+			if _, err := h.plan(); err != nil {
+				return fmt.Errorf("%w (in %s)", err, key)
+			}
+		}
+	}
+	if cfg.PostAdd != nil {
+		for _, entry := range cfg.PostAdd.Copy {
+			if err := entry.When.validate(); err != nil {
+				return err
+			}
+		}
+		return validateCopyHygiene(cfg.PostAdd)
+	}
+	return nil
+}
+
+// Configuration layers, lowest precedence first.
+const (
+	layerUser  = "user"
+	layerRepo  = "repo"
+	layerLocal = "local"
+	layerEnv   = "env"
+)
+
+// appendKey wraps a list in an upper layer that extends the lower layers'
+// list instead of replacing it: {"$append": [...]}.
+const appendKey = "$append"
+
+// configLayer is one source of configuration and its raw JSON.
+type configLayer struct {
+	Name string
+	Path string
+	data []byte
+}
+
+func (l *configLayer) String() string {
+	return l.Name + " (" + l.Path + ")"
+}
+
+// readConfigLayer reads path as a layer; a missing file yields nil.
+func readConfigLayer(name, path string) (*configLayer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("whq: failed to read %s: %w", path, err)
+	}
+	return newConfigLayer(name, path, data)
+}
+
+// newConfigLayer decodes raw in the format path's extension names, migrates
+// it to the current version, checks it against the schema and keeps it as
+// JSON for merging.
+func newConfigLayer(name, path string, raw []byte) (*configLayer, error) {
+	parsed, err := parseConfigFile(path, raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := migrateParsedConfig(path, parsed); err != nil {
+		return nil, err
+	}
+	if problems := validateConfig(parsed.value); len(problems) > 0 {
+		return nil, parsed.validationError(path, problems)
+	}
+	data, err := json.Marshal(parsed.value)
+	if err != nil {
+		return nil, err
+	}
+	return &configLayer{Name: name, Path: path, data: data}, nil
+}
+
+// validationError reports every problem as <file>:<line>:<col>: <message>.
+func (p *parsedConfig) validationError(path string, problems []schemaError) error {
+	errs := make([]error, len(problems))
+	for i, problem := range problems {
+		where := path
+		if pos, ok := p.at(problem.Path); ok {
+			where = fmt.Sprintf("%s:%d:%d", path, pos.Line, pos.Column)
+		}
+		errs[i] = fmt.Errorf("whq: %s: %s", where, problem.Message)
+	}
+	return errors.Join(errs...)
+}
+
+// findConfigFile returns dir/base with whichever supported extension exists,
+// or "" when none does. Two formats side by side are ambiguous.
+func findConfigFile(dir, base string) (string, error) {
+	var found []string
+	for _, ext := range configExtensions {
+		path := filepath.Join(dir, base+ext)
+		if _, err := os.Stat(path); err == nil {
+			found = append(found, path)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", nil
+	case 1:
+		return found[0], nil
+	}
+	return "", fmt.Errorf("whq: found %s; keep only one", strings.Join(found, " and "))
+}
+
+// readConfigLayerIn reads the layer stored as dir/base.<ext>.
+func readConfigLayerIn(name, dir, base string) (*configLayer, error) {
+	path, err := findConfigFile(dir, base)
+	if err != nil || path == "" {
+		return nil, err
+	}
+	return readConfigLayer(name, path)
+}
+
+// configLayers lists the files that make up the configuration of repoRoot:
+// the user file, .whq.json (or repo, when given) and .whq.local.json, each
+// possibly in YAML or TOML instead.
+// WHQ_CONFIG replaces all of them with one explicit file.
+func configLayers(repoRoot string, repo *configLayer) ([]*configLayer, error) {
+	if path := strings.TrimSpace(os.Getenv("WHQ_CONFIG")); path != "" {
+		layer, err := readConfigLayer(layerEnv, path)
+		if err != nil {
+			return nil, err
+		}
+		if layer == nil {
+			return nil, fmt.Errorf("whq: WHQ_CONFIG points at %s, which does not exist", path)
+		}
+		return []*configLayer{layer}, nil
+	}
+
+	var layers []*configLayer
+	if dir, err := userConfigDir(); err == nil {
+		layer, err := readConfigLayerIn(layerUser, dir, "config")
+		if err != nil {
+			return nil, err
+		}
+		layers = appendLayer(layers, layer)
+	}
+	if repo == nil {
+		layer, err := readConfigLayerIn(layerRepo, repoRoot, ".whq")
+		if err != nil {
+			return nil, err
+		}
+		repo = layer
+	}
+	layers = appendLayer(layers, repo)
+	local, err := readConfigLayerIn(layerLocal, repoRoot, ".whq.local")
+	if err != nil {
+		return nil, err
+	}
+	return appendLayer(layers, local), nil
+}
+
+func appendLayer(layers []*configLayer, layer *configLayer) []*configLayer {
+	if layer == nil {
+		return layers
+	}
+	return append(layers, layer)
+}
+
+// loadConfigLayers merges and decodes the layers of repoRoot. It returns nil
+// when there are none.
+func loadConfigLayers(repoRoot string, repo *configLayer) (*whqConfig, error) {
+	layers, err := configLayers(repoRoot, repo)
+	if err != nil || len(layers) == 0 {
+		return nil, err
+	}
+	merged, err := mergeConfigLayers(layers)
+	if err != nil {
+		return nil, err
+	}
+	return merged.decode()
+}
+
+// mergedConfig is the result of layering, with the layer each value came
+// from keyed by its path (e.g. "post_add.commands[1]").
+type mergedConfig struct {
+	value   map[string]any
+	origins map[string]*configLayer
+}
+
+// mergeConfigLayers merges layers in order. Objects merge key by key; any
+// other value, including a list, replaces what lower layers set unless it is
+// wrapped in {"$append": [...]}.
+func mergeConfigLayers(layers []*configLayer) (*mergedConfig, error) {
+	m := &mergedConfig{value: map[string]any{}, origins: map[string]*configLayer{}}
+	for _, layer := range layers {
+		dec := json.NewDecoder(bytes.NewReader(layer.data))
+		dec.UseNumber()
+		var obj map[string]any
+		if err := dec.Decode(&obj); err != nil {
+			return nil, fmt.Errorf("whq: invalid %s: %w", layer.Path, err)
+		}
+		// Decode the layer on its own first so type errors name the file.
+		plain, err := unwrapAppends(obj, "")
+		if err != nil {
+			return nil, fmt.Errorf("%w (in %s)", err, layer.Path)
+		}
+		data, err := json.Marshal(plain)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := parseWHQConfig(data, layer.Path); err != nil {
+			return nil, err
+		}
+		if err := m.mergeObject(m.value, obj, "", layer); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *mergedConfig) mergeObject(dst, src map[string]any, prefix string, layer *configLayer) error {
+	for key, val := range src {
+		path := joinConfigPath(prefix, key)
+		if srcObj, ok := val.(map[string]any); ok {
+			if items, ok, err := appendItems(srcObj, path); err != nil {
+				return err
+			} else if ok {
+				existing, isList := dst[key].([]any)
+				if !isList && dst[key] != nil {
+					// A single value where a string-or-list is accepted.
+					existing = []any{dst[key]}
+				}
+				for i, item := range items {
+					m.record(fmt.Sprintf("%s[%d]", path, len(existing)+i), item, layer)
+				}
+				dst[key] = append(existing, items...)
+				continue
+			}
+			if dstObj, ok := dst[key].(map[string]any); ok {
+				if err := m.mergeObject(dstObj, srcObj, path, layer); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		plain, err := unwrapAppends(val, path)
+		if err != nil {
+			return err
+		}
+		m.forget(path)
+		dst[key] = plain
+		m.record(path, plain, layer)
+	}
+	return nil
+}
+
+// appendItems recognizes {"$append": [...]}.
+func appendItems(obj map[string]any, path string) ([]any, bool, error) {
+	raw, ok := obj[appendKey]
+	if !ok {
+		return nil, false, nil
+	}
+	items, isList := raw.([]any)
+	if !isList || len(obj) != 1 {
+		return nil, false, fmt.Errorf("whq: %s: %q must be the only key and hold a list", path, appendKey)
+	}
+	return items, true, nil
+}
+
+// unwrapAppends replaces {"$append": [...]} with its list, for values that
+// have nothing below them to append to.
+func unwrapAppends(v any, path string) (any, error) {
+	obj, ok := v.(map[string]any)
+	if !ok {
+		return v, nil
+	}
+	if items, ok, err := appendItems(obj, path); ok || err != nil {
+		return items, err
+	}
+	out := make(map[string]any, len(obj))
+	for k, val := range obj {
+		plain, err := unwrapAppends(val, joinConfigPath(path, k))
+		if err != nil {
+			return nil, err
+		}
+		out[k] = plain
+	}
+	return out, nil
+}
+
+// record attributes v and everything below it to layer.
+func (m *mergedConfig) record(path string, v any, layer *configLayer) {
+	m.origins[path] = layer
+	switch v := v.(type) {
+	case map[string]any:
+		for k, val := range v {
+			m.record(joinConfigPath(path, k), val, layer)
+		}
+	case []any:
+		for i, val := range v {
+			m.record(fmt.Sprintf("%s[%d]", path, i), val, layer)
+		}
+	}
+}
+
+// forget drops the origins of a value that is being replaced.
+func (m *mergedConfig) forget(path string) {
+	for p := range m.origins {
+		if p == path || strings.HasPrefix(p, path+".") || strings.HasPrefix(p, path+"[") {
+			delete(m.origins, p)
+		}
+	}
+}
+
+func joinConfigPath(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+func (m *mergedConfig) decode() (*whqConfig, error) {
+	data, err := json.Marshal(m.value)
+	if err != nil {
+		return nil, err
+	}
+	return parseWHQConfig(data, "configuration")
+}
+
+func (m *mergedConfig) writeJSON(w io.Writer) error {
+	data, err := json.MarshalIndent(m.value, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+// writeOrigins prints one line per scalar (or empty) value: its path, the
+// value and the layer that set it.
+func (m *mergedConfig) writeOrigins(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	var walk func(path string, v any) error
+	walk = func(path string, v any) error {
+		switch v := v.(type) {
+		case map[string]any:
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				if err := walk(joinConfigPath(path, k), v[k]); err != nil {
+					return err
+				}
+			}
+			if len(v) > 0 || path == "" {
+				return nil
+			}
+		case []any:
+			for i, item := range v {
+				if err := walk(path+"["+strconv.Itoa(i)+"]", item); err != nil {
+					return err
+				}
+			}
+			if len(v) > 0 {
+				return nil
+			}
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		origin := "?"
+		if layer := m.origins[path]; layer != nil {
+			origin = layer.String()
+		}
+		_, err = fmt.Fprintf(tw, "%s\t%s\t%s\n", path, data, origin)
+		return err
+	}
+	if err := walk("", m.value); err != nil {
+		return err
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,560 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2/unstable"
+	"gopkg.in/yaml.v3"
+)
+
+// configDocument is a configuration file opened for rewriting. Unlike
+// parseConfigFile it keeps the file's text: edits touch only the values
+// they change, so layout and comments elsewhere survive. Keys are addressed
+// by their path from the top level.
+type configDocument interface {
+	// get returns the value at key decoded into JSON-compatible values.
+	get(key []string) (any, bool)
+	// set stores value at key, creating parent objects; new keys go last.
+	set(key []string, value any) error
+	// setFirst stores value at a top-level key, placing it first if new.
+	setFirst(key string, value any) error
+	// remove deletes key and returns its value in a form set accepts.
+	remove(key []string) (any, bool, error)
+	encode() ([]byte, error)
+}
+
+// openConfigDocument parses data in the format of name's extension.
+func openConfigDocument(name string, data []byte) (configDocument, error) {
+	var (
+		doc configDocument
+		err error
+	)
+	switch strings.ToLower(path.Ext(name)) {
+	case ".yaml", ".yml":
+		doc, err = openYAMLDocument(data)
+	case ".toml":
+		doc, err = openTOMLDocument(data)
+	default:
+		doc, err = openJSONDocument(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("whq: invalid %s: %w", name, err)
+	}
+	return doc, nil
+}
+
+// orderedMap is an object that remembers key order. Values are scalars,
+// []any or *orderedMap.
+type orderedMap struct {
+	keys   []string
+	values map[string]any
+}
+
+func newOrderedMap() *orderedMap {
+	return &orderedMap{values: map[string]any{}}
+}
+
+func (m *orderedMap) put(key string, value any, first bool) {
+	if _, ok := m.values[key]; !ok {
+		if first {
+			m.keys = append([]string{key}, m.keys...)
+		} else {
+			m.keys = append(m.keys, key)
+		}
+	}
+	m.values[key] = value
+}
+
+func (m *orderedMap) delete(key string) {
+	delete(m.values, key)
+	for i, k := range m.keys {
+		if k == key {
+			m.keys = append(m.keys[:i], m.keys[i+1:]...)
+			return
+		}
+	}
+}
+
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range m.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := marshalJSONValue(k)
+		if err != nil {
+			return nil, err
+		}
+		val, err := marshalJSONValue(m.values[k])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(val)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// marshalJSONValue encodes v without escaping <, > and &.
+func marshalJSONValue(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+// plainValue converts ordered maps back into map[string]any.
+func plainValue(v any) any {
+	switch v := v.(type) {
+	case *orderedMap:
+		out := make(map[string]any, len(v.keys))
+		for _, k := range v.keys {
+			out[k] = plainValue(v.values[k])
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = plainValue(item)
+		}
+		return out
+	}
+	return v
+}
+
+// orderedValue converts values from outside (e.g. parsed from the command
+// line) into the representation ordered documents store.
+func orderedValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		m := newOrderedMap()
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			m.put(k, orderedValue(v[k]), false)
+		}
+		return m
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = orderedValue(item)
+		}
+		return out
+	}
+	return v
+}
+
+// orderedDocument implements configDocument over an orderedMap, for files
+// already parsed into memory.
+type orderedDocument struct {
+	root *orderedMap
+}
+
+func (d *orderedDocument) parent(key []string, create bool) *orderedMap {
+	m := d.root
+	for _, k := range key[:len(key)-1] {
+		next, ok := m.values[k].(*orderedMap)
+		if !ok {
+			if !create {
+				return nil
+			}
+			next = newOrderedMap()
+			m.put(k, next, false)
+		}
+		m = next
+	}
+	return m
+}
+
+func (d *orderedDocument) get(key []string) (any, bool) {
+	m := d.parent(key, false)
+	if m == nil {
+		return nil, false
+	}
+	v, ok := m.values[key[len(key)-1]]
+	return plainValue(v), ok
+}
+
+func (d *orderedDocument) set(key []string, value any) error {
+	d.parent(key, true).put(key[len(key)-1], orderedValue(value), false)
+	return nil
+}
+
+func (d *orderedDocument) setFirst(key string, value any) error {
+	d.root.put(key, orderedValue(value), true)
+	return nil
+}
+
+func (d *orderedDocument) remove(key []string) (any, bool, error) {
+	m := d.parent(key, false)
+	if m == nil {
+		return nil, false, nil
+	}
+	v, ok := m.values[key[len(key)-1]]
+	if ok {
+		m.delete(key[len(key)-1])
+	}
+	return v, ok, nil
+}
+
+func (d *orderedDocument) encode() ([]byte, error) {
+	return marshalJSONValue(d.root)
+}
+
+// listEdit describes the change from old to new as the indices of old items
+// to delete and the items to append, keeping in place as many old items as
+// a greedy match finds.
+func listEdit(old, new []any) (deleted []int, appended []any) {
+	j := 0
+	for i, item := range old {
+		if j < len(new) && sameConfigValue(plainValue(item), plainValue(new[j])) {
+			j++
+			continue
+		}
+		deleted = append(deleted, i)
+	}
+	return deleted, new[j:]
+}
+
+func putTOMLKeyValue(table *orderedMap, kv *unstable.Node) error {
+	keys := tomlKeys(kv)
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := table.values[string(key.Data)].(*orderedMap)
+		if !ok {
+			next = newOrderedMap()
+			table.put(string(key.Data), next, false)
+		}
+		table = next
+	}
+	val, err := tomlNodeValue(kv.Value())
+	if err != nil {
+		return err
+	}
+	table.put(string(keys[len(keys)-1].Data), val, false)
+	return nil
+}
+
+func tomlNodeValue(n *unstable.Node) (any, error) {
+	switch n.Kind {
+	case unstable.String:
+		return string(n.Data), nil
+	case unstable.Bool:
+		return string(n.Data) == "true", nil
+	case unstable.Integer:
+		return strconv.ParseInt(strings.ReplaceAll(string(n.Data), "_", ""), 0, 64)
+	case unstable.Float:
+		return strconv.ParseFloat(strings.ReplaceAll(string(n.Data), "_", ""), 64)
+	case unstable.InlineTable:
+		m := newOrderedMap()
+		it := n.Children()
+		for it.Next() {
+			if child := it.Node(); child.Kind == unstable.KeyValue {
+				if err := putTOMLKeyValue(m, child); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return m, nil
+	case unstable.Array:
+		list := []any{}
+		it := n.Children()
+		for it.Next() {
+			child := it.Node()
+			if child.Kind == unstable.Comment {
+				continue
+			}
+			v, err := tomlNodeValue(child)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	}
+	// Dates and times stay as written.
+	return string(n.Data), nil
+}
+
+var bareTOMLKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func tomlKey(k string) string {
+	if bareTOMLKey.MatchString(k) {
+		return k
+	}
+	quoted, _ := marshalJSONValue(k)
+	return string(quoted)
+}
+
+// writeTOMLTable writes the body of the table m at prefix: plain keys first,
+// then sub-tables and arrays of tables, as TOML requires; otherwise key
+// order is kept.
+func writeTOMLTable(buf *bytes.Buffer, prefix string, m *orderedMap) error {
+	var writeTable func(prefix string, m *orderedMap) error
+	writeTable = func(prefix string, m *orderedMap) error {
+		for _, k := range m.keys {
+			v := m.values[k]
+			if _, ok := v.(*orderedMap); ok || isTableArray(v) {
+				continue
+			}
+			text, err := tomlValue(v)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(buf, "%s = %s\n", tomlKey(k), text)
+		}
+		for _, k := range m.keys {
+			name := tomlKey(k)
+			if prefix != "" {
+				name = prefix + "." + name
+			}
+			switch v := m.values[k].(type) {
+			case *orderedMap:
+				if buf.Len() > 0 {
+					buf.WriteByte('\n')
+				}
+				fmt.Fprintf(buf, "[%s]\n", name)
+				if err := writeTable(name, v); err != nil {
+					return err
+				}
+			case []any:
+				if !isTableArray(v) {
+					continue
+				}
+				for _, item := range v {
+					if buf.Len() > 0 {
+						buf.WriteByte('\n')
+					}
+					fmt.Fprintf(buf, "[[%s]]\n", name)
+					if err := writeTable(name, item.(*orderedMap)); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	}
+	return writeTable(prefix, m)
+}
+
+func isTableArray(v any) bool {
+	list, ok := v.([]any)
+	if !ok || len(list) == 0 {
+		return false
+	}
+	for _, item := range list {
+		if _, ok := item.(*orderedMap); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func tomlValue(v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", errors.New("TOML cannot represent null")
+	case string:
+		quoted, err := marshalJSONValue(v)
+		return string(quoted), err
+	case *orderedMap:
+		parts := make([]string, len(v.keys))
+		for i, k := range v.keys {
+			text, err := tomlValue(v.values[k])
+			if err != nil {
+				return "", err
+			}
+			parts[i] = tomlKey(k) + " = " + text
+		}
+		return "{ " + strings.Join(parts, ", ") + " }", nil
+	case []any:
+		parts := make([]string, len(v))
+		for i, item := range v {
+			text, err := tomlValue(item)
+			if err != nil {
+				return "", err
+			}
+			parts[i] = text
+		}
+		return "[" + strings.Join(parts, ", ") + "]", nil
+	}
+	return fmt.Sprint(v), nil
+}
+
+// yamlDocument edits the node tree so comments and layout survive.
+type yamlDocument struct {
+	doc *yaml.Node
+}
+
+func openYAMLDocument(data []byte) (configDocument, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	if len(doc.Content) != 1 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, errors.New("top level must be a mapping")
+	}
+	return &yamlDocument{doc: &doc}, nil
+}
+
+// lookup returns the mapping holding key's last element, and that element's
+// index in it (-1 if absent).
+func (d *yamlDocument) lookup(key []string, create bool) (*yaml.Node, int) {
+	m := d.doc.Content[0]
+	for _, k := range key[:len(key)-1] {
+		i := yamlKeyIndex(m, k)
+		if i < 0 || m.Content[i+1].Kind != yaml.MappingNode {
+			if !create {
+				return nil, -1
+			}
+			child := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			if i < 0 {
+				m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k}, child)
+			} else {
+				m.Content[i+1] = child
+			}
+			m = child
+			continue
+		}
+		m = m.Content[i+1]
+	}
+	return m, yamlKeyIndex(m, key[len(key)-1])
+}
+
+func yamlKeyIndex(m *yaml.Node, key string) int {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func (d *yamlDocument) get(key []string) (any, bool) {
+	m, i := d.lookup(key, false)
+	if i < 0 {
+		return nil, false
+	}
+	var v any
+	if err := m.Content[i+1].Decode(&v); err != nil {
+		return nil, false
+	}
+	v, _ = normalizeConfigValue(v)
+	return v, true
+}
+
+func yamlValueNode(value any) (*yaml.Node, error) {
+	if n, ok := value.(*yaml.Node); ok {
+		return n, nil
+	}
+	var n yaml.Node
+	if err := n.Encode(plainValue(value)); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+func (d *yamlDocument) set(key []string, value any) error {
+	n, err := yamlValueNode(value)
+	if err != nil {
+		return err
+	}
+	m, i := d.lookup(key, true)
+	if i >= 0 {
+		if list, ok := value.([]any); ok && m.Content[i+1].Kind == yaml.SequenceNode {
+			return setYAMLList(m.Content[i+1], list)
+		}
+		m.Content[i+1] = n
+		return nil
+	}
+	m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key[len(key)-1]}, n)
+	return nil
+}
+
+// setYAMLList turns the sequence seq into list by deleting and appending
+// items, so the items that stay keep their comments and style.
+func setYAMLList(seq *yaml.Node, list []any) error {
+	old := make([]any, len(seq.Content))
+	for j, item := range seq.Content {
+		var v any
+		if err := item.Decode(&v); err != nil {
+			return err
+		}
+		old[j], _ = normalizeConfigValue(v)
+	}
+	deleted, appended := listEdit(old, list)
+	for k := len(deleted) - 1; k >= 0; k-- {
+		j := deleted[k]
+		seq.Content = append(seq.Content[:j], seq.Content[j+1:]...)
+	}
+	for _, item := range appended {
+		n, err := yamlValueNode(item)
+		if err != nil {
+			return err
+		}
+		seq.Content = append(seq.Content, n)
+	}
+	return nil
+}
+
+func (d *yamlDocument) setFirst(key string, value any) error {
+	n, err := yamlValueNode(value)
+	if err != nil {
+		return err
+	}
+	m, i := d.lookup([]string{key}, false)
+	if i >= 0 {
+		m.Content[i+1] = n
+		return nil
+	}
+	k := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}
+	// Comments opening the file belong to all of it, not to the key that
+	// used to come first.
+	if len(m.Content) > 0 {
+		k.HeadComment, m.Content[0].HeadComment = m.Content[0].HeadComment, ""
+	}
+	m.Content = append([]*yaml.Node{k, n}, m.Content...)
+	return nil
+}
+
+func (d *yamlDocument) remove(key []string) (any, bool, error) {
+	m, i := d.lookup(key, false)
+	if i < 0 {
+		return nil, false, nil
+	}
+	v := m.Content[i+1]
+	m.Content = append(m.Content[:i], m.Content[i+2:]...)
+	return v, true, nil
+}
+
+func (d *yamlDocument) encode() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(d.doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
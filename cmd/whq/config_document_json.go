@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// jsonDocument edits JSON text in place: only the span of the value that
+// changes is rewritten, in the style of its surroundings (one line or
+// indented), so the rest of the file keeps its layout byte for byte.
+type jsonDocument struct {
+	data []byte
+}
+
+// jsonNode is a value in the text, data[start:end].
+type jsonNode struct {
+	start, end int
+	object     bool
+	array      bool
+	members    []jsonMember
+	items      []*jsonNode
+}
+
+// jsonMoved is a value removed from the text; set puts it back as it was
+// written when it fits on one line.
+type jsonMoved struct {
+	value any
+	text  string
+}
+
+type jsonMember struct {
+	key      string
+	keyStart int
+	value    *jsonNode
+}
+
+func openJSONDocument(data []byte) (configDocument, error) {
+	d := &jsonDocument{data: data}
+	if _, err := d.parse(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// parse replays the token stream like jsonPositions, recording where every
+// key and value starts and ends.
+func (d *jsonDocument) parse() (*jsonNode, error) {
+	dec := json.NewDecoder(bytes.NewReader(d.data))
+	next := func() int {
+		off := int(dec.InputOffset())
+		for off < len(d.data) && strings.IndexByte(" \t\r\n,:", d.data[off]) >= 0 {
+			off++
+		}
+		return off
+	}
+	var read func() (*jsonNode, error)
+	read = func() (*jsonNode, error) {
+		n := &jsonNode{start: next()}
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch tok {
+		case json.Delim('{'):
+			n.object = true
+			for dec.More() {
+				keyStart := next()
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				val, err := read()
+				if err != nil {
+					return nil, err
+				}
+				n.members = append(n.members, jsonMember{key: fmt.Sprint(key), keyStart: keyStart, value: val})
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, err
+			}
+		case json.Delim('['):
+			n.array = true
+			for dec.More() {
+				item, err := read()
+				if err != nil {
+					return nil, err
+				}
+				n.items = append(n.items, item)
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, err
+			}
+		}
+		n.end = int(dec.InputOffset())
+		return n, nil
+	}
+	root, err := read()
+	if err != nil {
+		return nil, err
+	}
+	if !root.object {
+		return nil, errors.New("top level must be an object")
+	}
+	return root, nil
+}
+
+// walk follows key from root. It returns the object holding key's last
+// element and that member's index; when key is missing, the deepest node
+// reached (an object lacking the next element, or a non-object in the way)
+// and how many elements of key lead to it.
+func (n *jsonNode) walk(key []string) (node *jsonNode, member, depth int) {
+	for depth, k := range key {
+		if !n.object {
+			return n, -1, depth
+		}
+		i := n.member(k)
+		if i < 0 {
+			return n, -1, depth
+		}
+		if depth == len(key)-1 {
+			return n, i, depth
+		}
+		n = n.members[i].value
+	}
+	return n, -1, len(key)
+}
+
+func (n *jsonNode) member(key string) int {
+	for i, m := range n.members {
+		if m.key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func (d *jsonDocument) decode(n *jsonNode) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(d.data[n.start:n.end]))
+	dec.UseNumber()
+	var v any
+	err := dec.Decode(&v)
+	return v, err
+}
+
+func (d *jsonDocument) splice(start, end int, text string) {
+	d.data = append(d.data[:start:start], append([]byte(text), d.data[end:]...)...)
+}
+
+// indentUnit is the indentation the file uses per level, two spaces unless
+// the top level says otherwise.
+func (d *jsonDocument) indentUnit(root *jsonNode) string {
+	if len(root.members) > 0 {
+		if unit := lineIndent(d.data, root.members[0].keyStart); unit != "" && multiline(d.data, root.start, root.members[0].keyStart) {
+			return unit
+		}
+	}
+	return "  "
+}
+
+func (d *jsonDocument) get(key []string) (any, bool) {
+	root, err := d.parse()
+	if err != nil {
+		return nil, false
+	}
+	n, i, _ := root.walk(key)
+	if i < 0 {
+		return nil, false
+	}
+	v, err := d.decode(n.members[i].value)
+	return v, err == nil
+}
+
+func (d *jsonDocument) set(key []string, value any) error {
+	root, err := d.parse()
+	if err != nil {
+		return err
+	}
+	unit := d.indentUnit(root)
+	n, i, depth := root.walk(key)
+	if i >= 0 {
+		old := n.members[i].value
+		if list, ok := value.([]any); ok && old.array {
+			return d.setList(key, list)
+		}
+		return d.replace(old, value, unit)
+	}
+	// Whatever is missing below depth is created as nested objects.
+	if moved, ok := value.(*jsonMoved); ok && depth < len(key)-1 {
+		value = moved.value
+	}
+	for k := len(key) - 1; k > depth; k-- {
+		value = map[string]any{key[k]: value}
+	}
+	if !n.object {
+		return d.replace(n, map[string]any{key[depth]: value}, unit)
+	}
+	return d.insertMember(n, n == root, key[depth], value, unit, false)
+}
+
+// replace rewrites n's span with value, spread over lines only when n was.
+func (d *jsonDocument) replace(n *jsonNode, value any, unit string) error {
+	text, err := jsonText(value, lineIndent(d.data, n.start), unit, multiline(d.data, n.start, n.end))
+	if err != nil {
+		return err
+	}
+	d.splice(n.start, n.end, text)
+	return nil
+}
+
+// insertMember adds key to the object obj, last or, with first, first.
+func (d *jsonDocument) insertMember(obj *jsonNode, root bool, key string, value any, unit string, first bool) error {
+	name, err := marshalJSONValue(key)
+	if err != nil {
+		return err
+	}
+	if len(obj.members) == 0 {
+		spread := root || multiline(d.data, obj.start, obj.end)
+		closeIndent := lineIndent(d.data, obj.end-1)
+		text, err := jsonText(value, closeIndent+unit, unit, spread)
+		if err != nil {
+			return err
+		}
+		member := string(name) + ": " + text
+		if spread {
+			member = "\n" + closeIndent + unit + member + "\n" + closeIndent
+		}
+		d.splice(obj.start+1, obj.end-1, member)
+		return nil
+	}
+	head := obj.members[0]
+	spread := multiline(d.data, obj.start, head.keyStart)
+	if first {
+		text, err := jsonText(value, lineIndent(d.data, head.keyStart), unit, spread)
+		if err != nil {
+			return err
+		}
+		sep := ", "
+		if spread {
+			sep = "," + string(d.data[obj.start+1:head.keyStart])
+		}
+		d.splice(head.keyStart, head.keyStart, string(name)+": "+text+sep)
+		return nil
+	}
+	last := obj.members[len(obj.members)-1]
+	indent := lineIndent(d.data, last.keyStart)
+	text, err := jsonText(value, indent, unit, spread)
+	if err != nil {
+		return err
+	}
+	sep := ", "
+	if spread {
+		sep = ",\n" + indent
+	}
+	d.splice(last.value.end, last.value.end, sep+string(name)+": "+text)
+	return nil
+}
+
+// setList turns the array at key into list by deleting and appending items,
+// so the items that stay keep their text.
+func (d *jsonDocument) setList(key []string, list []any) error {
+	old, _ := d.get(key)
+	deleted, appended := listEdit(old.([]any), list)
+	if len(appended) > 0 {
+		root, err := d.parse()
+		if err != nil {
+			return err
+		}
+		n, i, _ := root.walk(key)
+		if err := d.appendItems(n, n.members[i].value, appended, d.indentUnit(root)); err != nil {
+			return err
+		}
+	}
+	for k := len(deleted) - 1; k >= 0; k-- {
+		root, err := d.parse()
+		if err != nil {
+			return err
+		}
+		n, i, _ := root.walk(key)
+		arr := n.members[i].value
+		j := deleted[k]
+		switch {
+		case j+1 < len(arr.items):
+			d.splice(arr.items[j].start, arr.items[j+1].start, "")
+		case j > 0:
+			d.splice(arr.items[j-1].end, arr.items[j].end, "")
+		default:
+			d.splice(arr.start+1, arr.end-1, "")
+		}
+	}
+	return nil
+}
+
+// appendItems adds items to the array arr held by obj. An empty array has
+// no layout of its own, so it takes obj's.
+func (d *jsonDocument) appendItems(obj, arr *jsonNode, items []any, unit string) error {
+	spread := multiline(d.data, arr.start, arr.end) || len(arr.items) == 0 && multiline(d.data, obj.start, obj.end)
+	indent := lineIndent(d.data, arr.end-1) + unit
+	if len(arr.items) > 0 {
+		indent = lineIndent(d.data, arr.items[len(arr.items)-1].start)
+	}
+	var b strings.Builder
+	for i, item := range items {
+		text, err := jsonText(item, indent, unit, spread)
+		if err != nil {
+			return err
+		}
+		if i > 0 || len(arr.items) > 0 {
+			b.WriteByte(',')
+			if !spread {
+				b.WriteByte(' ')
+			}
+		}
+		if spread {
+			b.WriteString("\n" + indent)
+		}
+		b.WriteString(text)
+	}
+	if len(arr.items) > 0 {
+		last := arr.items[len(arr.items)-1]
+		d.splice(last.end, last.end, b.String())
+		return nil
+	}
+	if spread {
+		b.WriteString("\n" + lineIndent(d.data, arr.end-1))
+	}
+	d.splice(arr.start+1, arr.end-1, b.String())
+	return nil
+}
+
+func (d *jsonDocument) setFirst(key string, value any) error {
+	root, err := d.parse()
+	if err != nil {
+		return err
+	}
+	if root.member(key) >= 0 {
+		return d.set([]string{key}, value)
+	}
+	return d.insertMember(root, true, key, value, d.indentUnit(root), true)
+}
+
+func (d *jsonDocument) remove(key []string) (any, bool, error) {
+	root, err := d.parse()
+	if err != nil {
+		return nil, false, err
+	}
+	n, i, _ := root.walk(key)
+	if i < 0 {
+		return nil, false, nil
+	}
+	val := n.members[i].value
+	v, err := d.decode(val)
+	if err != nil {
+		return nil, false, err
+	}
+	moved := &jsonMoved{value: v, text: string(d.data[val.start:val.end])}
+	switch {
+	case i+1 < len(n.members):
+		d.splice(n.members[i].keyStart, n.members[i+1].keyStart, "")
+	case i > 0:
+		d.splice(n.members[i-1].value.end, n.members[i].value.end, "")
+	default:
+		d.splice(n.start+1, n.end-1, "")
+	}
+	return moved, true, nil
+}
+
+func (d *jsonDocument) encode() ([]byte, error) {
+	return d.data, nil
+}
+
+// jsonText renders v to be placed at a line indented by indent: spread over
+// lines like json.MarshalIndent, or on one line with a space after every
+// colon and comma.
+func jsonText(v any, indent, unit string, spread bool) (string, error) {
+	if moved, ok := v.(*jsonMoved); ok {
+		if !strings.Contains(moved.text, "\n") {
+			return moved.text, nil
+		}
+		v = moved.value
+	}
+	v = orderedValue(v)
+	if spread {
+		raw, err := marshalJSONValue(v)
+		if err != nil {
+			return "", err
+		}
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, raw, indent, unit); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+	var parts []string
+	switch v := v.(type) {
+	case *orderedMap:
+		for _, k := range v.keys {
+			name, err := marshalJSONValue(k)
+			if err != nil {
+				return "", err
+			}
+			text, err := jsonText(v.values[k], "", "", false)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, string(name)+": "+text)
+		}
+		return "{" + strings.Join(parts, ", ") + "}", nil
+	case []any:
+		for _, item := range v {
+			text, err := jsonText(item, "", "", false)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, text)
+		}
+		return "[" + strings.Join(parts, ", ") + "]", nil
+	}
+	text, err := marshalJSONValue(v)
+	return string(text), err
+}
+
+// lineIndent returns the spaces and tabs that start the line holding
+// data[pos].
+func lineIndent(data []byte, pos int) string {
+	start := lineStart(data, pos)
+	end := start
+	for end < len(data) && (data[end] == ' ' || data[end] == '\t') {
+		end++
+	}
+	return string(data[start:end])
+}
+
+// lineStart returns the offset of the line holding data[pos].
+func lineStart(data []byte, pos int) int {
+	return bytes.LastIndexByte(data[:pos], '\n') + 1
+}
+
+// lineEnd returns the offset just past the newline ending the line holding
+// data[pos], or len(data).
+func lineEnd(data []byte, pos int) int {
+	if i := bytes.IndexByte(data[pos:], '\n'); i >= 0 {
+		return pos + i + 1
+	}
+	return len(data)
+}
+
+func multiline(data []byte, start, end int) bool {
+	return bytes.IndexByte(data[start:end], '\n') >= 0
+}
@@ -0,0 +1,888 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2/unstable"
+)
+
+// tomlDocument edits TOML text in place, like jsonDocument: a new key goes
+// next to its siblings (or into a new section at the end), a removed key
+// takes its own comments along, and every other line stays as written. An
+// edit that would have to drop comments inside a value it replaces fails
+// instead.
+type tomlDocument struct {
+	data []byte
+}
+
+// tomlSection is the root table or a [table] or [[array]] section, with the
+// comments directly above its header.
+type tomlSection struct {
+	path  []string
+	array bool // [[path]], or a table within one; not addressable by key
+	tomlLine
+	entries []tomlLine
+	next    int // where the next section, comments included, starts
+}
+
+// tomlLine is a header or key/value expression: data[start:end] holds its
+// leading comments, data[line:end] the expression up to and including the
+// newline (and a trailing comment), and data[keyStart:keyEnd] its key.
+type tomlLine struct {
+	key              []string // relative to the section for key/values
+	start, line, end int
+	keyStart, keyEnd int
+	value, valueEnd  int
+}
+
+// tomlMoved is what remove returns: the value, and the removed text so
+// that set can put it back under another key, comments included.
+type tomlMoved struct {
+	value any
+	parts []tomlMovedPart
+}
+
+type tomlMovedPart struct {
+	rel     []string // key relative to the removed one
+	section bool
+	array   bool
+	// The removed text around the key: leading comments and indentation,
+	// and the rest after it, newline included.
+	head, tail string
+}
+
+func openTOMLDocument(data []byte) (configDocument, error) {
+	// Reject invalid input with the positioned errors of the full decoder.
+	if _, err := parseTOMLConfig(data); err != nil {
+		return nil, err
+	}
+	return &tomlDocument{data: data}, nil
+}
+
+// sections splits the text into the root table and its sections.
+func (d *tomlDocument) sections() ([]*tomlSection, error) {
+	p := &unstable.Parser{}
+	p.Reset(d.data)
+	sections := []*tomlSection{{}}
+	arrays := map[string]bool{}
+	for p.NextExpression() {
+		expr := p.Expression()
+		keys := tomlKeys(expr)
+		l := tomlLine{
+			keyStart: int(keys[0].Raw.Offset),
+			keyEnd:   int(keys[len(keys)-1].Raw.Offset + keys[len(keys)-1].Raw.Length),
+		}
+		for _, k := range keys {
+			l.key = append(l.key, string(k.Data))
+		}
+		l.line = lineStart(d.data, l.keyStart)
+		l.start = commentsAbove(d.data, l.line)
+		switch expr.Kind {
+		case unstable.Table, unstable.ArrayTable:
+			l.end = lineEnd(d.data, l.keyEnd)
+			s := &tomlSection{path: l.key, tomlLine: l}
+			for i := range s.path {
+				s.array = s.array || arrays[strings.Join(s.path[:i+1], "\x00")]
+			}
+			if expr.Kind == unstable.ArrayTable {
+				arrays[strings.Join(s.path, "\x00")] = true
+				s.array = true
+			}
+			sections[len(sections)-1].next = l.start
+			sections = append(sections, s)
+		case unstable.KeyValue:
+			l.value = l.keyEnd + bytes.IndexByte(d.data[l.keyEnd:], '=') + 1
+			for d.data[l.value] == ' ' || d.data[l.value] == '\t' {
+				l.value++
+			}
+			l.valueEnd = int(expr.Raw.Offset + expr.Raw.Length)
+			l.end = lineEnd(d.data, l.valueEnd)
+			s := sections[len(sections)-1]
+			s.entries = append(s.entries, l)
+		}
+	}
+	if err := p.Error(); err != nil {
+		return nil, err
+	}
+	sections[len(sections)-1].next = len(d.data)
+	return sections, nil
+}
+
+// commentsAbove returns the start of the comment lines directly above the
+// line starting at line.
+func commentsAbove(data []byte, line int) int {
+	for line > 0 {
+		prev := lineStart(data, line-1)
+		if text := bytes.TrimSpace(data[prev:line]); len(text) == 0 || text[0] != '#' {
+			break
+		}
+		line = prev
+	}
+	return line
+}
+
+func hasPrefix(key, prefix []string) bool {
+	if len(prefix) > len(key) {
+		return false
+	}
+	for i := range prefix {
+		if key[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func fullKey(s *tomlSection, e tomlLine) []string {
+	return append(append([]string{}, s.path...), e.key...)
+}
+
+// findEntry returns the key/value whose key is key or holds it, as an
+// inline table does.
+func findEntry(sections []*tomlSection, key []string) (*tomlSection, tomlLine, bool) {
+	for _, s := range sections {
+		if s.array || !hasPrefix(key, s.path) || len(s.path) == len(key) {
+			continue
+		}
+		for _, e := range s.entries {
+			if hasPrefix(key, fullKey(s, e)) {
+				return s, e, true
+			}
+		}
+	}
+	return nil, tomlLine{}, false
+}
+
+// findSection returns the [path] section, or the root table for no path.
+func findSection(sections []*tomlSection, path []string) *tomlSection {
+	for _, s := range sections {
+		if !s.array && len(s.path) == len(path) && hasPrefix(s.path, path) {
+			return s
+		}
+	}
+	return nil
+}
+
+func tomlKeyText(key []string) string {
+	parts := make([]string, len(key))
+	for i, k := range key {
+		parts[i] = tomlKey(k)
+	}
+	return strings.Join(parts, ".")
+}
+
+func errTOMLComments(key []string) error {
+	return fmt.Errorf("cannot rewrite %s without dropping comments in it; edit the file by hand", strings.Join(key, "."))
+}
+
+func (d *tomlDocument) tables() (*orderedDocument, error) {
+	root, err := readTOMLTables(d.data)
+	if err != nil {
+		return nil, err
+	}
+	return &orderedDocument{root: root}, nil
+}
+
+func (d *tomlDocument) get(key []string) (any, bool) {
+	doc, err := d.tables()
+	if err != nil {
+		return nil, false
+	}
+	return doc.get(key)
+}
+
+func (d *tomlDocument) splice(start, end int, text string) {
+	d.data = append(d.data[:start:start], append([]byte(text), d.data[end:]...)...)
+}
+
+func (d *tomlDocument) set(key []string, value any) error {
+	moved, _ := value.(*tomlMoved)
+	if moved != nil {
+		value = moved.value
+	}
+	value = orderedValue(plainValue(value))
+	sections, err := d.sections()
+	if err != nil {
+		return err
+	}
+	if s, e, ok := findEntry(sections, key); ok {
+		if full := fullKey(s, e); len(full) < len(key) {
+			return d.setInline(e, full, key, value, false)
+		}
+		return d.replaceEntry(key, e, value)
+	}
+
+	// A table, written as sections or dotted keys, is replaced whole unless
+	// it is an array of tables that only gains or loses entries.
+	if list, ok := value.([]any); ok && isTableArray(list) && d.onlyTableArray(sections, key) {
+		return d.setTableArray(key, list)
+	}
+	before := d.data
+	if removed, ok, err := d.remove(key); err != nil {
+		return err
+	} else if ok && removed.(*tomlMoved).hasComments() {
+		d.data = before
+		return errTOMLComments(key)
+	}
+	if sections, err = d.sections(); err != nil {
+		return err
+	}
+	return d.insert(sections, key, value, moved)
+}
+
+// replaceEntry gives the key/value e (at key) a new value. Arrays keep the
+// items that stay.
+func (d *tomlDocument) replaceEntry(key []string, e tomlLine, value any) error {
+	if list, ok := value.([]any); ok && d.data[e.value] == '[' {
+		old, _ := d.get(key)
+		if items, _ := tomlArrayItems(d.data, e.value); len(items) == len(old.([]any)) {
+			return d.setList(key, old.([]any), list)
+		}
+	}
+	if tomlHasComment(d.data, e.value, e.valueEnd) {
+		return errTOMLComments(key)
+	}
+	text, err := tomlValue(value)
+	if err != nil {
+		return err
+	}
+	d.splice(e.value, e.valueEnd, text)
+	return nil
+}
+
+// setInline sets (or with remove, deletes) key inside the inline table or
+// other value held by the key/value e at full, rewriting that value.
+func (d *tomlDocument) setInline(e tomlLine, full, key []string, value any, remove bool) error {
+	doc, err := d.tables()
+	if err != nil {
+		return err
+	}
+	if remove {
+		if _, _, err := doc.remove(key); err != nil {
+			return err
+		}
+	} else if err := doc.set(key, value); err != nil {
+		return err
+	}
+	text, err := tomlValue(doc.parent(full, false).values[full[len(full)-1]])
+	if err != nil {
+		return err
+	}
+	d.splice(e.value, e.valueEnd, text)
+	return nil
+}
+
+// setList turns the inline array at key, currently old, into list by
+// deleting and appending items.
+func (d *tomlDocument) setList(key []string, old, list []any) error {
+	deleted, appended := listEdit(old, list)
+	array := func() (open int, items [][2]int, close int, err error) {
+		sections, err := d.sections()
+		if err != nil {
+			return 0, nil, 0, err
+		}
+		_, e, _ := findEntry(sections, key)
+		items, close = tomlArrayItems(d.data, e.value)
+		return e.value, items, close, nil
+	}
+	if len(appended) > 0 {
+		open, items, close, err := array()
+		if err != nil {
+			return err
+		}
+		if err := d.appendItems(open, items, close, appended); err != nil {
+			return err
+		}
+	}
+	for k := len(deleted) - 1; k >= 0; k-- {
+		open, items, close, err := array()
+		if err != nil {
+			return err
+		}
+		if err := d.deleteItem(key, open, items, close, deleted[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *tomlDocument) appendItems(open int, items [][2]int, close int, values []any) error {
+	texts := make([]string, len(values))
+	for i, v := range values {
+		text, err := tomlValue(v)
+		if err != nil {
+			return err
+		}
+		texts[i] = text
+	}
+	if !multiline(d.data, open, close) {
+		if len(items) == 0 {
+			d.splice(open+1, close, strings.Join(texts, ", "))
+		} else {
+			end := items[len(items)-1][1]
+			d.splice(end, end, ", "+strings.Join(texts, ", "))
+		}
+		return nil
+	}
+	if len(items) == 0 {
+		indent := lineIndent(d.data, close)
+		var b strings.Builder
+		for _, text := range texts {
+			b.WriteString(indent + "  " + text + ",\n")
+		}
+		pos := lineStart(d.data, close)
+		d.splice(pos, pos, b.String())
+		return nil
+	}
+	last := items[len(items)-1]
+	indent := lineIndent(d.data, last[0])
+	if !multiline(d.data, last[1], close) {
+		// The closing bracket follows the last item on its line.
+		d.splice(last[1], last[1], ",\n"+indent+strings.Join(texts, ",\n"+indent))
+		return nil
+	}
+	// Put the new items on lines of their own after the last one, keeping
+	// its trailing comment where it is and copying whether it has a comma.
+	i := last[1]
+	for d.data[i] == ' ' || d.data[i] == '\t' {
+		i++
+	}
+	comma := d.data[i] == ','
+	var b strings.Builder
+	for k, text := range texts {
+		b.WriteString(indent + text)
+		if comma || k < len(texts)-1 {
+			b.WriteByte(',')
+		}
+		b.WriteByte('\n')
+	}
+	pos := lineEnd(d.data, last[1])
+	d.splice(pos, pos, b.String())
+	if !comma {
+		d.splice(last[1], last[1], ",")
+	}
+	return nil
+}
+
+// deleteItem removes item k of the inline array at key: its lines when it
+// has them to itself, along with comments on or directly above them, else
+// just the item and one separator.
+func (d *tomlDocument) deleteItem(key []string, open int, items [][2]int, close, k int) error {
+	start, end := items[k][0], items[k][1]
+	from := lineStart(d.data, start)
+	if len(bytes.TrimSpace(d.data[from:start])) == 0 && from > open {
+		rest := bytes.TrimLeft(d.data[end:lineEnd(d.data, end)], " \t")
+		rest = bytes.TrimLeft(bytes.TrimPrefix(rest, []byte(",")), " \t")
+		if len(rest) == 0 || rest[0] == '#' || rest[0] == '\n' || rest[0] == '\r' {
+			d.splice(commentsAbove(d.data, from), lineEnd(d.data, end), "")
+			return nil
+		}
+	}
+	switch {
+	case k+1 < len(items):
+		// Comments on the following lines belong to the next item.
+		sep := d.data[end:items[k+1][0]]
+		if nl := bytes.IndexByte(sep, '\n'); nl >= 0 && tomlHasComment(sep, nl+1, len(sep)) {
+			return errTOMLComments(key)
+		}
+		d.splice(start, items[k+1][0], "")
+	case k > 0:
+		// A comment before the first line break is the previous item's.
+		sep := d.data[items[k-1][1]:start]
+		nl := bytes.IndexByte(sep, '\n')
+		if nl < 0 {
+			nl = len(sep)
+		}
+		if tomlHasComment(sep, 0, nl) {
+			return errTOMLComments(key)
+		}
+		d.splice(items[k-1][1], end, "")
+	default:
+		if tomlHasComment(d.data, open+1, close) {
+			return errTOMLComments(key)
+		}
+		d.splice(open+1, close, "")
+	}
+	return nil
+}
+
+// onlyTableArray reports whether key is written only as [[key]] sections.
+func (d *tomlDocument) onlyTableArray(sections []*tomlSection, key []string) bool {
+	found := false
+	for _, s := range sections {
+		switch {
+		case len(s.path) == len(key) && hasPrefix(s.path, key):
+			if !s.array {
+				return false
+			}
+			found = true
+		case hasPrefix(key, s.path) && !s.array:
+			for _, e := range s.entries {
+				if hasPrefix(fullKey(s, e), key) {
+					return false
+				}
+			}
+		}
+	}
+	return found
+}
+
+// setTableArray turns the [[key]] sections into list by deleting and
+// appending sections.
+func (d *tomlDocument) setTableArray(key []string, list []any) error {
+	old, _ := d.get(key)
+	deleted, appended := listEdit(old.([]any), list)
+	// elements returns the span of every [[key]] section, with the
+	// sub-tables that follow it.
+	elements := func() ([][2]int, error) {
+		sections, err := d.sections()
+		if err != nil {
+			return nil, err
+		}
+		var spans [][2]int
+		for _, s := range sections {
+			if !hasPrefix(s.path, key) {
+				continue
+			}
+			if len(s.path) == len(key) {
+				spans = append(spans, [2]int{s.start, s.next})
+			} else if len(spans) > 0 {
+				spans[len(spans)-1][1] = s.next
+			}
+		}
+		return spans, nil
+	}
+	if len(appended) > 0 {
+		spans, err := elements()
+		if err != nil {
+			return err
+		}
+		text, err := tomlTables(tomlKeyText(key), appended)
+		if err != nil {
+			return err
+		}
+		pos := trimBlankLines(d.data, spans[len(spans)-1][1])
+		prefix := "\n"
+		if pos > 0 && d.data[pos-1] != '\n' {
+			prefix = "\n\n"
+		}
+		d.splice(pos, pos, prefix+text)
+	}
+	for k := len(deleted) - 1; k >= 0; k-- {
+		spans, err := elements()
+		if err != nil {
+			return err
+		}
+		d.deleteSpan(spans[deleted[k]][0], spans[deleted[k]][1])
+	}
+	return nil
+}
+
+// trimBlankLines moves pos back over the blank lines before it.
+func trimBlankLines(data []byte, pos int) int {
+	for pos > 0 {
+		prev := lineStart(data, pos-1)
+		if len(bytes.TrimSpace(data[prev:pos])) != 0 {
+			break
+		}
+		pos = prev
+	}
+	return pos
+}
+
+// deleteSpan removes data[start:end]; at the end of the file it takes the
+// blank lines before along, so end may already lie past it when spans are
+// deleted from the last.
+func (d *tomlDocument) deleteSpan(start, end int) {
+	if end >= len(d.data) {
+		end = len(d.data)
+		start = trimBlankLines(d.data, start)
+	}
+	d.splice(start, end, "")
+}
+
+// insert adds key, which is not set, with value: scalars and inline arrays
+// after the last key of the table holding them, tables as new sections at
+// the end of the file. moved, if set, brings the text remove took out.
+func (d *tomlDocument) insert(sections []*tomlSection, key []string, value any, moved *tomlMoved) error {
+	if moved != nil && len(moved.parts) > 0 && !moved.single() && !moved.sectionsOnly() {
+		if moved.hasComments() {
+			return errTOMLComments(key)
+		}
+		moved = nil
+	}
+	if moved != nil && moved.sectionsOnly() {
+		var b strings.Builder
+		for i, part := range moved.parts {
+			if i > 0 {
+				b.WriteByte('\n')
+			}
+			b.WriteString(part.head + tomlKeyText(append(append([]string{}, key...), part.rel...)) + strings.TrimRight(part.tail, "\n") + "\n")
+		}
+		d.appendSections(b.String())
+		return nil
+	}
+	single := moved != nil && moved.single()
+	if _, isMap := value.(*orderedMap); (isMap || isTableArray(value)) && !single {
+		text, err := tomlTables(tomlKeyText(key), value)
+		if err != nil {
+			return err
+		}
+		d.appendSections(text)
+		return nil
+	}
+
+	text, err := tomlValue(value)
+	if err != nil {
+		return err
+	}
+	line := func(indent string, rel []string) string {
+		if single {
+			return moved.parts[0].head + indent + tomlKeyText(rel) + moved.parts[0].tail
+		}
+		return indent + tomlKeyText(rel) + " = " + text + "\n"
+	}
+	parent := key[:len(key)-1]
+	// Next to keys that spell out the same parent with dots, in the
+	// innermost table that has them.
+	for i := len(parent); i >= 0; i-- {
+		s := findSection(sections, parent[:i])
+		if s == nil {
+			continue
+		}
+		for k := len(s.entries) - 1; k >= 0; k-- {
+			e := s.entries[k]
+			if i == len(parent) || (len(e.key) > len(parent)-i && hasPrefix(e.key, parent[i:])) {
+				d.insertLine(e.end, line(lineIndent(d.data, e.line), key[i:]))
+				return nil
+			}
+		}
+		if i == len(parent) && i > 0 {
+			d.insertLine(s.end, line("", key[i:]))
+			return nil
+		}
+	}
+	if len(parent) == 0 {
+		// A top-level key in a file without any goes before the first
+		// section.
+		if len(sections) > 1 {
+			pos := sections[1].start
+			d.splice(pos, pos, line("", key)+"\n")
+			return nil
+		}
+		d.insertLine(len(d.data), line("", key))
+		return nil
+	}
+	d.appendSections("[" + tomlKeyText(parent) + "]\n" + line("", key[len(parent):]))
+	return nil
+}
+
+// insertLine puts text, a whole line, at pos, the start of a line or the
+// end of the file.
+func (d *tomlDocument) insertLine(pos int, text string) {
+	if pos > 0 && d.data[pos-1] != '\n' {
+		text = "\n" + text
+	}
+	d.splice(pos, pos, text)
+}
+
+// appendSections adds text at the end of the file after a blank line.
+func (d *tomlDocument) appendSections(text string) {
+	end := trimBlankLines(d.data, len(d.data))
+	switch {
+	case end == 0:
+	case d.data[end-1] == '\n':
+		text = "\n" + text
+	default:
+		text = "\n\n" + text
+	}
+	d.splice(end, len(d.data), text)
+}
+
+func (d *tomlDocument) setFirst(key string, value any) error {
+	if _, ok := d.get([]string{key}); ok {
+		return d.set([]string{key}, value)
+	}
+	text, err := tomlValue(orderedValue(value))
+	if err != nil {
+		return err
+	}
+	sections, err := d.sections()
+	if err != nil {
+		return err
+	}
+	line := tomlKey(key) + " = " + text + "\n"
+	// Below the comments opening the file, which are usually about all of
+	// it, and above the first key or section.
+	switch {
+	case len(sections[0].entries) > 0:
+		d.splice(sections[0].entries[0].line, sections[0].entries[0].line, line)
+	case len(sections) > 1:
+		d.splice(sections[1].line, sections[1].line, line+"\n")
+	default:
+		d.insertLine(len(d.data), line)
+	}
+	return nil
+}
+
+func (d *tomlDocument) remove(key []string) (any, bool, error) {
+	doc, err := d.tables()
+	if err != nil {
+		return nil, false, err
+	}
+	v, ok := doc.get(key)
+	if !ok {
+		return nil, false, nil
+	}
+	sections, err := d.sections()
+	if err != nil {
+		return nil, false, err
+	}
+	moved := &tomlMoved{value: v}
+	if s, e, ok := findEntry(sections, key); ok && len(fullKey(s, e)) < len(key) {
+		return moved, true, d.setInline(e, fullKey(s, e), key, nil, true)
+	}
+	var spans [][2]int
+	for _, s := range sections {
+		if hasPrefix(s.path, key) {
+			spans = append(spans, [2]int{s.start, s.next})
+			moved.parts = append(moved.parts, tomlMovedPart{
+				rel:     s.path[len(key):],
+				section: true,
+				array:   s.array,
+				head:    string(d.data[s.start:s.keyStart]),
+				tail:    string(d.data[s.keyEnd:s.next]),
+			})
+			continue
+		}
+		if s.array || !hasPrefix(key, s.path) {
+			continue
+		}
+		for _, e := range s.entries {
+			if full := fullKey(s, e); hasPrefix(full, key) {
+				spans = append(spans, [2]int{e.start, e.end})
+				moved.parts = append(moved.parts, tomlMovedPart{
+					rel:  full[len(key):],
+					head: string(d.data[e.start:e.line]),
+					tail: string(d.data[e.keyEnd:e.end]),
+				})
+			}
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i][0] > spans[j][0] })
+	for _, span := range spans {
+		d.deleteSpan(span[0], span[1])
+	}
+	return moved, true, nil
+}
+
+// single reports whether m is one plain key/value line.
+func (m *tomlMoved) single() bool {
+	return len(m.parts) == 1 && !m.parts[0].section && len(m.parts[0].rel) == 0
+}
+
+// sectionsOnly reports whether m is a table written only as sections.
+func (m *tomlMoved) sectionsOnly() bool {
+	for _, part := range m.parts {
+		if !part.section {
+			return false
+		}
+	}
+	return len(m.parts) > 0
+}
+
+func (m *tomlMoved) hasComments() bool {
+	for _, part := range m.parts {
+		if text := part.head + part.tail; tomlHasComment([]byte(text), 0, len(text)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *tomlDocument) encode() ([]byte, error) {
+	return d.data, nil
+}
+
+// tomlArrayItems returns the spans of the items of the inline array that
+// starts at data[open], and the offset of its closing bracket.
+func tomlArrayItems(data []byte, open int) (items [][2]int, close int) {
+	i := open + 1
+	for i < len(data) {
+		i = skipTOMLSeparators(data, i)
+		if i >= len(data) || data[i] == ']' {
+			break
+		}
+		end := tomlValueEnd(data, i)
+		items = append(items, [2]int{i, end})
+		i = end
+	}
+	return items, i
+}
+
+// skipTOMLSeparators skips whitespace, line breaks, commas and comments.
+func skipTOMLSeparators(data []byte, i int) int {
+	for i < len(data) {
+		switch data[i] {
+		case ' ', '\t', '\r', '\n', ',':
+			i++
+		case '#':
+			i = lineEnd(data, i)
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// tomlValueEnd returns the end of the value starting at data[i]; the text
+// is known to be valid.
+func tomlValueEnd(data []byte, i int) int {
+	switch data[i] {
+	case '"', '\'':
+		return tomlStringEnd(data, i)
+	case '[':
+		_, close := tomlArrayItems(data, i)
+		return min(close+1, len(data))
+	case '{':
+		for j := i + 1; j < len(data); {
+			switch data[j] {
+			case '"', '\'':
+				j = tomlStringEnd(data, j)
+			case '[', '{':
+				j = tomlValueEnd(data, j)
+			case '}':
+				return j + 1
+			default:
+				j++
+			}
+		}
+		return len(data)
+	}
+	end := i
+	for end < len(data) && !bytes.ContainsRune([]byte(",]}#\r\n"), rune(data[end])) {
+		end++
+	}
+	return i + len(bytes.TrimRight(data[i:end], " \t"))
+}
+
+// tomlStringEnd returns the end of the string starting at data[i].
+func tomlStringEnd(data []byte, i int) int {
+	quote := data[i]
+	if bytes.HasPrefix(data[i:], []byte{quote, quote, quote}) {
+		j := i + 3
+		for j < len(data) {
+			if quote == '"' && data[j] == '\\' {
+				j += 2
+				continue
+			}
+			if bytes.HasPrefix(data[j:], []byte{quote, quote, quote}) {
+				// Up to two quotes may end the content right before the
+				// delimiter.
+				j += 3
+				for k := 0; k < 2 && j < len(data) && data[j] == quote; k++ {
+					j++
+				}
+				return j
+			}
+			j++
+		}
+		return len(data)
+	}
+	for j := i + 1; j < len(data); j++ {
+		switch {
+		case quote == '"' && data[j] == '\\':
+			j++
+		case data[j] == quote:
+			return j + 1
+		}
+	}
+	return len(data)
+}
+
+// tomlHasComment reports whether data[from:to], which starts outside any
+// string, holds a comment.
+func tomlHasComment(data []byte, from, to int) bool {
+	for i := from; i < to; {
+		switch data[i] {
+		case '"', '\'':
+			i = tomlStringEnd(data, i)
+		case '#':
+			return true
+		default:
+			i++
+		}
+	}
+	return false
+}
+
+// readTOMLTables reads data into ordered maps, keeping key order.
+func readTOMLTables(data []byte) (*orderedMap, error) {
+	root := newOrderedMap()
+	p := &unstable.Parser{}
+	p.Reset(data)
+	table := root
+	for p.NextExpression() {
+		expr := p.Expression()
+		switch expr.Kind {
+		case unstable.Table, unstable.ArrayTable:
+			table = root
+			keys := tomlKeys(expr)
+			for i, key := range keys {
+				name := string(key.Data)
+				if i == len(keys)-1 && expr.Kind == unstable.ArrayTable {
+					list, _ := table.values[name].([]any)
+					next := newOrderedMap()
+					table.put(name, append(list, next), false)
+					table = next
+					break
+				}
+				switch v := table.values[name].(type) {
+				case *orderedMap:
+					table = v
+				case []any:
+					table = v[len(v)-1].(*orderedMap)
+				default:
+					next := newOrderedMap()
+					table.put(name, next, false)
+					table = next
+				}
+			}
+		case unstable.KeyValue:
+			if err := putTOMLKeyValue(table, expr); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := p.Error(); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// tomlTables renders v, a table or an array of tables, as sections at key.
+func tomlTables(key string, v any) (string, error) {
+	items, header := []any{v}, "["+key+"]\n"
+	if list, ok := v.([]any); ok {
+		items, header = list, "[["+key+"]]\n"
+	}
+	var buf bytes.Buffer
+	for _, item := range items {
+		m, ok := item.(*orderedMap)
+		if !ok {
+			return "", errors.New("expected a table")
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(header)
+		if err := writeTOMLTable(&buf, key, m); err != nil {
+			return "", err
+		}
+	}
+	return buf.String(), nil
+}
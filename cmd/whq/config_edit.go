@@ -0,0 +1,452 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	configScope string
+
+	configGetCmd = &cobra.Command{
+		Use:   "get <key>",
+		Short: "Print a configuration value (merged, or from one --scope)",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return errors.New("Usage: whq config get [--scope <scope>] <key>")
+			}
+			if !cmd.Flags().Changed("scope") {
+				return getMergedConfigValue(args[0], os.Stdout)
+			}
+			return getScopedConfigValue(configScope, args[0], os.Stdout)
+		},
+	}
+
+	configSetCmd = &cobra.Command{
+		Use:   "set <key> <value>",
+		Short: "Set a configuration value",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 2 {
+				return errors.New("Usage: whq config set [--scope <scope>] <key> <value>")
+			}
+			return setConfigValue(editScope(cmd), args[0], parseConfigArg(args[1]), os.Stdout)
+		},
+	}
+
+	configAddCmd = &cobra.Command{
+		Use:   "add <key> <value>...",
+		Short: "Append values to a configuration list",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 2 {
+				return errors.New("Usage: whq config add [--scope <scope>] <key> <value>...")
+			}
+			return addConfigValues(editScope(cmd), args[0], parseConfigArgs(args[1:]), os.Stdout)
+		},
+	}
+
+	configRemoveCmd = &cobra.Command{
+		Use:   "remove <key> [value...]",
+		Short: "Remove a configuration key, or matching items from a list",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 1 {
+				return errors.New("Usage: whq config remove [--scope <scope>] <key> [value...]")
+			}
+			return removeConfigValues(editScope(cmd), args[0], parseConfigArgs(args[1:]), os.Stdout)
+		},
+	}
+)
+
+func init() {
+	for _, c := range []*cobra.Command{configGetCmd, configSetCmd, configAddCmd, configRemoveCmd} {
+		c.Flags().StringVar(&configScope, "scope", "", "Configuration file to use: user, repo, local or env (default repo, or env when WHQ_CONFIG is set)")
+		configCmd.AddCommand(c)
+	}
+}
+
+// editScope is --scope, defaulting to the file whq would read: WHQ_CONFIG
+// when set, .whq.json otherwise.
+func editScope(cmd *cobra.Command) string {
+	if cmd.Flags().Changed("scope") {
+		return configScope
+	}
+	if strings.TrimSpace(os.Getenv("WHQ_CONFIG")) != "" {
+		return layerEnv
+	}
+	return layerRepo
+}
+
+// scopeFile returns the directory and base name a scope's file lives at.
+func scopeFile(scope string) (dir, base string, err error) {
+	switch scope {
+	case layerUser:
+		dir, err := userConfigDir()
+		return dir, "config", err
+	case layerRepo:
+		return env.RepoRoot, ".whq", nil
+	case layerLocal:
+		return env.RepoRoot, ".whq.local", nil
+	}
+	return "", "", fmt.Errorf("whq: invalid scope %q (want user, repo, local or env)", scope)
+}
+
+// scopePath returns the file of scope and whether it exists. A new file is
+// JSON.
+func scopePath(scope string) (string, bool, error) {
+	if scope == layerEnv {
+		path := strings.TrimSpace(os.Getenv("WHQ_CONFIG"))
+		if path == "" {
+			return "", false, errors.New("whq: --scope env requires WHQ_CONFIG")
+		}
+		_, err := os.Stat(path)
+		return path, err == nil, nil
+	}
+	dir, base, err := scopeFile(scope)
+	if err != nil {
+		return "", false, err
+	}
+	path, err := findConfigFile(dir, base)
+	if err != nil {
+		return "", false, err
+	}
+	if path == "" {
+		return filepath.Join(dir, base+".json"), false, nil
+	}
+	return path, true, nil
+}
+
+// scopeLayers reads the layers merged below and above scope.
+func scopeLayers(scope string) (lower, upper []*configLayer, err error) {
+	if scope == layerEnv {
+		return nil, nil, nil
+	}
+	below := true
+	for _, name := range []string{layerUser, layerRepo, layerLocal} {
+		if name == scope {
+			below = false
+			continue
+		}
+		dir, base, err := scopeFile(name)
+		if err != nil {
+			if name == layerUser {
+				continue
+			}
+			return nil, nil, err
+		}
+		layer, err := readConfigLayerIn(name, dir, base)
+		if err != nil {
+			return nil, nil, err
+		}
+		if below {
+			lower = appendLayer(lower, layer)
+		} else {
+			upper = appendLayer(upper, layer)
+		}
+	}
+	return lower, upper, nil
+}
+
+// splitConfigKey turns a dotted key such as post_add.copy into its parts.
+func splitConfigKey(key string) ([]string, error) {
+	parts := strings.Split(key, ".")
+	for _, p := range parts {
+		if p == "" {
+			return nil, fmt.Errorf("whq: invalid key %q", key)
+		}
+	}
+	return parts, nil
+}
+
+// parseConfigArg reads a command-line value as JSON, falling back to a
+// plain string: 3 and true are a number and a boolean, .env is a string.
+func parseConfigArg(arg string) any {
+	var v any
+	if err := json.Unmarshal([]byte(arg), &v); err != nil {
+		return arg
+	}
+	return v
+}
+
+func parseConfigArgs(args []string) []any {
+	out := make([]any, len(args))
+	for i, arg := range args {
+		out[i] = parseConfigArg(arg)
+	}
+	return out
+}
+
+// writeConfigValue prints strings as they are and everything else as JSON.
+func writeConfigValue(w io.Writer, v any) error {
+	if s, ok := v.(string); ok {
+		_, err := fmt.Fprintln(w, s)
+		return err
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+func getMergedConfigValue(key string, stdout io.Writer) error {
+	parts, err := splitConfigKey(key)
+	if err != nil {
+		return err
+	}
+	layers, err := configLayers(env.RepoRoot, nil)
+	if err != nil {
+		return err
+	}
+	merged, err := mergeConfigLayers(layers)
+	if err != nil {
+		return err
+	}
+	v, ok := merged.lookup(parts)
+	if !ok {
+		return fmt.Errorf("whq: %s is not set", key)
+	}
+	return writeConfigValue(stdout, v)
+}
+
+// lookup returns the merged value at key.
+func (m *mergedConfig) lookup(key []string) (any, bool) {
+	var v any = m.value
+	for _, k := range key {
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if v, ok = obj[k]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+func getScopedConfigValue(scope, key string, stdout io.Writer) error {
+	parts, err := splitConfigKey(key)
+	if err != nil {
+		return err
+	}
+	path, exists, err := scopePath(scope)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("whq: %s is not set in %s", key, path)
+	}
+	doc, err := readConfigDocument(path)
+	if err != nil {
+		return err
+	}
+	v, ok := doc.get(parts)
+	if !ok {
+		return fmt.Errorf("whq: %s is not set in %s", key, path)
+	}
+	return writeConfigValue(stdout, v)
+}
+
+func readConfigDocument(path string) (configDocument, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("whq: failed to read %s: %w", path, err)
+	}
+	return openConfigDocument(path, data)
+}
+
+// editConfigFile opens the file of scope (starting a new one at the current
+// version if needed), applies edit, and writes the result only if it is
+// valid on its own and merged with the other layers.
+func editConfigFile(scope string, edit func(doc configDocument, path string) error, stdout io.Writer) error {
+	path, exists, err := scopePath(scope)
+	if err != nil {
+		return err
+	}
+	var doc configDocument
+	if exists {
+		doc, err = readConfigDocument(path)
+	} else {
+		doc, err = openConfigDocument(path, emptyConfigDocument(path))
+		if err == nil {
+			err = doc.setFirst("version", currentConfigVersion)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := edit(doc, path); err != nil {
+		return err
+	}
+	out, err := doc.encode()
+	if err != nil {
+		return fmt.Errorf("whq: failed to encode %s: %w", path, err)
+	}
+
+	edited, err := newConfigLayer(scope, path, out)
+	if err != nil {
+		return fmt.Errorf("whq: refusing to write %s:\n%w", path, err)
+	}
+	lower, upper, err := scopeLayers(scope)
+	if err != nil {
+		return err
+	}
+	layers := append(append(lower, edited), upper...)
+	if err := checkLayers(layers); err != nil {
+		return fmt.Errorf("whq: refusing to write %s:\n%w", path, err)
+	}
+
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	} else if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("whq: failed to create %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, out, mode); err != nil {
+		return fmt.Errorf("whq: failed to write %s: %w", path, err)
+	}
+	fmt.Fprintf(stdout, "Updated %s\n", path)
+	return nil
+}
+
+// setConfigKey sets key in doc, naming path if the file cannot take the
+// edit.
+func setConfigKey(doc configDocument, path string, key []string, value any) error {
+	if err := doc.set(key, value); err != nil {
+		return fmt.Errorf("whq: %s: %w", path, err)
+	}
+	return nil
+}
+
+// emptyConfigDocument is an empty top-level object in path's format.
+func emptyConfigDocument(path string) []byte {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".toml":
+		return nil
+	}
+	return []byte("{}\n")
+}
+
+func setConfigValue(scope, key string, value any, stdout io.Writer) error {
+	parts, err := splitConfigKey(key)
+	if err != nil {
+		return err
+	}
+	return editConfigFile(scope, func(doc configDocument, path string) error {
+		return setConfigKey(doc, path, parts, value)
+	}, stdout)
+}
+
+// listTarget finds the list stored at key: the key itself, or the list
+// inside an {"$append": [...]} wrapper. ok is false when key is unset.
+func listTarget(doc configDocument, key []string, name string) (target []string, items []any, ok bool, err error) {
+	v, ok := doc.get(key)
+	if !ok {
+		return key, nil, false, nil
+	}
+	if obj, isObj := v.(map[string]any); isObj {
+		if wrapped, isList := obj[appendKey].([]any); isList && len(obj) == 1 {
+			return append(append([]string{}, key...), appendKey), wrapped, true, nil
+		}
+	}
+	switch v := v.(type) {
+	case []any:
+		return key, v, true, nil
+	case string:
+		// A single command or path where a list is accepted.
+		return key, []any{v}, true, nil
+	}
+	return nil, nil, false, fmt.Errorf("whq: %s is not a list", name)
+}
+
+// addConfigValues appends values to the list at key. When this file does
+// not set key but a lower layer does, the values are wrapped in
+// {"$append": [...]} so the lower entries are kept.
+func addConfigValues(scope, key string, values []any, stdout io.Writer) error {
+	parts, err := splitConfigKey(key)
+	if err != nil {
+		return err
+	}
+	lowerSet := false
+	if lower, _, err := scopeLayers(scope); err != nil {
+		return err
+	} else if len(lower) > 0 {
+		merged, err := mergeConfigLayers(lower)
+		if err != nil {
+			return err
+		}
+		_, lowerSet = merged.lookup(parts)
+	}
+	return editConfigFile(scope, func(doc configDocument, path string) error {
+		target, items, ok, err := listTarget(doc, parts, key)
+		if err != nil {
+			return err
+		}
+		if !ok && lowerSet {
+			return setConfigKey(doc, path, parts, map[string]any{appendKey: values})
+		}
+		return setConfigKey(doc, path, target, append(items, values...))
+	}, stdout)
+}
+
+// removeConfigValues deletes key, or with values, the list items equal to
+// any of them.
+func removeConfigValues(scope, key string, values []any, stdout io.Writer) error {
+	parts, err := splitConfigKey(key)
+	if err != nil {
+		return err
+	}
+	return editConfigFile(scope, func(doc configDocument, path string) error {
+		if len(values) == 0 {
+			_, ok, err := doc.remove(parts)
+			if err != nil {
+				return fmt.Errorf("whq: %s: %w", path, err)
+			}
+			if !ok {
+				return fmt.Errorf("whq: %s is not set in %s", key, path)
+			}
+			return nil
+		}
+		target, items, ok, err := listTarget(doc, parts, key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("whq: %s is not set in %s", key, path)
+		}
+		kept := []any{}
+		found := make([]bool, len(values))
+		for _, item := range items {
+			drop := false
+			for i, v := range values {
+				if sameConfigValue(item, v) {
+					drop, found[i] = true, true
+				}
+			}
+			if !drop {
+				kept = append(kept, item)
+			}
+		}
+		for i, v := range values {
+			if !found[i] {
+				data, _ := marshalJSONValue(v)
+				return fmt.Errorf("whq: %s not found in %s", data, key)
+			}
+		}
+		return setConfigKey(doc, path, target, kept)
+	}, stdout)
+}
+
+// sameConfigValue compares values by their JSON encoding, so numbers match
+// whichever type the file's decoder produced.
+func sameConfigValue(a, b any) bool {
+	x, errA := marshalJSONValue(a)
+	y, errB := marshalJSONValue(b)
+	return errA == nil && errB == nil && string(x) == string(y)
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func setRepoRoot(t *testing.T) string {
+	t.Helper()
+	repo := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	origEnv := env
+	env.RepoRoot = repo
+	t.Cleanup(func() { env = origEnv })
+	return repo
+}
+
+func TestConfigEditJSONKeepsKeyOrder(t *testing.T) {
+	repo := setRepoRoot(t)
+	path := filepath.Join(repo, ".whq.json")
+	writeFile(t, path, `{
+  "version": 1,
+  "post_add": {"commands": ["make"], "copy": ["a"]},
+  "$schema": "whq.schema.json"
+}
+`)
+
+	var out bytes.Buffer
+	if err := addConfigValues(layerRepo, "post_add.copy", []any{".env", "b"}, &out); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	if err := setConfigValue(layerRepo, "post_add.parallelism", parseConfigArg("2"), &out); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if err := removeConfigValues(layerRepo, "post_add.copy", []any{"a"}, &out); err != nil {
+		t.Fatalf("remove failed: %v", err)
+	}
+	if out.String() != strings.Repeat("Updated "+path+"\n", 3) {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+	want := `{
+  "version": 1,
+  "post_add": {"commands": ["make"], "copy": [".env", "b"], "parallelism": 2},
+  "$schema": "whq.schema.json"
+}
+`
+	if data, _ := os.ReadFile(path); string(data) != want {
+		t.Fatalf("unexpected file:\n%s", data)
+	}
+
+	out.Reset()
+	if err := getMergedConfigValue("post_add.copy", &out); err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if out.String() != "[\n  \".env\",\n  \"b\"\n]\n" {
+		t.Fatalf("unexpected value: %q", out.String())
+	}
+	out.Reset()
+	if err := getScopedConfigValue(layerRepo, "post_add.commands", &out); err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if out.String() != "[\n  \"make\"\n]\n" {
+		t.Fatalf("unexpected value: %q", out.String())
+	}
+}
+
+func TestConfigEditRefusesInvalidResult(t *testing.T) {
+	repo := setRepoRoot(t)
+	path := filepath.Join(repo, ".whq.json")
+	before := "{\"post_add\": {\"commands\": [{\"name\": \"a\", \"run\": \"x\"}]}}\n"
+	writeFile(t, path, before)
+
+	var out bytes.Buffer
+	err := setConfigValue(layerRepo, "post_add.parallelism", parseConfigArg("many"), &out)
+	if err == nil || !strings.Contains(err.Error(), "post_add.parallelism: expected integer, got string") {
+		t.Fatalf("expected a schema error, got %v", err)
+	}
+	err = addConfigValues(layerRepo, "post_add.commands", []any{parseConfigArg(`{"name": "b", "needs": ["c"], "run": "y"}`)}, &out)
+	if err == nil || !strings.Contains(err.Error(), `"c"`) {
+		t.Fatalf("expected an unknown step error, got %v", err)
+	}
+	if err := removeConfigValues(layerRepo, "post_add.copy", nil, &out); err == nil || !strings.Contains(err.Error(), "is not set") {
+		t.Fatalf("expected a missing key error, got %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != before {
+		t.Fatalf("file changed:\n%s", data)
+	}
+	if out.Len() != 0 {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+}
+
+func TestConfigEditYAMLKeepsComments(t *testing.T) {
+	repo := setRepoRoot(t)
+	path := filepath.Join(repo, ".whq.yaml")
+	writeFile(t, path, `# setup for new worktrees
+post_add:
+  copy:
+    - .env # secrets
+  commands: [make]
+`)
+	var out bytes.Buffer
+	if err := setConfigValue(layerRepo, "post_add.copy_options.on_tracked", "refuse", &out); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	want := `# setup for new worktrees
+post_add:
+  copy:
+    - .env # secrets
+  commands: [make]
+  copy_options:
+    on_tracked: refuse
+`
+	if data, _ := os.ReadFile(path); string(data) != want {
+		t.Fatalf("unexpected file:\n%s", data)
+	}
+}
+
+func TestConfigEditYAMLKeepsListItemComments(t *testing.T) {
+	repo := setRepoRoot(t)
+	path := filepath.Join(repo, ".whq.yaml")
+	writeFile(t, path, `post_add:
+  commands:
+    # build first
+    - make # trailing
+    - make lint # slow
+`)
+	var out bytes.Buffer
+	if err := addConfigValues(layerRepo, "post_add.commands", []any{"make x"}, &out); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	if err := removeConfigValues(layerRepo, "post_add.commands", []any{"make lint"}, &out); err != nil {
+		t.Fatalf("remove failed: %v", err)
+	}
+	want := `post_add:
+  commands:
+    # build first
+    - make # trailing
+    - make x
+`
+	if data, _ := os.ReadFile(path); string(data) != want {
+		t.Fatalf("unexpected file:\n%s", data)
+	}
+}
+
+func TestConfigEditScopes(t *testing.T) {
+	repo := setRepoRoot(t)
+	writeFile(t, filepath.Join(repo, ".whq.json"), `{"post_add": {"copy": [".env"]}}`)
+
+	var out bytes.Buffer
+	if err := addConfigValues(layerLocal, "post_add.copy", []any{".envrc"}, &out); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	local := filepath.Join(repo, ".whq.local.json")
+	want := `{
+  "version": 1,
+  "post_add": {
+    "copy": {
+      "$append": [
+        ".envrc"
+      ]
+    }
+  }
+}
+`
+	if data, _ := os.ReadFile(local); string(data) != want {
+		t.Fatalf("unexpected local file:\n%s", data)
+	}
+	// Adding again extends the wrapped list.
+	if err := addConfigValues(layerLocal, "post_add.copy", []any{"tmp/"}, &out); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	out.Reset()
+	if err := getMergedConfigValue("post_add.copy", &out); err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if out.String() != "[\n  \".env\",\n  \".envrc\",\n  \"tmp/\"\n]\n" {
+		t.Fatalf("unexpected merged value: %q", out.String())
+	}
+
+	if err := setConfigValue(layerUser, "post_add.shell", []any{"sh", "-c"}, &out); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	dir, _ := userConfigDir()
+	if _, err := os.Stat(filepath.Join(dir, "config.json")); err != nil {
+		t.Fatalf("user config not created: %v", err)
+	}
+	if err := setConfigValue("team", "post_add.parallelism", 2, &out); err == nil || !strings.Contains(err.Error(), "invalid scope") {
+		t.Fatalf("expected an invalid scope error, got %v", err)
+	}
+}
+
+func TestConfigEditJSONKeepsLayout(t *testing.T) {
+	repo := setRepoRoot(t)
+	path := filepath.Join(repo, ".whq.json")
+	before := `{
+    "$schema": "whq.schema.json",
+    "post_add": {
+        "copy": [".env"],
+        "commands": [
+            "make"
+        ]
+    }
+}
+`
+	writeFile(t, path, before)
+
+	var out bytes.Buffer
+	if err := addConfigValues(layerRepo, "post_add.copy", []any{".envrc"}, &out); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	if err := addConfigValues(layerRepo, "post_add.commands", []any{"make test"}, &out); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	if err := setConfigValue(layerRepo, "post_add.copy_options.on_tracked", "warn", &out); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	want := `{
+    "$schema": "whq.schema.json",
+    "post_add": {
+        "copy": [".env", ".envrc"],
+        "commands": [
+            "make",
+            "make test"
+        ],
+        "copy_options": {
+            "on_tracked": "warn"
+        }
+    }
+}
+`
+	if data, _ := os.ReadFile(path); string(data) != want {
+		t.Fatalf("unexpected file:\n%s", data)
+	}
+
+	// Undoing the edits gives back the original bytes.
+	if err := removeConfigValues(layerRepo, "post_add.copy_options", nil, &out); err != nil {
+		t.Fatalf("remove failed: %v", err)
+	}
+	if err := removeConfigValues(layerRepo, "post_add.commands", []any{"make test"}, &out); err != nil {
+		t.Fatalf("remove failed: %v", err)
+	}
+	if err := removeConfigValues(layerRepo, "post_add.copy", []any{".envrc"}, &out); err != nil {
+		t.Fatalf("remove failed: %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != before {
+		t.Fatalf("unexpected file:\n%s", data)
+	}
+}
+
+func TestConfigEditTOMLKeepsComments(t *testing.T) {
+	repo := setRepoRoot(t)
+	path := filepath.Join(repo, ".whq.toml")
+	before := `# Team config
+version = 1
+
+[post_add]
+# secrets
+copy = [".env"] # keep in sync with .gitignore
+commands = [
+  "make", # build first
+]
+
+[post_add.copy_options]
+on_tracked = "refuse" # never clobber
+`
+	writeFile(t, path, before)
+
+	var out bytes.Buffer
+	if err := addConfigValues(layerRepo, "post_add.copy", []any{".envrc"}, &out); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	if err := addConfigValues(layerRepo, "post_add.commands", []any{"make test"}, &out); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	if err := setConfigValue(layerRepo, "post_add.parallelism", 2, &out); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if err := setConfigValue(layerRepo, "post_add.copy_options.on_tracked", "warn", &out); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if err := setConfigValue(layerRepo, "pre_rm.commands", []any{"make clean"}, &out); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	want := `# Team config
+version = 1
+
+[post_add]
+# secrets
+copy = [".env", ".envrc"] # keep in sync with .gitignore
+commands = [
+  "make", # build first
+  "make test",
+]
+parallelism = 2
+
+[post_add.copy_options]
+on_tracked = "warn" # never clobber
+
+[pre_rm]
+commands = ["make clean"]
+`
+	if data, _ := os.ReadFile(path); string(data) != want {
+		t.Fatalf("unexpected file:\n%s", data)
+	}
+
+	if err := removeConfigValues(layerRepo, "pre_rm", nil, &out); err != nil {
+		t.Fatalf("remove failed: %v", err)
+	}
+	if err := removeConfigValues(layerRepo, "post_add.parallelism", nil, &out); err != nil {
+		t.Fatalf("remove failed: %v", err)
+	}
+	if err := removeConfigValues(layerRepo, "post_add.commands", []any{"make test"}, &out); err != nil {
+		t.Fatalf("remove failed: %v", err)
+	}
+	if err := removeConfigValues(layerRepo, "post_add.copy", []any{".envrc"}, &out); err != nil {
+		t.Fatalf("remove failed: %v", err)
+	}
+	if err := setConfigValue(layerRepo, "post_add.copy_options.on_tracked", "refuse", &out); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != before {
+		t.Fatalf("unexpected file:\n%s", data)
+	}
+}
+
+func TestConfigEditTOMLRefusesToDropComments(t *testing.T) {
+	repo := setRepoRoot(t)
+	path := filepath.Join(repo, ".whq.toml")
+	before := `[post_add.copy_options]
+on_tracked = "refuse" # never clobber
+`
+	writeFile(t, path, before)
+
+	var out bytes.Buffer
+	err := setConfigValue(layerRepo, "post_add.copy_options", map[string]any{"on_tracked": "warn"}, &out)
+	if err == nil || !strings.Contains(err.Error(), "without dropping comments") {
+		t.Fatalf("expected a comments error, got %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != before {
+		t.Fatalf("file changed:\n%s", data)
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/pelletier/go-toml/v2/unstable"
+	"gopkg.in/yaml.v3"
+)
+
+// configExtensions are the formats a configuration file may use, in the
+// order they are looked for.
+var configExtensions = []string{".json", ".yaml", ".yml", ".toml"}
+
+// position is a 1-based line and column in a configuration file.
+type position struct {
+	Line, Column int
+}
+
+// parsedConfig is a configuration file decoded into JSON-compatible values,
+// with the position of every key and list item keyed by its path (e.g.
+// "post_add.commands[1]").
+type parsedConfig struct {
+	value     map[string]any
+	positions map[string]position
+}
+
+// at returns the position of path, or of its nearest ancestor.
+func (p *parsedConfig) at(path string) (position, bool) {
+	for path != "" {
+		if pos, ok := p.positions[path]; ok {
+			return pos, true
+		}
+		if i := strings.LastIndexAny(path, ".["); i >= 0 {
+			path = path[:i]
+		} else {
+			path = ""
+		}
+	}
+	return position{}, false
+}
+
+// parseConfigFile decodes data according to the extension of name.
+func parseConfigFile(name string, data []byte) (*parsedConfig, error) {
+	var (
+		parsed *parsedConfig
+		err    error
+	)
+	switch strings.ToLower(path.Ext(name)) {
+	case ".yaml", ".yml":
+		parsed, err = parseYAMLConfig(data)
+	case ".toml":
+		parsed, err = parseTOMLConfig(data)
+	default:
+		parsed, err = parseJSONConfig(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("whq: invalid %s: %w", name, err)
+	}
+	return parsed, nil
+}
+
+func parseJSONConfig(data []byte) (*parsedConfig, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var value any
+	if err := dec.Decode(&value); err != nil {
+		var syntax *json.SyntaxError
+		if errors.As(err, &syntax) {
+			pos := offsetPosition(data, int(syntax.Offset))
+			return nil, fmt.Errorf("line %d, column %d: %w", pos.Line, pos.Column, err)
+		}
+		return nil, err
+	}
+	obj, ok := value.(map[string]any)
+	if !ok {
+		return nil, errors.New("top level must be an object")
+	}
+	positions := map[string]position{}
+	jsonPositions(data, positions)
+	return &parsedConfig{value: obj, positions: positions}, nil
+}
+
+// jsonPositions records where each key and list item starts by replaying the
+// token stream. data is known to be valid JSON.
+func jsonPositions(data []byte, positions map[string]position) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	// next skips to the start of the upcoming token.
+	next := func() position {
+		off := int(dec.InputOffset())
+		for off < len(data) && strings.IndexByte(" \t\r\n,:", data[off]) >= 0 {
+			off++
+		}
+		return offsetPosition(data, off)
+	}
+	var walk func(p string) error
+	walk = func(p string) error {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'):
+			for dec.More() {
+				pos := next()
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				child := joinConfigPath(p, fmt.Sprint(key))
+				positions[child] = pos
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+		case json.Delim('['):
+			for i := 0; dec.More(); i++ {
+				child := fmt.Sprintf("%s[%d]", p, i)
+				positions[child] = next()
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+		default:
+			return nil
+		}
+		_, err = dec.Token() // closing delimiter
+		return err
+	}
+	_ = walk("")
+}
+
+func offsetPosition(data []byte, offset int) position {
+	if offset > len(data) {
+		offset = len(data)
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	return position{Line: line, Column: offset - (bytes.LastIndexByte(before, '\n') + 1) + 1}
+}
+
+func parseYAMLConfig(data []byte) (*parsedConfig, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	var value any
+	if err := doc.Decode(&value); err != nil {
+		return nil, err
+	}
+	if value == nil {
+		value = map[string]any{}
+	}
+	normalized, err := normalizeConfigValue(value)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := normalized.(map[string]any)
+	if !ok {
+		return nil, errors.New("top level must be a mapping")
+	}
+	positions := map[string]position{}
+	yamlPositions(&doc, "", positions)
+	return &parsedConfig{value: obj, positions: positions}, nil
+}
+
+func yamlPositions(n *yaml.Node, p string, positions map[string]position) {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		for _, c := range n.Content {
+			yamlPositions(c, p, positions)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, val := n.Content[i], n.Content[i+1]
+			child := joinConfigPath(p, key.Value)
+			positions[child] = position{Line: key.Line, Column: key.Column}
+			yamlPositions(val, child, positions)
+		}
+	case yaml.SequenceNode:
+		for i, c := range n.Content {
+			child := fmt.Sprintf("%s[%d]", p, i)
+			positions[child] = position{Line: c.Line, Column: c.Column}
+			yamlPositions(c, child, positions)
+		}
+	}
+}
+
+func parseTOMLConfig(data []byte) (*parsedConfig, error) {
+	var value map[string]any
+	if err := toml.Unmarshal(data, &value); err != nil {
+		var decodeErr *toml.DecodeError
+		if errors.As(err, &decodeErr) {
+			line, col := decodeErr.Position()
+			return nil, fmt.Errorf("line %d, column %d: %w", line, col, err)
+		}
+		return nil, err
+	}
+	if value == nil {
+		value = map[string]any{}
+	}
+	normalized, err := normalizeConfigValue(value)
+	if err != nil {
+		return nil, err
+	}
+	positions := map[string]position{}
+	tomlPositions(data, positions)
+	return &parsedConfig{value: normalized.(map[string]any), positions: positions}, nil
+}
+
+// tomlPositions walks the TOML syntax tree, resolving table headers and
+// array-of-tables indices into config paths. data is known to be valid.
+func tomlPositions(data []byte, positions map[string]position) {
+	p := &unstable.Parser{}
+	p.Reset(data)
+	arrays := map[string]int{} // array-of-tables path -> entries so far
+	table := ""
+	for p.NextExpression() {
+		expr := p.Expression()
+		switch expr.Kind {
+		case unstable.Table, unstable.ArrayTable:
+			table = ""
+			keys := tomlKeys(expr)
+			for i, key := range keys {
+				table = joinConfigPath(table, string(key.Data))
+				last := i == len(keys)-1
+				positions[table] = tomlPosition(p, key)
+				n, isArray := arrays[table]
+				switch {
+				case last && expr.Kind == unstable.ArrayTable:
+					arrays[table] = n + 1
+					table = fmt.Sprintf("%s[%d]", table, n)
+					positions[table] = tomlPosition(p, key)
+				case isArray:
+					table = fmt.Sprintf("%s[%d]", table, n-1)
+				}
+			}
+		case unstable.KeyValue:
+			tomlKeyValuePositions(p, expr, table, positions)
+		}
+	}
+}
+
+func tomlKeyValuePositions(p *unstable.Parser, kv *unstable.Node, table string, positions map[string]position) {
+	keyPath := table
+	for _, key := range tomlKeys(kv) {
+		keyPath = joinConfigPath(keyPath, string(key.Data))
+		positions[keyPath] = tomlPosition(p, key)
+	}
+	tomlValuePositions(p, kv.Value(), keyPath, positions)
+}
+
+func tomlValuePositions(p *unstable.Parser, val *unstable.Node, keyPath string, positions map[string]position) {
+	switch val.Kind {
+	case unstable.InlineTable:
+		it := val.Children()
+		for it.Next() {
+			if child := it.Node(); child.Kind == unstable.KeyValue {
+				tomlKeyValuePositions(p, child, keyPath, positions)
+			}
+		}
+	case unstable.Array:
+		it := val.Children()
+		for i := 0; it.Next(); {
+			child := it.Node()
+			if child.Kind == unstable.Comment {
+				continue
+			}
+			item := fmt.Sprintf("%s[%d]", keyPath, i)
+			if child.Raw.Length > 0 {
+				positions[item] = tomlPosition(p, child)
+			}
+			tomlValuePositions(p, child, item, positions)
+			i++
+		}
+	}
+}
+
+func tomlKeys(n *unstable.Node) []*unstable.Node {
+	var keys []*unstable.Node
+	it := n.Key()
+	for it.Next() {
+		keys = append(keys, it.Node())
+	}
+	return keys
+}
+
+func tomlPosition(p *unstable.Parser, n *unstable.Node) position {
+	start := p.Shape(n.Raw).Start
+	return position{Line: start.Line, Column: start.Column}
+}
+
+// normalizeConfigValue turns decoded YAML and TOML values into the shapes
+// encoding/json produces, so every format validates and merges alike.
+// Dates and times become strings.
+func normalizeConfigValue(v any) (any, error) {
+	switch v := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, val := range v {
+			n, err := normalizeConfigValue(val)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = n
+		}
+		return out, nil
+	case map[any]any:
+		return nil, errors.New("mapping keys must be strings")
+	case []any:
+		out := make([]any, len(v))
+		for i, val := range v {
+			n, err := normalizeConfigValue(val)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = n
+		}
+		return out, nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case nil, string, bool, int, int64, uint64, float64, json.Number:
+		return v, nil
+	case encoding.TextMarshaler:
+		text, err := v.MarshalText()
+		return string(text), err
+	}
+	return fmt.Sprint(v), nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseConfigFileFormats(t *testing.T) {
+	files := map[string]string{
+		"whq.json": `{
+  "post_add": {
+    "copy": [".env"],
+    "commands": [
+      "make",
+      {"run": ["npm", "ci"], "name": "deps"}
+    ],
+    "parallelism": 2
+  }
+}`,
+		"whq.yaml": `post_add:
+  copy: [.env]
+  commands:
+    - make
+    - run: [npm, ci]
+      name: deps
+  parallelism: 2
+`,
+		"whq.toml": `[post_add]
+copy = [".env"]
+commands = [
+  "make",
+  { run = ["npm", "ci"], name = "deps" },
+]
+parallelism = 2
+`,
+	}
+	want := `{"post_add":{"commands":["make",{"name":"deps","run":["npm","ci"]}],"copy":[".env"],"parallelism":2}}`
+	for name, data := range files {
+		t.Run(name, func(t *testing.T) {
+			parsed, err := parseConfigFile(name, []byte(data))
+			if err != nil {
+				t.Fatalf("parse failed: %v", err)
+			}
+			got, err := json.Marshal(parsed.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != want {
+				t.Fatalf("value mismatch:\n got %s\nwant %s", got, want)
+			}
+			pos, ok := parsed.at("post_add.commands[1].name")
+			if !ok || pos.Line != map[string]int{"whq.json": 6, "whq.yaml": 6, "whq.toml": 5}[name] {
+				t.Fatalf("unexpected position %+v (%v)", pos, ok)
+			}
+			if pos, _ := parsed.at("post_add.parallelism"); pos.Line < 6 {
+				t.Fatalf("unexpected position for parallelism: %+v", pos)
+			}
+		})
+	}
+}
+
+func TestParseConfigFileTOMLTables(t *testing.T) {
+	parsed, err := parseConfigFile("whq.toml", []byte(`[post_add]
+parallelism = 1
+
+[[post_add.commands]]
+run = "make"
+
+[[post_add.commands]]
+run = "make test"
+[post_add.commands.when]
+branch = "main"
+`))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	for p, line := range map[string]int{
+		"post_add.commands[0].run":         5,
+		"post_add.commands[1].run":         8,
+		"post_add.commands[1].when.branch": 10,
+	} {
+		if pos, ok := parsed.at(p); !ok || pos.Line != line {
+			t.Errorf("%s: got %+v, want line %d", p, pos, line)
+		}
+	}
+}
+
+func TestParseConfigFileSyntaxErrors(t *testing.T) {
+	for name, data := range map[string]string{
+		"a.json": "{\n  \"post_add\": {,}\n}",
+		"a.yaml": "post_add:\n  - a\n  b: c\n",
+		"a.toml": "[post_add\n",
+	} {
+		_, err := parseConfigFile(name, []byte(data))
+		if err == nil || !strings.Contains(err.Error(), "line ") || !strings.Contains(err.Error(), name) {
+			t.Errorf("%s: expected an error with a line number, got %v", name, err)
+		}
+	}
+	if _, err := parseConfigFile("a.json", []byte(`["post_add"]`)); err == nil {
+		t.Fatalf("expected an error for a non-object document")
+	}
+}
+
+func TestLoadWHQConfigYAML(t *testing.T) {
+	repoRoot := t.TempDir()
+	writeFile(t, filepath.Join(repoRoot, ".whq.yaml"), "post_add:\n  commands:\n    - pnpm install\n")
+	cfg, err := loadWHQConfig(repoRoot)
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if cfg == nil || len(cfg.PostAdd.Commands) != 1 || cfg.PostAdd.Commands[0].Script != "pnpm install" {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+
+	writeFile(t, filepath.Join(repoRoot, ".whq.json"), `{}`)
+	if _, err := loadWHQConfig(repoRoot); err == nil || !strings.Contains(err.Error(), "keep only one") {
+		t.Fatalf("expected ambiguity error, got %v", err)
+	}
+}
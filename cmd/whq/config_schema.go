@@ -0,0 +1,418 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// schema is the subset of JSON Schema whq needs to describe its
+// configuration. The same tree validates configuration files and is printed
+// by `whq config schema`.
+type schema struct {
+	Ref         string             `json:"$ref,omitempty"`
+	Type        string             `json:"type,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Enum        []string           `json:"enum,omitempty"`
+	Properties  map[string]*schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	Items       *schema            `json:"items,omitempty"`
+	MinItems    int                `json:"minItems,omitempty"`
+	Minimum     *int               `json:"minimum,omitempty"`
+	Maximum     *int               `json:"maximum,omitempty"`
+	AnyOf       []*schema          `json:"anyOf,omitempty"`
+
+	// Values holds the schema of every value of an object used as a map;
+	// objects without it reject keys missing from Properties.
+	Values *schema `json:"-"`
+	// appendList marks the {"$append": [...]} form of a list.
+	appendList bool
+}
+
+func (s *schema) MarshalJSON() ([]byte, error) {
+	type plain schema
+	out := struct {
+		*plain
+		AdditionalProperties any `json:"additionalProperties,omitempty"`
+	}{plain: (*plain)(s)}
+	if s.Type == "object" {
+		if s.Values != nil {
+			out.AdditionalProperties = s.Values
+		} else {
+			out.AdditionalProperties = false
+		}
+	}
+	return json.Marshal(out)
+}
+
+// configSchemaID is where editors can fetch the schema from.
+const configSchemaID = "https://raw.githubusercontent.com/nomnel/whq/main/whq.schema.json"
+
+func ref(name string) *schema { return &schema{Ref: "#/$defs/" + name} }
+
+func stringType(desc string) *schema { return &schema{Type: "string", Description: desc} }
+
+func boolType(desc string) *schema { return &schema{Type: "boolean", Description: desc} }
+
+func enumType(desc string, values ...string) *schema {
+	return &schema{Type: "string", Description: desc, Enum: values}
+}
+
+// listOf accepts a list of items, or {"$append": [...]} to extend the list a
+// lower configuration layer set.
+func listOf(items *schema, desc string) *schema {
+	list := &schema{Type: "array", Items: items}
+	return &schema{Description: desc, AnyOf: []*schema{list, {
+		Type:        "object",
+		Description: "Append to the list from lower configuration layers",
+		Properties:  map[string]*schema{appendKey: list},
+		Required:    []string{appendKey},
+		appendList:  true,
+	}}}
+}
+
+// nullable lets an upper layer clear a section with null.
+func nullable(s *schema) *schema {
+	return &schema{AnyOf: []*schema{s, {Type: "null"}}}
+}
+
+func configDefs() map[string]*schema {
+	zero := 0
+	stringList := func(desc string) *schema {
+		s := listOf(&schema{Type: "string"}, desc)
+		s.AnyOf = append([]*schema{{Type: "string"}}, s.AnyOf...)
+		return s
+	}
+	hookProps := func() map[string]*schema {
+		return map[string]*schema{
+			"shell":       listOf(&schema{Type: "string"}, "Argv prefix string commands are appended to (default [\"bash\", \"-lc\"])"),
+			"commands":    listOf(ref("command"), "Commands to run"),
+			"parallelism": {Type: "integer", Minimum: &zero, Description: "How many commands may run at once (default 1)"},
+		}
+	}
+	copyMeta := map[string]*schema{
+		"preserve_times":  boolType("Keep modification times"),
+		"preserve_xattrs": boolType("Copy extended attributes"),
+		"preserve_owner":  boolType("Keep owner and group when permitted"),
+		"dereference":     boolType("Copy the targets of symlinks instead of the links"),
+	}
+
+	postAdd := &schema{Type: "object", Description: "Steps run after `whq add` creates a worktree", Properties: hookProps()}
+	postAdd.Properties["copy"] = listOf(ref("copyEntry"), "Files and directories copied from the main worktree")
+	copySettings := &schema{Type: "object", Description: "Settings for every copy entry", Properties: map[string]*schema{
+		"on_tracked":        enumType("What to do when a copy overwrites tracked files", onTrackedWarn, onTrackedRefuse),
+		"exclude_untracked": enumType("Whether to add untracked copies to .git/info/exclude", excludeAsk, excludeAlways, excludeNever),
+	}}
+	for k, v := range copyMeta {
+		copySettings.Properties[k] = v
+	}
+	postAdd.Properties["copy_options"] = copySettings
+
+	// Exactly one of path and from_user is checked when copying.
+	copyObject := &schema{Type: "object", Properties: map[string]*schema{}}
+	for k, v := range copyMeta {
+		copyObject.Properties[k] = v
+	}
+	copyObject.Properties["path"] = stringType("Path relative to the main worktree")
+	copyObject.Properties["from_user"] = stringType("Copy from the per-user files store instead of the main worktree")
+	copyObject.Properties["optional"] = boolType("Skip the entry when the source is missing")
+	copyObject.Properties["when"] = ref("when")
+
+	argv := &schema{Type: "array", Items: &schema{Type: "string"}, MinItems: 1}
+	return map[string]*schema{
+		"hook":      {Type: "object", Properties: hookProps()},
+		"postAdd":   postAdd,
+		"copyEntry": {AnyOf: []*schema{stringType("Path relative to the main worktree"), copyObject}},
+		"command": {AnyOf: []*schema{
+			stringType("Script run through the shell"),
+			{Type: "array", Items: &schema{Type: "string"}, MinItems: 1, Description: "Argv run without a shell"},
+			{
+				Type: "object",
+				Properties: map[string]*schema{
+					"name":  stringType("Step name other commands can depend on"),
+					"needs": stringList("Names of steps that must finish first"),
+					"when":  ref("when"),
+					"run":   {AnyOf: []*schema{stringType("Script run through the shell"), argv}},
+					"cwd":   stringType("Working directory, relative to the hook's directory"),
+					"env":   {Type: "object", Description: "Extra environment variables", Values: &schema{Type: "string"}},
+					"stdin": enumType("Where stdin comes from (default null)", stdinNull, stdinInherit),
+				},
+				Required: []string{"run"},
+			},
+		}},
+		"script": {AnyOf: []*schema{
+			stringType("Script run through bash -lc"),
+			{Type: "array", Items: &schema{Type: "string"}, MinItems: 1, Description: "Argv run without a shell"},
+			{
+				Type: "object",
+				Properties: map[string]*schema{
+					"run":         {AnyOf: []*schema{stringType("Script run through bash -lc"), argv}},
+					"description": stringType("Shown by `whq run` without arguments"),
+					"cwd":         stringType("Working directory, relative to the worktree"),
+					"env":         {Type: "object", Description: "Extra environment variables", Values: &schema{Type: "string"}},
+				},
+				Required: []string{"run"},
+			},
+			{Type: "null", Description: "Removes the script a lower layer defines"},
+		}},
+		"when": {
+			Type:        "object",
+			Description: "Run only when every condition holds",
+			Properties: map[string]*schema{
+				"branch":       stringList("Branch name globs"),
+				"branch_regex": stringType("Regular expression the branch must match"),
+				"exists":       stringList("Paths that must exist (prefix ! for must not)"),
+				"env":          stringList("Variables that must be set, or NAME=value (prefix ! to negate)"),
+				"os":           stringList("Allowed GOOS values"),
+				"arch":         stringList("Allowed GOARCH values"),
+			},
+		},
+	}
+}
+
+// configSchema describes a whole configuration file.
+func configSchema() *schema {
+	first, latest := 1, currentConfigVersion
+	return &schema{
+		Type: "object",
+		Properties: map[string]*schema{
+			"version":       {Type: "integer", Minimum: &first, Maximum: &latest, Description: "Schema version the file is written for (default 1)"},
+			"$schema":       stringType("JSON Schema for editor support"),
+			"config_source": enumType("Where worktree hooks read .whq.json from", configSourceMain, configSourceNew, configSourceBase),
+			"picker": {Type: "object", Description: "Interactive worktree picker", Properties: map[string]*schema{
+				"command": {Type: "array", Items: &schema{Type: "string"}, MinItems: 1, Description: "External picker (e.g. [\"fzf\"]) reading worktree names on stdin"},
+			}},
+			"scripts":     {Type: "object", Description: "Named commands for `whq run` (null removes one a lower layer defines)", Values: ref("script")},
+			hookPreAdd:    nullable(ref("hook")),
+			hookPostAdd:   nullable(ref("postAdd")),
+			hookPreRm:     nullable(ref("hook")),
+			hookPostRm:    nullable(ref("hook")),
+			hookPostPrune: nullable(ref("hook")),
+		},
+	}
+}
+
+// configSchemaDocument is what `whq config schema` prints.
+func configSchemaDocument() ([]byte, error) {
+	body, err := json.Marshal(configSchema())
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+	doc["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	doc["$id"] = configSchemaID
+	doc["title"] = "whq configuration"
+	doc["$defs"] = configDefs()
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// schemaError is one problem found while validating a configuration file.
+type schemaError struct {
+	Path    string
+	Message string
+}
+
+// schemaValidator checks decoded configuration against the schema.
+type schemaValidator struct {
+	defs   map[string]*schema
+	errors []schemaError
+}
+
+// validateConfig returns every problem in value, in path order.
+func validateConfig(value map[string]any) []schemaError {
+	v := &schemaValidator{defs: configDefs()}
+	v.check(configSchema(), value, "")
+	sort.SliceStable(v.errors, func(i, j int) bool { return v.errors[i].Path < v.errors[j].Path })
+	return v.errors
+}
+
+// fail records a problem with the value at p, naming p in the message.
+func (v *schemaValidator) fail(p, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if p != "" {
+		msg = p + ": " + msg
+	}
+	v.errors = append(v.errors, schemaError{Path: p, Message: msg})
+}
+
+func (v *schemaValidator) check(s *schema, value any, p string) {
+	if s.Ref != "" {
+		s = v.defs[strings.TrimPrefix(s.Ref, "#/$defs/")]
+	}
+	if len(s.AnyOf) > 0 {
+		v.checkAnyOf(s, value, p)
+		return
+	}
+	if got := jsonType(value); got != s.Type && !(s.Type == "number" && got == "integer") {
+		v.fail(p, "expected %s, got %s", s.Type, got)
+		return
+	}
+	switch value := value.(type) {
+	case map[string]any:
+		v.checkObject(s, value, p)
+	case []any:
+		if len(value) < s.MinItems {
+			v.fail(p, "needs at least %d item(s)", s.MinItems)
+		}
+		for i, item := range value {
+			v.check(s.Items, item, fmt.Sprintf("%s[%d]", p, i))
+		}
+	case string:
+		if len(s.Enum) > 0 && !containsString(s.Enum, value) {
+			v.fail(p, "must be one of %s, got %q", quoteList(s.Enum), value)
+		}
+	default:
+		n, err := strconv.ParseFloat(fmt.Sprint(value), 64)
+		if err != nil {
+			break
+		}
+		if s.Minimum != nil && n < float64(*s.Minimum) {
+			v.fail(p, "must be at least %d", *s.Minimum)
+		}
+		if s.Maximum != nil && n > float64(*s.Maximum) {
+			v.fail(p, "must be at most %d", *s.Maximum)
+		}
+	}
+}
+
+// checkAnyOf picks the alternative matching the value's JSON type, so errors
+// inside it stay precise.
+func (v *schemaValidator) checkAnyOf(s *schema, value any, p string) {
+	got := jsonType(value)
+	var types []string
+	for _, alt := range s.AnyOf {
+		resolved := alt
+		if alt.Ref != "" {
+			resolved = v.defs[strings.TrimPrefix(alt.Ref, "#/$defs/")]
+		}
+		if resolved.appendList {
+			if obj, ok := value.(map[string]any); ok {
+				if _, has := obj[appendKey]; !has {
+					v.fail(p, "expected %s, got object (use {%q: [...]} to append)", strings.Join(types, " or "), appendKey)
+					return
+				}
+			}
+		}
+		if got == resolved.Type || (resolved.Type == "number" && got == "integer") {
+			v.check(resolved, value, p)
+			return
+		}
+		if !resolved.appendList {
+			types = append(types, resolved.Type)
+		}
+	}
+	v.fail(p, "expected %s, got %s", strings.Join(types, " or "), got)
+}
+
+func (v *schemaValidator) checkObject(s *schema, obj map[string]any, p string) {
+	for _, key := range s.Required {
+		if _, ok := obj[key]; !ok {
+			v.fail(p, "missing required field %q", key)
+		}
+	}
+	for key, val := range obj {
+		child := joinConfigPath(p, key)
+		if prop, ok := s.Properties[key]; ok {
+			v.check(prop, val, child)
+			continue
+		}
+		if s.Values != nil {
+			v.check(s.Values, val, child)
+			continue
+		}
+		msg := fmt.Sprintf("unknown field %q", key)
+		if p != "" {
+			msg += " in " + p
+		}
+		if guess := closestKey(key, s.Properties); guess != "" {
+			msg += fmt.Sprintf(" (did you mean %q?)", guess)
+		}
+		v.errors = append(v.errors, schemaError{Path: child, Message: msg})
+	}
+}
+
+func jsonType(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	case int, int64, uint64:
+		return "integer"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case float64:
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func quoteList(list []string) string {
+	quoted := make([]string, len(list))
+	for i, s := range list {
+		quoted[i] = strconv.Quote(s)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// closestKey suggests a known key for a typo such as "comands" or
+// "post-add": the nearest by edit distance, if close enough.
+func closestKey(key string, props map[string]*schema) string {
+	best, bestDist := "", 3
+	normalized := strings.ReplaceAll(strings.ToLower(key), "-", "_")
+	for name := range props {
+		if d := editDistance(normalized, name); d < bestDist || (d == bestDist && best != "" && name < best) {
+			best, bestDist = name, d
+		}
+	}
+	if bestDist > len(key)/2 {
+		return ""
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
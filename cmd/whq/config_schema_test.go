@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewConfigLayerRejectsUnknownFields(t *testing.T) {
+	_, err := newConfigLayer(layerRepo, ".whq.json", []byte(`{
+  "post-add": {},
+  "pre_rm": {
+    "comands": ["x"],
+    "parallelism": "2"
+  }
+}`))
+	if err == nil {
+		t.Fatalf("expected validation errors")
+	}
+	want := []string{
+		`whq: .whq.json:2:3: unknown field "post-add" (did you mean "post_add"?)`,
+		`whq: .whq.json:4:5: unknown field "comands" in pre_rm (did you mean "commands"?)`,
+		`whq: .whq.json:5:5: pre_rm.parallelism: expected integer, got string`,
+	}
+	if got := err.Error(); got != strings.Join(want, "\n") {
+		t.Fatalf("unexpected errors:\n%s", got)
+	}
+}
+
+func TestValidateConfigForms(t *testing.T) {
+	valid := loadValue(t, `{
+		"$schema": "whq.schema.json",
+		"config_source": "new-worktree",
+		"pre_add": null,
+		"post_add": {
+			"copy": [".env", {"from_user": "key", "optional": true, "when": {"branch": "main"}}],
+			"copy_options": {"preserve_times": true, "on_tracked": "refuse"},
+			"commands": {"$append": [
+				"make",
+				["npm", "ci"],
+				{"name": "a", "needs": "b", "run": "x", "env": {"K": "v"}, "stdin": "inherit"}
+			]}
+		}
+	}`)
+	if problems := validateConfig(valid); len(problems) != 0 {
+		t.Fatalf("expected a valid config, got %+v", problems)
+	}
+
+	for doc, want := range map[string]string{
+		`{"post_add": {"commands": [{"name": "x"}]}}`:                 `post_add.commands[0]: missing required field "run"`,
+		`{"post_add": {"commands": [{"run": 1}]}}`:                    `post_add.commands[0].run: expected string or array, got integer`,
+		`{"post_add": {"commands": {"extra": []}}}`:                   `post_add.commands: expected array, got object (use {"$append": [...]} to append)`,
+		`{"post_add": {"commands": [{"run": "x", "env": {"K": 1}}]}}`: `post_add.commands[0].env.K: expected string, got integer`,
+		`{"config_source": "upstream"}`:                               `config_source: must be one of "main-worktree", "new-worktree", "base-revision", got "upstream"`,
+		`{"pre_rm": {"parallelism": -1}}`:                             `pre_rm.parallelism: must be at least 0`,
+		`{"pre_rm": []}`:                                              `pre_rm: expected object or null, got array`,
+	} {
+		problems := validateConfig(loadValue(t, doc))
+		if len(problems) != 1 || problems[0].Message != want {
+			t.Errorf("%s:\n got %+v\nwant %s", doc, problems, want)
+		}
+	}
+}
+
+func loadValue(t *testing.T, doc string) map[string]any {
+	t.Helper()
+	parsed, err := parseConfigFile("test.json", []byte(doc))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	return parsed.value
+}
+
+func TestConfigSchemaFileIsCurrent(t *testing.T) {
+	doc, err := configSchemaDocument()
+	if err != nil {
+		t.Fatalf("schema failed: %v", err)
+	}
+	committed, err := os.ReadFile(filepath.Join("..", "..", "whq.schema.json"))
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if !bytes.Equal(append(doc, '\n'), committed) {
+		t.Fatalf("whq.schema.json is stale; regenerate it with `whq config schema > whq.schema.json`")
+	}
+}
+
+func TestValidateConfigFiles(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.toml")
+	writeFile(t, good, "[post_add]\ncommands = [\"make\"]\n")
+	cycle := filepath.Join(dir, "cycle.yaml")
+	writeFile(t, cycle, "post_add:\n  commands:\n    - {name: a, needs: b, run: x}\n    - {name: b, needs: a, run: y}\n")
+
+	var out bytes.Buffer
+	err := validateConfigFiles([]string{good, cycle}, &out)
+	if err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Fatalf("expected a cycle error, got %v", err)
+	}
+	if out.String() != good+": ok\n" {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+}
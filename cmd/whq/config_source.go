@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Values for the top-level config_source setting.
+const (
+	configSourceMain = "main-worktree"
+	configSourceNew  = "new-worktree"
+	configSourceBase = "base-revision"
+)
+
+// configSourceOverride is set by `whq add --config-source`; it wins over the
+// config_source key.
+var configSourceOverride string
+
+// configSource picks where worktree-specific hooks read .whq.json from: the
+// flag, then config_source in the main worktree's file, then the main
+// worktree itself.
+func configSource(mainCfg *whqConfig) (string, error) {
+	source := configSourceOverride
+	if source == "" && mainCfg != nil {
+		source = mainCfg.ConfigSource
+	}
+	switch source {
+	case "":
+		return configSourceMain, nil
+	case configSourceMain, configSourceNew, configSourceBase:
+		return source, nil
+	}
+	return "", fmt.Errorf("whq: invalid config_source %q (want %s, %s or %s)", source, configSourceMain, configSourceNew, configSourceBase)
+}
+
+// configRevision is the commit a worktree for branch checks out: the branch
+// itself, or where `whq add` is about to create it: <remote>/<branch> when
+// only a remote has it (see remoteBranch), otherwise HEAD.
+func configRevision(repoRoot, branch string) (string, error) {
+	exists, err := branchExists(repoRoot, branch)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		return branch, nil
+	}
+	remote, err := remoteBranch(repoRoot, branch)
+	if err != nil || remote != "" {
+		return remote, err
+	}
+	return "HEAD", nil
+}
+
+// readConfigRevision reads .whq.json (or .whq.yaml, ...) as committed in rev
+// as the repo layer. A revision without one yields nil, like a missing file
+// on disk.
+func readConfigRevision(repoRoot, rev string) (*configLayer, error) {
+	var found []string
+	for _, ext := range configExtensions {
+		spec := rev + ":.whq" + ext
+		probe := exec.Command("git", "cat-file", "-e", spec)
+		probe.Dir = repoRoot
+		if probe.Run() == nil {
+			found = append(found, spec)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, nil
+	case 1:
+	default:
+		return nil, fmt.Errorf("whq: found %s; keep only one", strings.Join(found, " and "))
+	}
+	show := exec.Command("git", "show", found[0])
+	show.Dir = repoRoot
+	data, err := show.Output()
+	if err != nil {
+		return nil, fmt.Errorf("whq: failed to read %s: %w", found[0], err)
+	}
+	return newConfigLayer(layerRepo, found[0], data)
+}
+
+// loadWorktreeConfig returns the configuration hooks for one worktree use,
+// resolved according to source, and reports where its .whq.json came from
+// unless that is the main worktree. The user and local layers still apply.
+// new-worktree falls back to rev while the worktree is not checked out yet,
+// which is when pre_add runs. WHQ_CONFIG pins the main configuration.
+func loadWorktreeConfig(source string, mainCfg *whqConfig, repoRoot, worktreeRoot, rev string) (*whqConfig, error) {
+	if source == configSourceMain || os.Getenv("WHQ_CONFIG") != "" {
+		return mainCfg, nil
+	}
+	var (
+		repo   *configLayer
+		origin string
+		err    error
+	)
+	if st, statErr := os.Stat(worktreeRoot); source == configSourceNew && statErr == nil && st.IsDir() {
+		origin = filepath.Join(worktreeRoot, ".whq.json")
+		repo, err = readConfigLayerIn(layerRepo, worktreeRoot, ".whq")
+	} else {
+		origin = rev + ":.whq.json"
+		repo, err = readConfigRevision(repoRoot, rev)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if repo != nil {
+		origin = repo.Path
+	} else {
+		origin += " (not found)"
+		// Keep the user and local layers without falling back to the
+		// main worktree's .whq.json.
+		repo = &configLayer{Name: layerRepo, Path: origin, data: []byte("{}")}
+	}
+	fmt.Fprintf(os.Stdout, "Using .whq.json from %s (config_source=%s)\n", origin, source)
+	return loadConfigLayers(repoRoot, repo)
+}
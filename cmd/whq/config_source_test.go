@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigSource(t *testing.T) {
+	t.Cleanup(func() { configSourceOverride = "" })
+
+	if got, err := configSource(nil); err != nil || got != configSourceMain {
+		t.Fatalf("default: got %q, %v", got, err)
+	}
+	cfg := &whqConfig{ConfigSource: configSourceBase}
+	if got, _ := configSource(cfg); got != configSourceBase {
+		t.Fatalf("config key ignored: got %q", got)
+	}
+	configSourceOverride = configSourceNew
+	if got, _ := configSource(cfg); got != configSourceNew {
+		t.Fatalf("flag should win: got %q", got)
+	}
+	configSourceOverride = "upstream"
+	if _, err := configSource(cfg); err == nil || !strings.Contains(err.Error(), "invalid config_source") {
+		t.Fatalf("expected invalid source error, got %v", err)
+	}
+}
+
+func TestReadConfigRevision(t *testing.T) {
+	repo := initGitRepo(t)
+	if layer, err := readConfigRevision(repo, "HEAD"); err != nil || layer != nil {
+		t.Fatalf("revision without .whq.json: got %v, %v", layer, err)
+	}
+
+	runGit(t, repo, "checkout", "-q", "-b", "release")
+	writeFile(t, filepath.Join(repo, ".whq.json"), `{"post_add": {"commands": ["make old"]}}`)
+	runGit(t, repo, "add", ".whq.json")
+	runGit(t, repo, "commit", "-q", "-m", "release config")
+	runGit(t, repo, "checkout", "-q", "-")
+	writeFile(t, filepath.Join(repo, ".whq.json"), `{"post_add": {"commands": ["make new"]}}`)
+
+	layer, err := readConfigRevision(repo, "release")
+	if err != nil || layer == nil {
+		t.Fatalf("read failed: %v, %v", layer, err)
+	}
+	cfg, err := loadConfigLayers(repo, layer)
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if cfg == nil || cfg.PostAdd == nil || cfg.PostAdd.Commands[0].Script != "make old" {
+		t.Fatalf("expected the committed release config, got %+v", cfg)
+	}
+
+	if err := os.Remove(filepath.Join(repo, ".whq.json")); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, repo, "checkout", "-q", "release")
+	writeFile(t, filepath.Join(repo, ".whq.json"), "{")
+	runGit(t, repo, "commit", "-q", "-am", "break config")
+	if _, err := readConfigRevision(repo, "release"); err == nil || !strings.Contains(err.Error(), "release:.whq.json") {
+		t.Fatalf("expected parse error naming the revision, got %v", err)
+	}
+}
+
+func TestLoadWorktreeConfig(t *testing.T) {
+	repo := initGitRepo(t)
+	writeFile(t, filepath.Join(repo, ".whq.json"), `{"post_add": {"commands": ["make committed"]}}`)
+	runGit(t, repo, "add", ".whq.json")
+	runGit(t, repo, "commit", "-q", "-m", "config")
+	mainCfg := &whqConfig{PostAdd: &postAddConfig{}}
+
+	cfg, err := loadWorktreeConfig(configSourceMain, mainCfg, repo, "", "HEAD")
+	if err != nil || cfg != mainCfg {
+		t.Fatalf("main-worktree should return the main config, got %v, %v", cfg, err)
+	}
+
+	// Before checkout new-worktree reads the revision being checked out.
+	missing := filepath.Join(t.TempDir(), "feature")
+	cfg, err = loadWorktreeConfig(configSourceNew, mainCfg, repo, missing, "HEAD")
+	if err != nil || cfg == nil || cfg.PostAdd.Commands[0].Script != "make committed" {
+		t.Fatalf("expected the HEAD config, got %+v, %v", cfg, err)
+	}
+
+	worktree := t.TempDir()
+	writeFile(t, filepath.Join(worktree, ".whq.json"), `{"post_add": {"commands": ["make local"]}}`)
+	cfg, err = loadWorktreeConfig(configSourceNew, mainCfg, repo, worktree, "HEAD")
+	if err != nil || cfg == nil || cfg.PostAdd.Commands[0].Script != "make local" {
+		t.Fatalf("expected the worktree's config, got %+v, %v", cfg, err)
+	}
+
+	cfg, err = loadWorktreeConfig(configSourceBase, mainCfg, repo, worktree, "HEAD")
+	if err != nil || cfg == nil || cfg.PostAdd.Commands[0].Script != "make committed" {
+		t.Fatalf("base-revision should ignore the worktree file, got %+v, %v", cfg, err)
+	}
+}
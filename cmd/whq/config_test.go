@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeUserConfig(t *testing.T, data string) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	path := filepath.Join(dir, "whq", "config.json")
+	writeFile(t, path, data)
+	return path
+}
+
+func TestLoadWHQConfigMergesLayers(t *testing.T) {
+	writeUserConfig(t, `{
+		"post_add": {"copy": [".envrc"], "commands": ["direnv allow"], "parallelism": 2},
+		"post_prune": {"commands": ["echo pruned"]}
+	}`)
+	repoRoot := t.TempDir()
+	writeFile(t, filepath.Join(repoRoot, ".whq.json"), `{
+		"post_add": {"commands": {"$append": ["pnpm install"]}, "copy": ["app.env"]}
+	}`)
+	writeFile(t, filepath.Join(repoRoot, ".whq.local.json"), `{
+		"post_add": {"commands": {"$append": ["code ."]}},
+		"post_prune": null
+	}`)
+
+	cfg, err := loadWHQConfig(repoRoot)
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	var cmds []string
+	for _, c := range cfg.PostAdd.Commands {
+		cmds = append(cmds, c.Script)
+	}
+	if got := strings.Join(cmds, ","); got != "direnv allow,pnpm install,code ." {
+		t.Fatalf("commands should append across layers, got %s", got)
+	}
+	if len(cfg.PostAdd.Copy) != 1 || cfg.PostAdd.Copy[0].Path != "app.env" {
+		t.Fatalf("copy should be replaced by the repo layer, got %+v", cfg.PostAdd.Copy)
+	}
+	if cfg.PostAdd.Parallelism != 2 {
+		t.Fatalf("untouched keys should be kept, got parallelism %d", cfg.PostAdd.Parallelism)
+	}
+	if cfg.PostPrune != nil {
+		t.Fatalf("null should clear a lower layer's value, got %+v", cfg.PostPrune)
+	}
+}
+
+func TestLoadWHQConfigFromEnv(t *testing.T) {
+	writeUserConfig(t, `{"post_add": {"commands": ["user"]}}`)
+	repoRoot := t.TempDir()
+	writeFile(t, filepath.Join(repoRoot, ".whq.json"), `{"post_add": {"commands": ["repo"]}}`)
+	explicit := filepath.Join(t.TempDir(), "ci.json")
+	writeFile(t, explicit, `{"post_add": {"commands": {"$append": ["ci"]}}}`)
+	t.Setenv("WHQ_CONFIG", explicit)
+
+	cfg, err := loadWHQConfig(repoRoot)
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if len(cfg.PostAdd.Commands) != 1 || cfg.PostAdd.Commands[0].Script != "ci" {
+		t.Fatalf("WHQ_CONFIG should be the only layer, got %+v", cfg.PostAdd.Commands)
+	}
+
+	t.Setenv("WHQ_CONFIG", filepath.Join(t.TempDir(), "missing.json"))
+	if _, err := loadWHQConfig(repoRoot); err == nil || !strings.Contains(err.Error(), "WHQ_CONFIG") {
+		t.Fatalf("expected missing WHQ_CONFIG error, got %v", err)
+	}
+}
+
+func TestLoadWHQConfigLayerErrors(t *testing.T) {
+	userPath := writeUserConfig(t, `{"post_add": {"parallelism": "two"}}`)
+	repoRoot := t.TempDir()
+	if _, err := loadWHQConfig(repoRoot); err == nil || !strings.Contains(err.Error(), userPath) {
+		t.Fatalf("type errors should name the layer, got %v", err)
+	}
+
+	writeFile(t, userPath, `{}`)
+	writeFile(t, filepath.Join(repoRoot, ".whq.local.json"), `{"post_add": {"commands": {"$append": "x"}}}`)
+	if _, err := loadWHQConfig(repoRoot); err == nil || !strings.Contains(err.Error(), "$append") {
+		t.Fatalf("expected malformed $append error, got %v", err)
+	}
+}
+
+func TestConfigShowOrigin(t *testing.T) {
+	userPath := writeUserConfig(t, `{"post_add": {"commands": ["direnv allow"], "parallelism": 2}}`)
+	repoRoot := t.TempDir()
+	repoPath := filepath.Join(repoRoot, ".whq.json")
+	writeFile(t, repoPath, `{"post_add": {"commands": {"$append": ["pnpm install"]}}}`)
+
+	layers, err := configLayers(repoRoot, nil)
+	if err != nil {
+		t.Fatalf("layers failed: %v", err)
+	}
+	merged, err := mergeConfigLayers(layers)
+	if err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	var out bytes.Buffer
+	if err := merged.writeOrigins(&out); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	want := []string{
+		`post_add.commands[0]  "direnv allow"  user (` + userPath + `)`,
+		`post_add.commands[1]  "pnpm install"  repo (` + repoPath + `)`,
+		`post_add.parallelism  2               user (` + userPath + `)`,
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected origins:\n%s\nwant:\n%s", out.String(), strings.Join(want, "\n"))
+	}
+
+	out.Reset()
+	if err := merged.writeJSON(&out); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if !strings.Contains(out.String(), `"pnpm install"`) {
+		t.Fatalf("unexpected JSON: %s", out.String())
+	}
+}
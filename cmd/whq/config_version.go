@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/spf13/cobra"
+)
+
+// currentConfigVersion is the schema version whq writes and validates
+// against. Files without "version" are version 1.
+var currentConfigVersion = 1 + len(configMigrations)
+
+// keyMove relocates a value between two dotted key paths.
+type keyMove struct {
+	from, to string
+}
+
+// configMigration upgrades a file from version to-1 to version to.
+type configMigration struct {
+	to    int
+	moves []keyMove
+}
+
+// configMigrations lists every schema change, oldest first; the one to
+// version n is at index n-2. There are none yet.
+var configMigrations []configMigration
+
+var (
+	configMigrateCmd = &cobra.Command{
+		Use:   "migrate [file...]",
+		Short: "Rewrite configuration files to the current schema version",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			paths := args
+			if len(paths) == 0 {
+				layers, err := configLayers(env.RepoRoot, nil)
+				if err != nil {
+					return err
+				}
+				for _, layer := range layers {
+					paths = append(paths, layer.Path)
+				}
+				if len(paths) == 0 {
+					fmt.Fprintln(os.Stdout, "No configuration files found")
+					return nil
+				}
+			}
+			var errs []error
+			for _, path := range paths {
+				if err := migrateConfigFile(path, os.Stdout); err != nil {
+					errs = append(errs, err)
+				}
+			}
+			return errors.Join(errs...)
+		},
+	}
+
+	// configWarnings receives deprecation warnings; each file warns once per
+	// run even when it is loaded several times.
+	configWarnings io.Writer = os.Stderr
+	configWarned   sync.Map
+)
+
+func init() {
+	configCmd.AddCommand(configMigrateCmd)
+}
+
+// configVersion reads the "version" key of doc.
+func configVersion(doc configDocument) (int, error) {
+	v, ok := doc.get([]string{"version"})
+	if !ok {
+		return 1, nil
+	}
+	n, err := strconv.Atoi(fmt.Sprint(v))
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("version must be a positive integer, got %v", v)
+	}
+	if n > currentConfigVersion {
+		return 0, fmt.Errorf("version %d is newer than this whq supports (%d); upgrade whq", n, currentConfigVersion)
+	}
+	return n, nil
+}
+
+// migrateDocument applies the migrations newer than doc's version and
+// returns the moves it made. It leaves "version" itself alone.
+func migrateDocument(doc configDocument) (int, []keyMove, error) {
+	version, err := configVersion(doc)
+	if err != nil {
+		return 0, nil, err
+	}
+	var applied []keyMove
+	for _, m := range configMigrations {
+		if m.to <= version {
+			continue
+		}
+		for _, move := range m.moves {
+			from, to := strings.Split(move.from, "."), strings.Split(move.to, ".")
+			val, ok, err := doc.remove(from)
+			if err != nil {
+				return 0, nil, err
+			}
+			if !ok {
+				continue
+			}
+			if _, exists := doc.get(to); exists {
+				return 0, nil, fmt.Errorf("both %s and %s are set; keep only %s", move.from, move.to, move.to)
+			}
+			if err := doc.set(to, val); err != nil {
+				return 0, nil, err
+			}
+			applied = append(applied, move)
+		}
+	}
+	return version, applied, nil
+}
+
+// migrateParsedConfig upgrades a freshly parsed file in memory, moving key
+// positions along, and warns about every deprecated key it used.
+func migrateParsedConfig(path string, parsed *parsedConfig) error {
+	doc := &orderedDocument{root: orderedValue(parsed.value).(*orderedMap)}
+	version, moves, err := migrateDocument(doc)
+	if err != nil {
+		return fmt.Errorf("whq: %s: %w", path, err)
+	}
+	if _, ok := doc.get([]string{"version"}); ok && version != currentConfigVersion {
+		if err := doc.setFirst("version", currentConfigVersion); err != nil {
+			return err
+		}
+	}
+	parsed.value = plainValue(doc.root).(map[string]any)
+	for _, move := range moves {
+		for p, pos := range parsed.positions {
+			if p == move.from || strings.HasPrefix(p, move.from+".") || strings.HasPrefix(p, move.from+"[") {
+				parsed.positions[move.to+strings.TrimPrefix(p, move.from)] = pos
+			}
+		}
+		if _, warned := configWarned.LoadOrStore(path+"\x00"+move.from, true); warned {
+			continue
+		}
+		where := path
+		if pos, ok := parsed.at(move.from); ok {
+			where = fmt.Sprintf("%s:%d:%d", path, pos.Line, pos.Column)
+		}
+		fmt.Fprintf(configWarnings, "whq: warning: %s: %s is deprecated; use %s (run `whq config migrate`)\n", where, move.from, move.to)
+	}
+	return nil
+}
+
+// migrateConfigFile rewrites path in place at the current version, keeping
+// key order. Files already current are left untouched.
+func migrateConfigFile(path string, stdout io.Writer) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("whq: failed to read %s: %w", path, err)
+	}
+	doc, err := openConfigDocument(path, data)
+	if err != nil {
+		return err
+	}
+	version, _, err := migrateDocument(doc)
+	if err != nil {
+		return fmt.Errorf("whq: %s: %w", path, err)
+	}
+	if version == currentConfigVersion {
+		fmt.Fprintf(stdout, "%s: already at version %d\n", path, currentConfigVersion)
+		return nil
+	}
+	if err := doc.setFirst("version", currentConfigVersion); err != nil {
+		return fmt.Errorf("whq: %s: %w", path, err)
+	}
+	out, err := doc.encode()
+	if err != nil {
+		return fmt.Errorf("whq: failed to encode %s: %w", path, err)
+	}
+	// Never write something the loader would reject.
+	if _, err := newConfigLayer(layerRepo, path, out); err != nil {
+		return fmt.Errorf("whq: %s would not be valid after migration: %w", path, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, out, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("whq: failed to write %s: %w", path, err)
+	}
+	fmt.Fprintf(stdout, "Migrated %s from version %d to %d\n", path, version, currentConfigVersion)
+	return nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// withTestMigration pretends version 2 renamed post_add.files to
+// post_add.copy and post_add.copy_settings to post_add.copy_options.
+func withTestMigration(t *testing.T) {
+	t.Helper()
+	origMigrations, origVersion := configMigrations, currentConfigVersion
+	configMigrations = []configMigration{{to: 2, moves: []keyMove{
+		{"post_add.files", "post_add.copy"},
+		{"post_add.copy_settings", "post_add.copy_options"},
+	}}}
+	currentConfigVersion = 2
+	t.Cleanup(func() { configMigrations, currentConfigVersion = origMigrations, origVersion })
+}
+
+func TestNewConfigLayerMigratesVersion1(t *testing.T) {
+	withTestMigration(t)
+	var warnings bytes.Buffer
+	configWarnings = &warnings
+	t.Cleanup(func() { configWarnings = os.Stderr })
+
+	path := filepath.Join(t.TempDir(), ".whq.json")
+	layer, err := newConfigLayer(layerRepo, path, []byte(`{
+  "post_add": {
+    "commands": ["make"],
+    "copy_settings": {"on_tracked": "refuse"}
+  }
+}`))
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if want := `{"post_add":{"commands":["make"],"copy_options":{"on_tracked":"refuse"}}}`; string(layer.data) != want {
+		t.Fatalf("unexpected data:\n got %s\nwant %s", layer.data, want)
+	}
+	want := "whq: warning: " + path + ":4:5: post_add.copy_settings is deprecated; use post_add.copy_options (run `whq config migrate`)\n"
+	if warnings.String() != want {
+		t.Fatalf("unexpected warnings:\n got %q\nwant %q", warnings.String(), want)
+	}
+
+	// The warning is printed once per file and key.
+	warnings.Reset()
+	if _, err := newConfigLayer(layerRepo, path, []byte(`{"post_add": {"copy_settings": {"on_tracked": "refuse"}}}`)); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if warnings.Len() != 0 {
+		t.Fatalf("expected no repeated warning, got %q", warnings.String())
+	}
+}
+
+func TestNewConfigLayerVersionErrors(t *testing.T) {
+	for doc, want := range map[string]string{
+		`{"version": 2}`: "version 2 is newer than this whq supports (1); upgrade whq",
+		`{"version": 0}`: "version must be a positive integer, got 0",
+	} {
+		_, err := newConfigLayer(layerRepo, ".whq.json", []byte(doc))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected %q, got %v", doc, want, err)
+		}
+	}
+
+	withTestMigration(t)
+	for doc, want := range map[string]string{
+		`{"post_add": {"files": [".env"], "copy": [".envrc"]}}`: "both post_add.files and post_add.copy are set",
+		`{"version": 2, "post_add": {"files": [".env"]}}`:       `unknown field "files" in post_add`,
+	} {
+		_, err := newConfigLayer(layerRepo, ".whq.json", []byte(doc))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected %q, got %v", doc, want, err)
+		}
+	}
+}
+
+func TestMigrateConfigFileAtCurrentVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".whq.json")
+	before := `{"post_add": {"copy": [".env"]}}`
+	writeFile(t, path, before)
+	var out bytes.Buffer
+	if err := migrateConfigFile(path, &out); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+	if out.String() != path+": already at version 1\n" {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+	if data, _ := os.ReadFile(path); string(data) != before {
+		t.Fatalf("file should be untouched, got %s", data)
+	}
+}
+
+func TestMigrateConfigFile(t *testing.T) {
+	withTestMigration(t)
+	files := map[string]struct{ before, after string }{
+		".whq.json": {
+			before: `{
+  "$schema": "whq.schema.json",
+  "post_add": {
+    "files": [".env"],
+    "copy_settings": {"preserve_times": true},
+    "commands": ["make"]
+  }
+}
+`,
+			after: `{
+  "version": 2,
+  "$schema": "whq.schema.json",
+  "post_add": {
+    "commands": ["make"],
+    "copy": [".env"],
+    "copy_options": {"preserve_times": true}
+  }
+}
+`,
+		},
+		".whq.yaml": {
+			before: `# worktree setup
+post_add:
+  files: [.env] # secrets
+  commands:
+    - make
+`,
+			after: `# worktree setup
+version: 2
+post_add:
+  commands:
+    - make
+  copy: [.env] # secrets
+`,
+		},
+		".whq.toml": {
+			before: `# worktree setup
+[post_add]
+files = [".env"] # secrets
+
+# never clobber tracked files
+[post_add.copy_settings]
+on_tracked = "refuse"
+
+[[post_add.commands]]
+run = "make"
+`,
+			after: `# worktree setup
+version = 2
+
+[post_add]
+copy = [".env"] # secrets
+
+[[post_add.commands]]
+run = "make"
+
+# never clobber tracked files
+[post_add.copy_options]
+on_tracked = "refuse"
+`,
+		},
+	}
+	for name, tc := range files {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			writeFile(t, path, tc.before)
+			var out bytes.Buffer
+			if err := migrateConfigFile(path, &out); err != nil {
+				t.Fatalf("migrate failed: %v", err)
+			}
+			if out.String() != "Migrated "+path+" from version 1 to 2\n" {
+				t.Fatalf("unexpected output: %q", out.String())
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tc.after {
+				t.Fatalf("unexpected result:\n%s\nwant:\n%s", data, tc.after)
+			}
+
+			out.Reset()
+			if err := migrateConfigFile(path, &out); err != nil {
+				t.Fatalf("second migrate failed: %v", err)
+			}
+			if out.String() != path+": already at version 2\n" {
+				t.Fatalf("unexpected output: %q", out.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// copyStrategy selects how file contents are transferred.
+type copyStrategy int
+
+const (
+	// copyStrategyAuto tries a copy-on-write clone first and falls back to
+	// copyStrategyRange when the filesystem does not support it.
+	copyStrategyAuto copyStrategy = iota
+	// copyStrategyRange copies via (*os.File).ReadFrom, which uses
+	// copy_file_range(2)/sendfile(2) where available.
+	copyStrategyRange
+	// copyStrategyStream copies through a userspace buffer.
+	copyStrategyStream
+)
+
+const (
+	maxCopyWorkers       = 8
+	copyProgressInterval = time.Second
+)
+
+type copyOptions struct {
+	Workers  int
+	Strategy copyStrategy
+
+	// PreserveTimes keeps modification times of files, directories and
+	// symlinks.
+	PreserveTimes bool
+	// PreserveXattrs copies extended attributes where the platform supports
+	// them.
+	PreserveXattrs bool
+	// PreserveOwner keeps uid/gid when the process is permitted to chown.
+	PreserveOwner bool
+	// Dereference copies what symlinks point to instead of the links.
+	Dereference bool
+}
+
+func (o copyOptions) stat(path string) (fs.FileInfo, error) {
+	if o.Dereference {
+		return os.Stat(path)
+	}
+	return os.Lstat(path)
+}
+
+// copier copies files and directory trees, tracking how much was written.
+type copier struct {
+	opts    copyOptions
+	files   atomic.Int64
+	bytes   atomic.Int64
+	noClone atomic.Bool
+}
+
+func defaultCopyWorkers() int {
+	return min(max(runtime.NumCPU(), 1), maxCopyWorkers)
+}
+
+func newCopier(opts copyOptions) *copier {
+	if opts.Workers < 1 {
+		opts.Workers = defaultCopyWorkers()
+	}
+	return &copier{opts: opts}
+}
+
+func (c *copier) copyPath(src, dest string) error {
+	info, err := c.opts.stat(src)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return c.copySymlink(src, dest, info)
+	}
+	if info.IsDir() {
+		return c.copyDirectory(src, dest)
+	}
+	return c.copyFile(src, dest, info)
+}
+
+type copyJob struct {
+	src, dest string
+	info      fs.FileInfo
+}
+
+type copiedDir struct {
+	src, dest string
+	info      fs.FileInfo
+}
+
+// copyDirectory recreates the directory structure of src under dest while a
+// bounded pool of workers copies regular files.
+func (c *copier) copyDirectory(src, dest string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("source is not a directory: %s", src)
+	}
+	if err := os.RemoveAll(dest); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.MkdirAll(dest, info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+		jobs     = make(chan copyJob)
+		stop     = make(chan struct{})
+	)
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			close(stop)
+		})
+	}
+	for range c.opts.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				if err := c.copyFile(job.src, job.dest, job.info); err != nil {
+					fail(err)
+				}
+			}
+		}()
+	}
+
+	dirs := []copiedDir{{src: src, dest: dest, info: info}}
+	// WalkDir does not descend into a linked root, which src is when a
+	// dereferenced entry names a link to a directory.
+	root, walkErr := filepath.EvalSymlinks(src)
+	if walkErr == nil {
+		walkErr = c.walkTree(root, dest, jobs, stop, &dirs, map[string]bool{root: true})
+	}
+	close(jobs)
+	wg.Wait()
+
+	if walkErr != nil {
+		return walkErr
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	// Writing children bumps a directory's mtime, so restore metadata
+	// deepest-first once every file is in place.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := c.copyMetadata(dirs[i].src, dirs[i].dest, dirs[i].info, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkTree creates directories and symlinks under dest and queues regular
+// files on jobs. With Dereference set it descends into linked directories,
+// refusing to enter one it is already inside (onPath holds the linked
+// directories between the root and src). Two links to the same directory
+// are copied twice.
+func (c *copier) walkTree(src, dest string, jobs chan<- copyJob, stop <-chan struct{}, dirs *[]copiedDir, onPath map[string]bool) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if path == src {
+			return nil
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 && c.opts.Dereference {
+			if info, err = os.Stat(path); err != nil {
+				return err
+			}
+			if info.IsDir() {
+				real, err := filepath.EvalSymlinks(path)
+				if err != nil {
+					return err
+				}
+				if onPath[real] {
+					return fmt.Errorf("symlink cycle at %s", path)
+				}
+				if err := os.MkdirAll(target, info.Mode().Perm()); err != nil {
+					return err
+				}
+				*dirs = append(*dirs, copiedDir{src: path, dest: target, info: info})
+				onPath[real] = true
+				err = c.walkTree(real, target, jobs, stop, dirs, onPath)
+				delete(onPath, real)
+				return err
+			}
+		}
+		if info.IsDir() {
+			*dirs = append(*dirs, copiedDir{src: path, dest: target, info: info})
+			return os.MkdirAll(target, info.Mode().Perm())
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return c.copySymlink(path, target, info)
+		}
+		select {
+		case jobs <- copyJob{src: path, dest: target, info: info}:
+			return nil
+		case <-stop:
+			return filepath.SkipAll
+		}
+	})
+}
+
+func (c *copier) copyFile(src, dest string, info fs.FileInfo) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dest); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = out.Close()
+	}()
+
+	n, err := c.copyContents(out, in)
+	if err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := c.copyMetadata(src, dest, info, false); err != nil {
+		return err
+	}
+	c.files.Add(1)
+	c.bytes.Add(n)
+	return nil
+}
+
+func (c *copier) copyContents(out, in *os.File) (int64, error) {
+	switch c.opts.Strategy {
+	case copyStrategyAuto:
+		if !c.noClone.Load() {
+			if err := cloneFile(out, in); err == nil {
+				info, err := in.Stat()
+				if err != nil {
+					return 0, err
+				}
+				return info.Size(), nil
+			}
+			// Unsupported here (other filesystem, cross-device, ...): stop
+			// trying and use the regular path for the rest of this copy.
+			c.noClone.Store(true)
+		}
+		return io.Copy(out, in)
+	case copyStrategyRange:
+		return io.Copy(out, in)
+	default:
+		// Hide ReadFrom/WriteTo so io.Copy cannot take the kernel fast path.
+		return io.Copy(struct{ io.Writer }{out}, struct{ io.Reader }{in})
+	}
+}
+
+func (c *copier) copySymlink(src, dest string, info fs.FileInfo) error {
+	if err := os.RemoveAll(dest); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	target, err := os.Readlink(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	if err := os.Symlink(target, dest); err != nil {
+		return err
+	}
+	return c.copyMetadata(src, dest, info, true)
+}
+
+// copyMetadata applies the metadata selected in c.opts from src to dest.
+// Times go last because changing ownership or attributes may touch them.
+func (c *copier) copyMetadata(src, dest string, info fs.FileInfo, link bool) error {
+	if c.opts.PreserveXattrs {
+		if err := copyXattrs(src, dest, link); err != nil {
+			return fmt.Errorf("copy extended attributes of %s: %w", src, err)
+		}
+	}
+	if c.opts.PreserveOwner {
+		if err := copyOwner(dest, info); err != nil {
+			return fmt.Errorf("copy ownership of %s: %w", src, err)
+		}
+	}
+	if c.opts.PreserveTimes {
+		if err := copyModTime(dest, info, link); err != nil {
+			return fmt.Errorf("copy modification time of %s: %w", src, err)
+		}
+	}
+	return nil
+}
+
+// reportProgress prints running totals to w every interval until the returned
+// function is called. Copies finishing within the first interval stay silent.
+func (c *copier) reportProgress(w io.Writer, label string, interval time.Duration) func() {
+	start := time.Now()
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	var reported bool
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				reported = true
+				fmt.Fprintf(w, "Post-add copy: %s ... %s\n", label, c.summary())
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+		if reported {
+			fmt.Fprintf(w, "Post-add copy: %s done, %s in %s\n", label, c.summary(), time.Since(start).Round(100*time.Millisecond))
+		}
+	}
+}
+
+func (c *copier) summary() string {
+	return fmt.Sprintf("%d files, %s", c.files.Load(), formatBytes(c.bytes.Load()))
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCopierCopiesTreeWithAllStrategies(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		strategy copyStrategy
+		workers  int
+	}{
+		{"auto", copyStrategyAuto, 4},
+		{"range", copyStrategyRange, 4},
+		{"stream-serial", copyStrategyStream, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			src := t.TempDir()
+			writeFile(t, filepath.Join(src, "a.txt"), "alpha")
+			writeFile(t, filepath.Join(src, "nested", "deep", "b.txt"), "beta")
+			if err := os.Symlink("a.txt", filepath.Join(src, "link")); err != nil {
+				t.Fatalf("symlink failed: %v", err)
+			}
+			dest := filepath.Join(t.TempDir(), "out")
+
+			c := newCopier(copyOptions{Workers: tc.workers, Strategy: tc.strategy})
+			if err := c.copyPath(src, dest); err != nil {
+				t.Fatalf("copy failed: %v", err)
+			}
+
+			got, err := os.ReadFile(filepath.Join(dest, "nested", "deep", "b.txt"))
+			if err != nil || string(got) != "beta" {
+				t.Fatalf("nested file mismatch: %q, %v", got, err)
+			}
+			target, err := os.Readlink(filepath.Join(dest, "link"))
+			if err != nil || target != "a.txt" {
+				t.Fatalf("symlink mismatch: %q, %v", target, err)
+			}
+			if c.files.Load() != 2 || c.bytes.Load() != int64(len("alpha")+len("beta")) {
+				t.Fatalf("unexpected stats: %s", c.summary())
+			}
+		})
+	}
+}
+
+func TestCopierPreservesModTimes(t *testing.T) {
+	src := t.TempDir()
+	file := filepath.Join(src, "pkg", "cache.o")
+	writeFile(t, file, "obj")
+	old := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, p := range []string{file, filepath.Join(src, "pkg"), src} {
+		if err := os.Chtimes(p, old, old); err != nil {
+			t.Fatalf("chtimes failed: %v", err)
+		}
+	}
+	dest := filepath.Join(t.TempDir(), "out")
+
+	c := newCopier(copyOptions{PreserveTimes: true, PreserveXattrs: true, PreserveOwner: true})
+	if err := c.copyPath(src, dest); err != nil {
+		t.Fatalf("copy failed: %v", err)
+	}
+	for _, p := range []string{dest, filepath.Join(dest, "pkg"), filepath.Join(dest, "pkg", "cache.o")} {
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatalf("stat failed: %v", err)
+		}
+		if !info.ModTime().Equal(old) {
+			t.Fatalf("mtime of %s not preserved: %v", p, info.ModTime())
+		}
+	}
+}
+
+func TestCopierDereferencesSymlinks(t *testing.T) {
+	outside := t.TempDir()
+	writeFile(t, filepath.Join(outside, "shared", "data.txt"), "shared")
+	src := t.TempDir()
+	if err := os.Symlink(filepath.Join(outside, "shared"), filepath.Join(src, "shared")); err != nil {
+		t.Fatalf("symlink failed: %v", err)
+	}
+	if err := os.Symlink(src, filepath.Join(src, "loop")); err != nil {
+		t.Fatalf("symlink failed: %v", err)
+	}
+
+	c := newCopier(copyOptions{Dereference: true})
+	err := c.copyPath(src, filepath.Join(t.TempDir(), "cyclic"))
+	if err == nil || !strings.Contains(err.Error(), "symlink cycle") {
+		t.Fatalf("expected cycle error, got %v", err)
+	}
+
+	if err := os.Remove(filepath.Join(src, "loop")); err != nil {
+		t.Fatalf("remove failed: %v", err)
+	}
+	dest := filepath.Join(t.TempDir(), "out")
+	if err := newCopier(copyOptions{Dereference: true}).copyPath(src, dest); err != nil {
+		t.Fatalf("copy failed: %v", err)
+	}
+	info, err := os.Lstat(filepath.Join(dest, "shared"))
+	if err != nil || !info.IsDir() {
+		t.Fatalf("expected real directory, got %v, %v", info, err)
+	}
+	got, err := os.ReadFile(filepath.Join(dest, "shared", "data.txt"))
+	if err != nil || string(got) != "shared" {
+		t.Fatalf("dereferenced file mismatch: %q, %v", got, err)
+	}
+}
+
+func TestCopierDereferencesSharedTargets(t *testing.T) {
+	outside := t.TempDir()
+	writeFile(t, filepath.Join(outside, "shared", "data.txt"), "shared")
+	src := t.TempDir()
+	// A diamond, not a cycle: both links lead to the same directory.
+	for _, name := range []string{"a", "b"} {
+		if err := os.Symlink(filepath.Join(outside, "shared"), filepath.Join(src, name)); err != nil {
+			t.Fatalf("symlink failed: %v", err)
+		}
+	}
+	dest := filepath.Join(t.TempDir(), "out")
+	if err := newCopier(copyOptions{Dereference: true}).copyPath(src, dest); err != nil {
+		t.Fatalf("copy failed: %v", err)
+	}
+	for _, name := range []string{"a", "b"} {
+		got, err := os.ReadFile(filepath.Join(dest, name, "data.txt"))
+		if err != nil || string(got) != "shared" {
+			t.Fatalf("%s: dereferenced file mismatch: %q, %v", name, got, err)
+		}
+	}
+}
+
+func TestCopierDereferencesLinkedRoot(t *testing.T) {
+	outside := t.TempDir()
+	writeFile(t, filepath.Join(outside, "modules", "pkg", "index.js"), "module")
+	src := filepath.Join(t.TempDir(), "node_modules")
+	if err := os.Symlink(filepath.Join(outside, "modules"), src); err != nil {
+		t.Fatalf("symlink failed: %v", err)
+	}
+	dest := filepath.Join(t.TempDir(), "node_modules")
+	if err := newCopier(copyOptions{Dereference: true}).copyPath(src, dest); err != nil {
+		t.Fatalf("copy failed: %v", err)
+	}
+	if info, err := os.Lstat(dest); err != nil || !info.IsDir() {
+		t.Fatalf("expected real directory, got %v, %v", info, err)
+	}
+	got, err := os.ReadFile(filepath.Join(dest, "pkg", "index.js"))
+	if err != nil || string(got) != "module" {
+		t.Fatalf("dereferenced file mismatch: %q, %v", got, err)
+	}
+}
+
+func TestCopierReportsFailureFromWorkers(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, filepath.Join(src, "ok.txt"), "ok")
+	unreadable := filepath.Join(src, "secret.txt")
+	writeFile(t, unreadable, "nope")
+	if err := os.Chmod(unreadable, 0); err != nil {
+		t.Fatalf("chmod failed: %v", err)
+	}
+	if f, err := os.Open(unreadable); err == nil {
+		f.Close()
+		t.Skip("running with privileges that bypass file permissions")
+	}
+
+	c := newCopier(copyOptions{Workers: 2})
+	if err := c.copyPath(src, filepath.Join(t.TempDir(), "out")); err == nil {
+		t.Fatalf("expected error for unreadable file")
+	}
+}
+
+func TestCopierProgressSilentForShortCopies(t *testing.T) {
+	var out bytes.Buffer
+	c := newCopier(copyOptions{})
+	stop := c.reportProgress(&out, "tree", time.Hour)
+	stop()
+	if out.Len() != 0 {
+		t.Fatalf("expected no progress output, got %q", out.String())
+	}
+
+	c.files.Store(3)
+	c.bytes.Store(3 << 20)
+	stop = c.reportProgress(&out, "tree", time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	stop()
+	if !strings.Contains(out.String(), "Post-add copy: tree done, 3 files, 3.0 MiB") {
+		t.Fatalf("missing final progress line: %q", out.String())
+	}
+}
+
+func BenchmarkCopyTree(b *testing.B) {
+	src := b.TempDir()
+	payload := bytes.Repeat([]byte("x"), 64<<10)
+	for d := range 16 {
+		for f := range 32 {
+			path := filepath.Join(src, fmt.Sprintf("pkg%02d", d), fmt.Sprintf("file%02d.bin", f))
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				b.Fatal(err)
+			}
+			if err := os.WriteFile(path, payload, 0o644); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	destRoot := b.TempDir()
+
+	for _, bc := range []struct {
+		name     string
+		strategy copyStrategy
+		workers  int
+	}{
+		{"stream-serial", copyStrategyStream, 1},
+		{"stream-parallel", copyStrategyStream, maxCopyWorkers},
+		{"range-serial", copyStrategyRange, 1},
+		{"range-parallel", copyStrategyRange, maxCopyWorkers},
+		{"auto-parallel", copyStrategyAuto, maxCopyWorkers},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.SetBytes(int64(16 * 32 * len(payload)))
+			for i := 0; b.Loop(); i++ {
+				c := newCopier(copyOptions{Workers: bc.workers, Strategy: bc.strategy})
+				dest := filepath.Join(destRoot, fmt.Sprintf("%s-%d", bc.name, i))
+				if err := c.copyPath(src, dest); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
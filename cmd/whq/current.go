@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+var currentCmd = &cobra.Command{
+	Use:   "current",
+	Short: "Print the worktree containing the working directory",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 0 {
+			return errors.New("Usage: whq current")
+		}
+		wt, err := currentWorktree()
+		if err != nil {
+			return err
+		}
+		return printWorktree(wt, os.Stdout)
+	},
+}
+
+// currentWorktree is the worktree containing the working directory.
+func currentWorktree() (worktreeItem, error) {
+	items, err := worktreeItems()
+	if err != nil {
+		return worktreeItem{}, err
+	}
+	var best worktreeItem
+	for _, item := range items {
+		// The deepest one wins: worktrees may live inside the main one.
+		if cwdWithin(item.path) && len(item.path) > len(best.path) {
+			best = item
+		}
+	}
+	if best.path == "" {
+		return worktreeItem{}, errors.New("whq: the working directory is not inside a worktree of this repository")
+	}
+	return best, nil
+}
+
+// printWorktree describes wt as `whq current` does: its name as `whq list`
+// prints it, its branch and its path, one per line.
+func printWorktree(wt worktreeItem, w io.Writer) error {
+	branch := wt.branch
+	if branch == "" {
+		branch = "(detached)"
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "worktree\t%s\nbranch\t%s\npath\t%s\n", wt.label, branch, wt.path)
+	return tw.Flush()
+}
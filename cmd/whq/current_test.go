@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCurrentWorktree(t *testing.T) {
+	repoRoot, whqRoot := setupResolveRepo(t)
+	t.Chdir(filepath.Join(whqRoot, "repo", "hotfix"))
+	if wt, err := currentWorktree(); err != nil || wt.label != "hotfix" {
+		t.Fatalf("unexpected worktree %+v, %v", wt, err)
+	}
+	t.Chdir(repoRoot)
+	if wt, err := currentWorktree(); err != nil || wt.label != "@" {
+		t.Fatalf("unexpected worktree %+v, %v", wt, err)
+	}
+}
+
+func TestPrintWorktree(t *testing.T) {
+	var out bytes.Buffer
+	if err := printWorktree(worktreeItem{label: "hotfix", path: "/wt/hotfix"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if want := "worktree  hotfix\nbranch    (detached)\npath      /wt/hotfix\n"; out.String() != want {
+		t.Fatalf("unexpected output %q, want %q", out.String(), want)
+	}
+}
+
+func TestRmCurrentWorktree(t *testing.T) {
+	repoRoot, whqRoot := setupResolveRepo(t)
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	origForce, origBranch := rmForce, rmBranch
+	t.Cleanup(func() { rmForce, rmBranch = origForce, origBranch })
+	rmForce, rmBranch = false, false
+	cdFile := filepath.Join(t.TempDir(), "cd")
+	t.Setenv(cdFileEnv, cdFile)
+
+	t.Chdir(repoRoot)
+	if err := rmCmd.RunE(rmCmd, nil); err == nil || err.Error() != "whq: refusing to remove the main worktree" {
+		t.Fatalf("expected a refusal, got %v", err)
+	}
+	hotfix := filepath.Join(whqRoot, "repo", "hotfix")
+	t.Chdir(hotfix)
+	if err := rmCmd.RunE(rmCmd, nil); err != nil {
+		t.Fatalf("rm failed: %v", err)
+	}
+	if _, err := os.Stat(hotfix); !os.IsNotExist(err) {
+		t.Fatalf("worktree should be removed, err=%v", err)
+	}
+	if data, err := os.ReadFile(cdFile); err != nil || string(data) != repoRoot {
+		t.Fatalf("expected a cd to %s, got %q, %v", repoRoot, data, err)
+	}
+}
+
+func TestPathDefaultsToCurrentWorktree(t *testing.T) {
+	repoRoot, whqRoot := setupResolveRepo(t)
+	origInteractive := promptInteractive
+	promptInteractive = func() bool { return false }
+	t.Cleanup(func() { promptInteractive = origInteractive })
+
+	t.Chdir(t.TempDir())
+	if err := pathCmd.RunE(pathCmd, nil); err == nil || err.Error() != "whq: the working directory is not inside a worktree of this repository" {
+		t.Fatalf("expected an error outside worktrees, got %v", err)
+	}
+	hotfix := filepath.Join(whqRoot, "repo", "hotfix")
+	t.Chdir(hotfix)
+	if err := pathCmd.RunE(pathCmd, nil); err != nil {
+		t.Fatalf("path failed: %v", err)
+	}
+	state, err := loadWorktreeState()
+	if err != nil || state.Repos[repoRoot] == nil || state.Repos[repoRoot].Current != hotfix {
+		t.Fatalf("the current worktree should be recorded, got %+v, %v", state, err)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Values for post_add.copy_options.on_tracked.
+const (
+	onTrackedWarn   = "warn"
+	onTrackedRefuse = "refuse"
+)
+
+// Values for post_add.copy_options.exclude_untracked.
+const (
+	excludeAsk    = "ask"
+	excludeAlways = "always"
+	excludeNever  = "never"
+)
+
+type copyDestState int
+
+const (
+	copyDestIgnored copyDestState = iota
+	copyDestUntracked
+	copyDestTracked
+)
+
+func validateCopyHygiene(cfg *postAddConfig) error {
+	opts := cfg.CopyOptions
+	switch opts.OnTracked {
+	case "", onTrackedWarn, onTrackedRefuse:
+	default:
+		return fmt.Errorf("whq: invalid post_add.copy_options.on_tracked %q (want %q or %q)", opts.OnTracked, onTrackedWarn, onTrackedRefuse)
+	}
+	switch opts.ExcludeUntracked {
+	case "", excludeAsk, excludeAlways, excludeNever:
+	default:
+		return fmt.Errorf("whq: invalid post_add.copy_options.exclude_untracked %q (want %q, %q or %q)", opts.ExcludeUntracked, excludeAsk, excludeAlways, excludeNever)
+	}
+	return nil
+}
+
+func isGitWorktree(dir string) bool {
+	c := exec.Command("git", "rev-parse", "--is-inside-work-tree")
+	c.Dir = dir
+	out, err := c.Output()
+	return err == nil && strings.TrimSpace(string(out)) == "true"
+}
+
+// classifyCopyDest reports how git sees item inside worktreeRoot: tracked
+// (some file at or below it is in the index), ignored, or untracked and
+// visible to `git status`.
+func classifyCopyDest(worktreeRoot, item string, isDir bool) (copyDestState, error) {
+	rel := filepath.ToSlash(filepath.Clean(item))
+
+	// Literal, so that *, ? and [ in file names are not globs.
+	ls := exec.Command("git", "ls-files", "-z", "--", ":(literal)"+rel)
+	ls.Dir = worktreeRoot
+	out, err := ls.Output()
+	if err != nil {
+		return 0, fmt.Errorf("git ls-files %s: %w", rel, err)
+	}
+	if len(out) > 0 {
+		return copyDestTracked, nil
+	}
+
+	if isDir {
+		rel += "/"
+	}
+	ci := exec.Command("git", "check-ignore", "-q", "--", rel)
+	ci.Dir = worktreeRoot
+	if err := ci.Run(); err != nil {
+		var ee *exec.ExitError
+		if errors.As(err, &ee) && ee.ExitCode() == 1 {
+			return copyDestUntracked, nil
+		}
+		return 0, fmt.Errorf("git check-ignore %s: %w", rel, err)
+	}
+	return copyDestIgnored, nil
+}
+
+// excludePattern anchors item to the worktree root, with glob characters
+// escaped, so only that path is hidden.
+func excludePattern(item string, isDir bool) string {
+	p := "/" + gitignoreEscaper.Replace(strings.TrimSuffix(filepath.ToSlash(filepath.Clean(item)), "/"))
+	if isDir {
+		p += "/"
+	}
+	return p
+}
+
+var gitignoreEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`)
+
+// gitExcludePath resolves info/exclude for the repository worktreeRoot
+// belongs to.
+func gitExcludePath(worktreeRoot string) (string, error) {
+	c := exec.Command("git", "rev-parse", "--git-path", "info/exclude")
+	c.Dir = worktreeRoot
+	out, err := c.Output()
+	if err != nil {
+		return "", fmt.Errorf("whq: failed to locate info/exclude: %w", err)
+	}
+	p := strings.TrimSpace(string(out))
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(worktreeRoot, p)
+	}
+	return p, nil
+}
+
+// appendExcludes adds patterns missing from the exclude file at path.
+func appendExcludes(path string, patterns []string) ([]string, error) {
+	existing := map[string]bool{}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		existing[strings.TrimSpace(scanner.Text())] = true
+	}
+
+	var added []string
+	for _, p := range patterns {
+		if !existing[p] && !slices.Contains(added, p) {
+			added = append(added, p)
+		}
+	}
+	if len(added) == 0 {
+		return nil, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var b strings.Builder
+	if len(data) > 0 && !strings.HasSuffix(string(data), "\n") {
+		b.WriteString("\n")
+	}
+	b.WriteString("# Added by whq (post_add.copy)\n")
+	for _, p := range added {
+		b.WriteString(p + "\n")
+	}
+	if _, err := io.WriteString(f, b.String()); err != nil {
+		return nil, err
+	}
+	return added, f.Close()
+}
+
+// excludeUntrackedCopies offers to hide copied paths that would otherwise
+// show up in `git status` by listing them in info/exclude, which is never
+// committed.
+func excludeUntrackedCopies(worktreeRoot, mode string, patterns []string) error {
+	if len(patterns) == 0 || mode == excludeNever {
+		return nil
+	}
+	excludePath, err := gitExcludePath(worktreeRoot)
+	if err != nil {
+		return err
+	}
+	if mode != excludeAlways {
+		question := fmt.Sprintf("Post-add copy: %s not ignored by git; append to %s?", strings.Join(patterns, ", "), excludePath)
+		ok, err := confirm(question)
+		if errors.Is(err, errNotInteractive) {
+			fmt.Fprintf(os.Stderr, "Post-add copy: warning: %s not ignored by git (set post_add.copy_options.exclude_untracked to \"always\" to hide them)\n", strings.Join(patterns, ", "))
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+	}
+	added, err := appendExcludes(excludePath, patterns)
+	if err != nil {
+		return fmt.Errorf("whq: failed to update %s: %w", excludePath, err)
+	}
+	for _, p := range added {
+		fmt.Fprintf(os.Stdout, "Post-add exclude: %s\n", p)
+	}
+	return nil
+}
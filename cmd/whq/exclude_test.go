@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunPostAddActionsRefusesTrackedDestination(t *testing.T) {
+	repoRoot := t.TempDir()
+	worktreeRoot := initGitRepo(t)
+	writeFile(t, filepath.Join(repoRoot, ".whq.json"), `{
+		"post_add": {"copy": ["README.md"], "copy_options": {"on_tracked": "refuse"}}
+	}`)
+	writeFile(t, filepath.Join(repoRoot, "README.md"), "local copy\n")
+
+	err := runPostAddActions(repoRoot, worktreeRoot, "demo")
+	if err == nil || !strings.Contains(err.Error(), "tracked") {
+		t.Fatalf("expected tracked refusal, got %v", err)
+	}
+	got, _ := os.ReadFile(filepath.Join(worktreeRoot, "README.md"))
+	if string(got) != "tracked\n" {
+		t.Fatalf("tracked file should be untouched: %q", got)
+	}
+}
+
+func TestRunPostAddActionsExcludesUntrackedCopies(t *testing.T) {
+	repoRoot := t.TempDir()
+	worktreeRoot := initGitRepo(t)
+	writeFile(t, filepath.Join(worktreeRoot, ".gitignore"), "ignored.env\n")
+	runGit(t, worktreeRoot, "add", ".gitignore")
+	runGit(t, worktreeRoot, "commit", "-q", "-m", "ignore")
+
+	writeFile(t, filepath.Join(repoRoot, ".whq.json"), `{
+		"post_add": {
+			"copy": [".env", "issues/", "ignored.env", "README.md"],
+			"copy_options": {"exclude_untracked": "always"}
+		}
+	}`)
+	writeFile(t, filepath.Join(repoRoot, ".env"), "SECRET=1\n")
+	writeFile(t, filepath.Join(repoRoot, "issues", "1.md"), "bug\n")
+	writeFile(t, filepath.Join(repoRoot, "ignored.env"), "X=1\n")
+	writeFile(t, filepath.Join(repoRoot, "README.md"), "tracked\n")
+
+	if err := runPostAddActions(repoRoot, worktreeRoot, "demo"); err != nil {
+		t.Fatalf("expected success, got error: %v", err)
+	}
+
+	exclude, err := os.ReadFile(filepath.Join(worktreeRoot, ".git", "info", "exclude"))
+	if err != nil {
+		t.Fatalf("read exclude failed: %v", err)
+	}
+	for _, want := range []string{"/.env\n", "/issues/\n"} {
+		if !strings.Contains(string(exclude), want) {
+			t.Fatalf("exclude missing %q: %q", want, exclude)
+		}
+	}
+	if strings.Contains(string(exclude), "ignored.env") || strings.Contains(string(exclude), "README") {
+		t.Fatalf("exclude should only list untracked, unignored paths: %q", exclude)
+	}
+	if status := runGit(t, worktreeRoot, "status", "--porcelain"); status != "" {
+		t.Fatalf("expected clean status, got %q", status)
+	}
+
+	// A second run must not duplicate entries.
+	if err := runPostAddActions(repoRoot, worktreeRoot, "demo"); err != nil {
+		t.Fatalf("second run failed: %v", err)
+	}
+	again, _ := os.ReadFile(filepath.Join(worktreeRoot, ".git", "info", "exclude"))
+	if !bytes.Equal(exclude, again) {
+		t.Fatalf("exclude changed on second run: %q", again)
+	}
+}
+
+func TestExcludeUntrackedCopiesPrompts(t *testing.T) {
+	worktreeRoot := initGitRepo(t)
+	stubPrompt(t, "y\n")
+
+	if err := excludeUntrackedCopies(worktreeRoot, excludeAsk, []string{"/.env"}); err != nil {
+		t.Fatalf("exclude failed: %v", err)
+	}
+	exclude, err := os.ReadFile(filepath.Join(worktreeRoot, ".git", "info", "exclude"))
+	if err != nil || !strings.Contains(string(exclude), "/.env\n") {
+		t.Fatalf("expected /.env in exclude, got %q, %v", exclude, err)
+	}
+}
+
+func initGitRepo(t testing.TB) string {
+	t.Helper()
+	dir := t.TempDir()
+	runGit(t, dir, "init", "-q")
+	runGit(t, dir, "config", "user.email", "whq@example.com")
+	runGit(t, dir, "config", "user.name", "whq")
+	writeFile(t, filepath.Join(dir, "README.md"), "tracked\n")
+	runGit(t, dir, "add", "README.md")
+	runGit(t, dir, "commit", "-q", "-m", "init")
+	return dir
+}
+
+func runGit(t testing.TB, dir string, args ...string) string {
+	t.Helper()
+	c := exec.Command("git", args...)
+	c.Dir = dir
+	out, err := c.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v failed: %v\n%s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func stubPrompt(t *testing.T, answer string) *bytes.Buffer {
+	t.Helper()
+	origIn, origOut, origInteractive := promptIn, promptOut, promptInteractive
+	var out bytes.Buffer
+	promptIn = strings.NewReader(answer)
+	promptOut = &out
+	promptInteractive = func() bool { return true }
+	t.Cleanup(func() { promptIn, promptOut, promptInteractive = origIn, origOut, origInteractive })
+	return &out
+}
+
+func TestClassifyCopyDestTakesPathsLiterally(t *testing.T) {
+	worktreeRoot := initGitRepo(t)
+	writeFile(t, filepath.Join(worktreeRoot, "a1.txt"), "tracked\n")
+	runGit(t, worktreeRoot, "add", "a1.txt")
+	runGit(t, worktreeRoot, "commit", "-q", "-m", "a1")
+
+	for _, item := range []string{"a[1].txt", "a?.txt", "*.txt"} {
+		state, err := classifyCopyDest(worktreeRoot, item, false)
+		if err != nil || state != copyDestUntracked {
+			t.Errorf("%s: expected untracked, got %v, %v", item, state, err)
+		}
+	}
+	if state, err := classifyCopyDest(worktreeRoot, "a1.txt", false); err != nil || state != copyDestTracked {
+		t.Errorf("a1.txt: expected tracked, got %v, %v", state, err)
+	}
+
+	// The exclude entry hides only the copy itself.
+	if err := excludeUntrackedCopies(worktreeRoot, excludeAlways, []string{excludePattern("a[1].txt", false)}); err != nil {
+		t.Fatalf("exclude failed: %v", err)
+	}
+	writeFile(t, filepath.Join(worktreeRoot, "a[1].txt"), "copy\n")
+	writeFile(t, filepath.Join(worktreeRoot, "a2.txt"), "new\n")
+	if status := runGit(t, worktreeRoot, "status", "--porcelain"); status != "?? a2.txt" {
+		t.Fatalf("unexpected status %q", status)
+	}
+}
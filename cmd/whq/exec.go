@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// exitCodeError makes whq exit with code without printing anything, e.g. to
+// pass on the exit status of a command it ran.
+type exitCodeError struct {
+	code int
+}
+
+func (e *exitCodeError) Error() string { return "" }
+
+var execCmd = &cobra.Command{
+	Use:   "exec [<worktree>] [--] <command> [args...]",
+	Short: "Run a command inside a worktree (default: the current one)",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var (
+			wt  worktreeItem
+			err error
+		)
+		if cmd.ArgsLenAtDash() == 0 {
+			// `whq exec -- <command>`: no worktree given.
+			if len(args) == 0 {
+				return errors.New("Usage: whq exec [<worktree>] -- <command> [args...]")
+			}
+			wt, err = currentWorktree()
+		} else {
+			// Flag parsing stops at the worktree, so a -- after it is still here.
+			dashed := cmd.ArgsLenAtDash() == 1
+			if len(args) > 1 && args[1] == "--" {
+				args = append(args[:1], args[2:]...)
+				dashed = true
+			}
+			if len(args) < 2 {
+				return errors.New("Usage: whq exec [<worktree>] -- <command> [args...]")
+			}
+			var exact bool
+			wt, exact, err = resolveWorktreeMatch(args[0])
+			// Without --, the first word may just as well start the
+			// command: only an exact name or branch picks a worktree.
+			if err == nil && !exact && !dashed {
+				err = fmt.Errorf("whq: '%s' is not the exact name or branch of a worktree (it matches %s); write `whq exec %s -- ...` to use it", args[0], wt.label, args[0])
+			}
+			args = args[1:]
+		}
+		if err != nil {
+			return err
+		}
+		recordWorktreeUse(wt.path)
+		return runExec(wt, args, os.Stdin, os.Stdout, os.Stderr)
+	},
+}
+
+var (
+	foreachParallel int
+	foreachFilter   string
+
+	foreachCmd = &cobra.Command{
+		Use:   "foreach [--parallel N] [--filter dirty|<branch-glob>] -- <command> [args...]",
+		Short: "Run a command in every worktree and summarize the results",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("Usage: whq foreach [--parallel N] [--filter dirty|<branch-glob>] -- <command> [args...]")
+			}
+			items, err := worktreeItems()
+			if err != nil {
+				return err
+			}
+			if items, err = filterWorktrees(items, foreachFilter); err != nil {
+				return err
+			}
+			if len(items) == 0 {
+				fmt.Fprintln(os.Stdout, "No matching worktrees")
+				return nil
+			}
+			return runForeach(items, args, foreachParallel, os.Stdout, os.Stderr)
+		},
+	}
+)
+
+func init() {
+	// Everything after the worktree (or the first argument) is the command,
+	// flags included.
+	execCmd.Flags().SetInterspersed(false)
+	foreachCmd.Flags().SetInterspersed(false)
+	foreachCmd.Flags().IntVarP(&foreachParallel, "parallel", "j", 1, "Run in up to N worktrees at a time")
+	foreachCmd.Flags().StringVar(&foreachFilter, "filter", "", "Only worktrees with uncommitted changes (dirty) or whose branch matches a glob")
+}
+
+// worktreeEnv is the environment commands run with in wt: whq's own plus the
+// WHQ_* variables hooks get.
+func worktreeEnv(wt worktreeItem) []string {
+	hc := hookContext{RepoRoot: env.RepoRoot, WorktreeRoot: wt.path, Branch: wt.branch}
+	return append(os.Environ(), hc.environ()...)
+}
+
+// runExec runs argv in wt and passes its exit status on.
+func runExec(wt worktreeItem, argv []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	c := exec.Command(argv[0], argv[1:]...)
+	c.Dir = wt.path
+	c.Env = worktreeEnv(wt)
+	c.Stdin, c.Stdout, c.Stderr = stdin, stdout, stderr
+	return runForeground(c)
+}
+
+// runForeground runs c, which shares the terminal, and passes its exit
+// status on.
+func runForeground(c *exec.Cmd) error {
+	// The command gets Ctrl-C itself; whq only waits for it to finish.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	defer signal.Stop(sigs)
+
+	if err := c.Run(); err != nil {
+		var ee *exec.ExitError
+		if errors.As(err, &ee) {
+			return &exitCodeError{code: max(ee.ExitCode(), 1)}
+		}
+		return fmt.Errorf("whq: failed to run %s: %w", c.Args[0], err)
+	}
+	return nil
+}
+
+// filterWorktrees keeps the worktrees --filter selects: with uncommitted
+// changes for "dirty", otherwise those whose branch matches the glob.
+func filterWorktrees(items []worktreeItem, filter string) ([]worktreeItem, error) {
+	if filter == "" {
+		return items, nil
+	}
+	if filter != "dirty" {
+		if _, err := path.Match(filter, ""); err != nil {
+			return nil, fmt.Errorf("whq: invalid --filter pattern %q: %w", filter, err)
+		}
+	}
+	var out []worktreeItem
+	for _, item := range items {
+		var keep bool
+		if filter == "dirty" {
+			dirty, err := worktreeDirty(item.path)
+			if err != nil {
+				return nil, err
+			}
+			keep = dirty
+		} else {
+			keep, _ = path.Match(filter, item.branch)
+			keep = keep && item.branch != ""
+		}
+		if keep {
+			out = append(out, item)
+		}
+	}
+	return out, nil
+}
+
+// worktreeDirty reports whether the worktree at dir has uncommitted changes,
+// untracked files included.
+func worktreeDirty(dir string) (bool, error) {
+	c := exec.Command("git", "status", "--porcelain")
+	c.Dir = dir
+	out, err := c.Output()
+	if err != nil {
+		return false, fmt.Errorf("whq: git status failed in %s: %w", dir, err)
+	}
+	return len(strings.TrimSpace(string(out))) > 0, nil
+}
+
+// foreachResult is how the command went in one worktree; code is -1 when it
+// did not run.
+type foreachResult struct {
+	code     int
+	duration time.Duration
+}
+
+// runForeach runs argv in each worktree, up to parallel at a time, with each
+// output line prefixed by the worktree's name; then prints a summary. Unlike
+// hooks, a failure does not stop the others, but an interrupt does.
+func runForeach(items []worktreeItem, argv []string, parallel int, stdout, stderr io.Writer) error {
+	parallel = max(parallel, 1)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	results := make([]foreachResult, len(items))
+	for i := range results {
+		results[i].code = -1
+	}
+	var (
+		outMu sync.Mutex
+		wg    sync.WaitGroup
+	)
+	sem := make(chan struct{}, parallel)
+	for i, item := range items {
+		sem <- struct{}{}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			prefix := "[" + item.label + "] "
+			po := &prefixWriter{mu: &outMu, w: stdout, prefix: prefix}
+			pe := &prefixWriter{mu: &outMu, w: stderr, prefix: prefix}
+			c := exec.CommandContext(ctx, argv[0], argv[1:]...)
+			c.Dir = item.path
+			c.Env = worktreeEnv(item)
+			c.Stdout, c.Stderr = po, pe
+			if parallel > 1 {
+				// Not in the terminal's foreground group: interrupts arrive
+				// through ctx.
+				setProcessGroup(c)
+			}
+			start := time.Now()
+			err := c.Run()
+			po.Flush()
+			pe.Flush()
+			res := foreachResult{code: 0, duration: time.Since(start)}
+			if err != nil {
+				var ee *exec.ExitError
+				if errors.As(err, &ee) {
+					res.code = max(ee.ExitCode(), 1)
+				} else {
+					res.code = 127
+					outMu.Lock()
+					fmt.Fprintf(stderr, "%swhq: failed to run %s: %v\n", prefix, argv[0], err)
+					outMu.Unlock()
+				}
+			}
+			results[i] = res
+		}()
+	}
+	wg.Wait()
+
+	failed := 0
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "WORKTREE\tEXIT\tDURATION")
+	for i, item := range items {
+		res := results[i]
+		switch {
+		case res.code < 0:
+			failed++
+			fmt.Fprintf(tw, "%s\t-\tskipped\n", item.label)
+		default:
+			if res.code != 0 {
+				failed++
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%s\n", item.label, res.code, res.duration.Round(time.Millisecond))
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return errors.New("whq: foreach interrupted")
+	}
+	if failed > 0 {
+		return fmt.Errorf("whq: command failed in %d of %d worktree(s)", failed, len(items))
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRunExec(t *testing.T) {
+	_, whqRoot := setupResolveRepo(t)
+	wt, err := resolveWorktree("hotfix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+	err = runExec(wt, []string{"sh", "-c", `pwd; echo "$WHQ_BRANCH $WHQ_WORKTREE"; cat; exit 3`}, strings.NewReader("input\n"), &stdout, &stderr)
+	var exit *exitCodeError
+	if !errors.As(err, &exit) || exit.code != 3 {
+		t.Fatalf("expected exit code 3, got %v", err)
+	}
+	dir := filepath.Join(whqRoot, "repo", "hotfix")
+	if want := dir + "\nrelease/2.0-hotfix " + dir + "\ninput\n"; stdout.String() != want {
+		t.Fatalf("unexpected output %q, want %q", stdout.String(), want)
+	}
+
+	err = runExec(wt, []string{"whq-no-such-command"}, nil, &stdout, &stderr)
+	if err == nil || !strings.Contains(err.Error(), "whq: failed to run whq-no-such-command") {
+		t.Fatalf("expected a start failure, got %v", err)
+	}
+}
+
+func TestFilterWorktrees(t *testing.T) {
+	_, whqRoot := setupResolveRepo(t)
+	items, err := worktreeItems()
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels := func(items []worktreeItem) string {
+		var out []string
+		for _, item := range items {
+			out = append(out, item.label)
+		}
+		return strings.Join(out, ",")
+	}
+
+	got, err := filterWorktrees(items, "feature/*")
+	if err != nil || labels(got) != "feature/JIRA-1234-login-fix,feature/JIRA-1243-logout" {
+		t.Fatalf("unexpected glob filter result %q, %v", labels(got), err)
+	}
+	writeFile(t, filepath.Join(whqRoot, "repo", "hotfix", "new.txt"), "x")
+	got, err = filterWorktrees(items, "dirty")
+	if err != nil || labels(got) != "hotfix" {
+		t.Fatalf("unexpected dirty filter result %q, %v", labels(got), err)
+	}
+	if _, err := filterWorktrees(items, "[x"); err == nil || !strings.Contains(err.Error(), "invalid --filter pattern") {
+		t.Fatalf("expected a pattern error, got %v", err)
+	}
+}
+
+func TestRunForeach(t *testing.T) {
+	setupResolveRepo(t)
+	items, err := worktreeItems()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+	err = runForeach(items, []string{"sh", "-c", `echo "on $WHQ_BRANCH"; case "$WHQ_BRANCH" in release/*) echo bad >&2; exit 4;; esac`}, 2, &stdout, &stderr)
+	if err == nil || err.Error() != "whq: command failed in 1 of 4 worktree(s)" {
+		t.Fatalf("expected one failure, got %v", err)
+	}
+	out := stdout.String()
+	for _, want := range []string{
+		"[@] on main\n",
+		"[feature/JIRA-1234-login-fix] on feature/JIRA-1234-login-fix\n",
+		"[hotfix] on release/2.0-hotfix\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in output:\n%s", want, out)
+		}
+	}
+	if stderr.String() != "[hotfix] bad\n" {
+		t.Fatalf("unexpected stderr %q", stderr.String())
+	}
+	summary := regexp.MustCompile(`(?m)^WORKTREE +EXIT +DURATION\n@ +0 +\S+\nfeature/JIRA-1234-login-fix +0 +\S+\nfeature/JIRA-1243-logout +0 +\S+\nhotfix +4 +\S+\n\z`)
+	if !summary.MatchString(out) {
+		t.Fatalf("unexpected summary:\n%s", out)
+	}
+}
+
+func TestExecTakesOnlyExactWorktreeWithoutDash(t *testing.T) {
+	_, whqRoot := setupResolveRepo(t)
+	marker := filepath.Join(t.TempDir(), "ran")
+
+	// Like "make" in `whq exec make test`, a word that only fuzzily matches a
+	// worktree is not taken as one without --.
+	err := execCmd.RunE(execCmd, []string{"lgnfx", "touch", marker})
+	if err == nil || !strings.Contains(err.Error(), "not the exact name or branch") {
+		t.Fatalf("expected an inexact match error, got %v", err)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Fatalf("command should not run, stat: %v", err)
+	}
+
+	if err := execCmd.RunE(execCmd, []string{"lgnfx", "--", "sh", "-c", "pwd > " + marker}); err != nil {
+		t.Fatalf("exec failed: %v", err)
+	}
+	got, _ := os.ReadFile(marker)
+	if want := filepath.Join(whqRoot, "repo", "feature", "JIRA-1234-login-fix") + "\n"; string(got) != want {
+		t.Fatalf("ran in %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Fuzzy match scoring: every matched rune earns fuzzyMatchScore, plus a
+// bonus when it starts a word or continues the previous match; every
+// skipped rune between matches costs fuzzyGapPenalty.
+const (
+	fuzzyMatchScore       = 16
+	fuzzyBoundaryBonus    = 8
+	fuzzyConsecutiveBonus = 12
+	fuzzyGapPenalty       = 1
+)
+
+// fuzzyMatch reports whether the runes of pattern appear in text in order,
+// ignoring case, and scores the best such alignment; higher is better.
+// positions holds the rune indices of text that matched.
+func fuzzyMatch(pattern, text string) (score int, positions []int, ok bool) {
+	p := []rune(strings.ToLower(pattern))
+	t := []rune(text)
+	lower := []rune(strings.ToLower(text))
+	if len(p) == 0 {
+		return 0, nil, true
+	}
+	if len(lower) != len(t) {
+		// Lowercasing changed the length; match without case folding.
+		lower = t
+	}
+	best := -1 << 31
+	for start := range lower {
+		if lower[start] != p[0] {
+			continue
+		}
+		s, pos, matched := fuzzyAlign(p, t, lower, start)
+		if matched && s > best {
+			best, positions, ok = s, pos, true
+		}
+	}
+	return best, positions, ok
+}
+
+// fuzzyAlign matches p greedily from start, preferring to continue a run.
+func fuzzyAlign(p, t, lower []rune, start int) (int, []int, bool) {
+	positions := make([]int, 0, len(p))
+	score := 0
+	i := start
+	for j, r := range p {
+		for i < len(lower) && lower[i] != r {
+			i++
+		}
+		if i == len(lower) {
+			return 0, nil, false
+		}
+		score += fuzzyMatchScore
+		if i == 0 || isFuzzyBoundary(t[i-1], t[i]) {
+			score += fuzzyBoundaryBonus
+		}
+		if j > 0 {
+			if prev := positions[j-1]; prev == i-1 {
+				score += fuzzyConsecutiveBonus
+			} else {
+				score -= (i - prev - 1) * fuzzyGapPenalty
+			}
+		}
+		positions = append(positions, i)
+		i++
+	}
+	return score, positions, true
+}
+
+// isFuzzyBoundary reports whether cur starts a word: after a separator or
+// at a lower-to-upper case change.
+func isFuzzyBoundary(prev, cur rune) bool {
+	switch prev {
+	case '/', '-', '_', '.', ' ':
+		return true
+	}
+	return unicode.IsLower(prev) && unicode.IsUpper(cur)
+}
+
+// fuzzyResult is one candidate that matched.
+type fuzzyResult struct {
+	index     int
+	score     int
+	positions []int
+}
+
+// fuzzyFilter returns the candidates matching pattern, best first; ties keep
+// shorter candidates, then the original order. An empty pattern keeps every
+// candidate in order.
+func fuzzyFilter(pattern string, candidates []string) []fuzzyResult {
+	var out []fuzzyResult
+	for i, c := range candidates {
+		if score, pos, ok := fuzzyMatch(pattern, c); ok {
+			out = append(out, fuzzyResult{index: i, score: score, positions: pos})
+		}
+	}
+	if pattern == "" {
+		return out
+	}
+	sort.SliceStable(out, func(a, b int) bool {
+		if out[a].score != out[b].score {
+			return out[a].score > out[b].score
+		}
+		return len(candidates[out[a].index]) < len(candidates[out[b].index])
+	})
+	return out
+}
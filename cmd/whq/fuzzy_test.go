@@ -0,0 +1,49 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFuzzyMatch(t *testing.T) {
+	if _, _, ok := fuzzyMatch("xl", "feature/login-fix"); ok {
+		t.Fatalf("out-of-order runes must not match")
+	}
+	score, pos, ok := fuzzyMatch("LoFi", "feature/login-fix")
+	if !ok || !slices.Equal(pos, []int{8, 9, 14, 15}) {
+		t.Fatalf("unexpected match: %d %v %v", score, pos, ok)
+	}
+	if _, _, ok := fuzzyMatch("", "anything"); !ok {
+		t.Fatalf("an empty pattern matches everything")
+	}
+}
+
+func TestFuzzyFilterRanking(t *testing.T) {
+	candidates := []string{
+		"fix/readme",
+		"feature/JIRA-1234-login-fix",
+		"feature/JIRA-1243-logout",
+		"release/1.2.3.4",
+	}
+	var got []string
+	for _, r := range fuzzyFilter("1234", candidates) {
+		got = append(got, candidates[r.index])
+	}
+	want := []string{"feature/JIRA-1234-login-fix", "release/1.2.3.4"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	got = got[:0]
+	for _, r := range fuzzyFilter("fix", candidates) {
+		got = append(got, candidates[r.index])
+	}
+	// A word-start match beats the same runes later in a longer name.
+	if want := []string{"fix/readme", "feature/JIRA-1234-login-fix"}; !slices.Equal(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	if all := fuzzyFilter("", candidates); len(all) != len(candidates) || all[0].index != 0 || all[3].index != 3 {
+		t.Fatalf("an empty pattern must keep the order: %+v", all)
+	}
+}
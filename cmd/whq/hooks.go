@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Lifecycle hook keys in .whq.json.
+const (
+	hookPreAdd    = "pre_add"
+	hookPostAdd   = "post_add"
+	hookPreRm     = "pre_rm"
+	hookPostRm    = "post_rm"
+	hookPostPrune = "post_prune"
+)
+
+// hookPhases are the labels hooks use in progress output and errors.
+var hookPhases = map[string]string{
+	hookPreAdd:    "Pre-add",
+	hookPostAdd:   "Post-add",
+	hookPreRm:     "Pre-rm",
+	hookPostRm:    "Post-rm",
+	hookPostPrune: "Post-prune",
+}
+
+// hookConfig is the command pipeline shared by every lifecycle hook.
+type hookConfig struct {
+	// Shell is the argv prefix string commands are appended to; defaults to
+	// ["bash", "-lc"].
+	Shell    []string       `json:"shell"`
+	Commands []commandEntry `json:"commands"`
+	// Parallelism caps how many commands run at once; defaults to 1.
+	Parallelism int `json:"parallelism"`
+}
+
+// hookContext describes what a hook runs for. Commands start in Dir, which
+// is also the base for `when.exists`.
+type hookContext struct {
+	Hook         string
+	RepoRoot     string
+	WorktreeRoot string
+	Branch       string
+	Dir          string
+}
+
+// environ returns the WHQ_* variables exported to hook commands. Empty
+// values are omitted.
+func (hc hookContext) environ() []string {
+	var vars []string
+	for _, kv := range [][2]string{
+		{"WHQ_HOOK", hc.Hook},
+		{"WHQ_ROOT", env.WHQRoot},
+		{"WHQ_REPO_ROOT", hc.RepoRoot},
+		{"WHQ_REPO_WHQ_ROOT", env.RepoWHQRoot},
+		{"WHQ_HOST", env.Host},
+		{"WHQ_OWNER", env.Owner},
+		{"WHQ_PROJECT", env.Project},
+		{"WHQ_WORKTREE", hc.WorktreeRoot},
+		{"WHQ_BRANCH", hc.Branch},
+	} {
+		if kv[1] != "" {
+			vars = append(vars, kv[0]+"="+kv[1])
+		}
+	}
+	return vars
+}
+
+// runHook runs the commands configured for hc.Hook. Missing configuration is
+// a silent no-op, matching post_add.
+func runHook(cfg *whqConfig, hc hookContext) error {
+	hook := cfg.hook(hc.Hook)
+	if hook == nil || len(hook.Commands) == 0 {
+		return nil
+	}
+	graph, err := hook.plan()
+	if err != nil {
+		return err
+	}
+	if err := ensureTrusted(cfg, hc.RepoRoot); err != nil {
+		return err
+	}
+
+	phase := hookPhases[hc.Hook]
+	fmt.Fprintf(os.Stdout, "%s (.whq.json): starting (commands=%d)\n", phase, len(hook.Commands))
+	if err := hook.run(graph, phase, hc); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "%s (.whq.json): completed\n", phase)
+	return nil
+}
+
+// hook returns the pipeline configured under key, or nil.
+func (c *whqConfig) hook(key string) *hookConfig {
+	if c == nil {
+		return nil
+	}
+	switch key {
+	case hookPreAdd:
+		return c.PreAdd
+	case hookPostAdd:
+		if c.PostAdd != nil {
+			return &c.PostAdd.hookConfig
+		}
+	case hookPreRm:
+		return c.PreRm
+	case hookPostRm:
+		return c.PostRm
+	case hookPostPrune:
+		return c.PostPrune
+	}
+	return nil
+}
+
+// plan validates conditions and the dependency graph before anything runs.
+func (h *hookConfig) plan() (*commandGraph, error) {
+	for _, entry := range h.Commands {
+		if err := entry.When.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return planCommands(h.Commands)
+}
+
+func (h *hookConfig) run(graph *commandGraph, phase string, hc hookContext) error {
+	runner := commandRunner{shell: h.Shell, hc: hc}
+	return graph.run(phase, runner, h.Parallelism, os.Stdout, os.Stderr)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunHookExportsContext(t *testing.T) {
+	repoRoot := t.TempDir()
+	origEnv := env
+	env.WHQRoot, env.Host, env.Owner, env.Project = "/whq", "github.com", "acme", "app"
+	t.Cleanup(func() { env = origEnv })
+
+	writeFile(t, filepath.Join(repoRoot, ".whq.json"), `{
+		"pre_add": {
+			"shell": ["sh", "-c"],
+			"commands": ["env | grep '^WHQ_' | sort > hook.env"]
+		}
+	}`)
+	cfg, err := loadWHQConfig(repoRoot)
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+
+	hc := hookContext{Hook: hookPreAdd, RepoRoot: repoRoot, WorktreeRoot: "/whq/wt", Branch: "feature/x", Dir: repoRoot}
+	if err := runHook(cfg, hc); err != nil {
+		t.Fatalf("hook failed: %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(repoRoot, "hook.env"))
+	if err != nil {
+		t.Fatalf("hook output missing: %v", err)
+	}
+	for _, want := range []string{
+		"WHQ_BRANCH=feature/x",
+		"WHQ_HOOK=pre_add",
+		"WHQ_HOST=github.com",
+		"WHQ_REPO_ROOT=" + repoRoot,
+		"WHQ_WORKTREE=/whq/wt",
+	} {
+		if !strings.Contains(string(got), want+"\n") {
+			t.Errorf("missing %s in %q", want, got)
+		}
+	}
+
+	// Hooks that are not configured are silent no-ops.
+	if err := runHook(cfg, hookContext{Hook: hookPostPrune, RepoRoot: repoRoot, Dir: repoRoot}); err != nil {
+		t.Fatalf("unconfigured hook failed: %v", err)
+	}
+}
+
+func TestRmRunsPreAndPostRmHooks(t *testing.T) {
+	repoRoot := initGitRepo(t)
+	whqRoot := t.TempDir()
+	dest := filepath.Join(whqRoot, "feature")
+	runGit(t, repoRoot, "worktree", "add", "-q", "-b", "feature", dest)
+
+	origEnv := env
+	env.RepoRoot, env.RepoWHQRoot = repoRoot, whqRoot
+	t.Cleanup(func() { env = origEnv })
+	origForce, origBranch := rmForce, rmBranch
+	t.Cleanup(func() { rmForce, rmBranch = origForce, origBranch })
+	rmForce, rmBranch = false, false
+
+	writeFile(t, filepath.Join(repoRoot, ".whq.json"), `{
+		"pre_rm": {"commands": ["test -f allow-rm"]},
+		"post_rm": {"commands": ["echo \"$WHQ_BRANCH\" > removed.txt"]}
+	}`)
+	runGit(t, repoRoot, "add", ".whq.json")
+	runGit(t, repoRoot, "commit", "-q", "-m", "hooks")
+
+	err := rmCmd.RunE(rmCmd, []string{"feature"})
+	if err == nil || !strings.Contains(err.Error(), "pre-rm command failed") {
+		t.Fatalf("expected pre-rm failure, got %v", err)
+	}
+	if _, err := os.Stat(dest); err != nil {
+		t.Fatalf("worktree should be kept after pre-rm failure: %v", err)
+	}
+
+	writeFile(t, filepath.Join(dest, "allow-rm"), "")
+	rmForce = true
+	if err := rmCmd.RunE(rmCmd, []string{"feature"}); err != nil {
+		t.Fatalf("rm failed: %v", err)
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Fatalf("worktree should be removed, err=%v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(repoRoot, "removed.txt"))
+	if err != nil || string(got) != "feature\n" {
+		t.Fatalf("post-rm output mismatch: %q, %v", got, err)
+	}
+}
+
+func TestAddAsksForTrustBeforeCreatingWorktree(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("WHQ_TRUST_ALL", "")
+	repoRoot := initGitRepo(t)
+	whqRoot := t.TempDir()
+	origEnv := env
+	env.RepoRoot, env.RepoWHQRoot = repoRoot, whqRoot
+	t.Cleanup(func() { env = origEnv })
+
+	writeFile(t, filepath.Join(repoRoot, ".whq.json"), `{"post_add": {"commands": ["touch added.txt"]}}`)
+	runGit(t, repoRoot, "add", ".whq.json")
+	runGit(t, repoRoot, "commit", "-q", "-m", "hooks")
+
+	out := stubPrompt(t, "n\n")
+	dest := filepath.Join(whqRoot, "feature")
+	existedAtPrompt := false
+	promptInteractive = func() bool {
+		_, err := os.Stat(dest)
+		existedAtPrompt = existedAtPrompt || err == nil
+		return true
+	}
+	err := addCmd.RunE(addCmd, []string{"feature"})
+	if err == nil || !strings.Contains(err.Error(), "not trusted") {
+		t.Fatalf("expected a trust refusal, got %v", err)
+	}
+	if !strings.Contains(out.String(), "post_add: touch added.txt") {
+		t.Fatalf("prompt should list the post_add commands: %q", out.String())
+	}
+	if existedAtPrompt {
+		t.Fatalf("the worktree was created before asking for trust")
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Fatalf("worktree should not be created, err=%v", err)
+	}
+	if exists, err := branchExists(repoRoot, "feature"); err != nil || exists {
+		t.Fatalf("branch should not be created: %v, %v", exists, err)
+	}
+}
+
+func TestAddStartsMissingBranchFromRemote(t *testing.T) {
+	upstream := initGitRepo(t)
+	runGit(t, upstream, "checkout", "-q", "-b", "feature")
+	writeFile(t, filepath.Join(upstream, "feature.txt"), "feature\n")
+	runGit(t, upstream, "add", "feature.txt")
+	runGit(t, upstream, "commit", "-q", "-m", "feature")
+
+	repoRoot := initGitRepo(t)
+	runGit(t, repoRoot, "remote", "add", "origin", upstream)
+	runGit(t, repoRoot, "fetch", "-q", "origin")
+	whqRoot := t.TempDir()
+	origEnv := env
+	env.RepoRoot, env.RepoWHQRoot = repoRoot, whqRoot
+	t.Cleanup(func() { env = origEnv })
+
+	if rev, err := configRevision(repoRoot, "feature"); err != nil || rev != "origin/feature" {
+		t.Fatalf("configRevision = %q, %v; want origin/feature", rev, err)
+	}
+	if err := addCmd.RunE(addCmd, []string{"feature"}); err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(whqRoot, "feature")
+	if got, want := runGit(t, dest, "rev-parse", "HEAD"), runGit(t, repoRoot, "rev-parse", "origin/feature"); got != want {
+		t.Fatalf("worktree HEAD = %s, want origin/feature %s", got, want)
+	}
+	if got := runGit(t, dest, "rev-parse", "--abbrev-ref", "@{upstream}"); got != "origin/feature" {
+		t.Fatalf("upstream = %q, want origin/feature", got)
+	}
+}
+
+func TestRemoteBranchRefusesAmbiguousRemotes(t *testing.T) {
+	upstream := initGitRepo(t)
+	runGit(t, upstream, "branch", "feature")
+	repoRoot := initGitRepo(t)
+	for _, remote := range []string{"origin", "fork"} {
+		runGit(t, repoRoot, "remote", "add", remote, upstream)
+		runGit(t, repoRoot, "fetch", "-q", remote)
+	}
+	if _, err := remoteBranch(repoRoot, "feature"); err == nil || !strings.Contains(err.Error(), "several remotes") {
+		t.Fatalf("expected an ambiguity error, got %v", err)
+	}
+	if got, err := remoteBranch(repoRoot, "missing"); err != nil || got != "" {
+		t.Fatalf("remoteBranch(missing) = %q, %v", got, err)
+	}
+}
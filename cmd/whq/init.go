@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"os/exec"
+	"path"
 	"path/filepath"
 	"strings"
 
@@ -12,21 +14,24 @@ import (
 )
 
 var (
-	initForce bool
+	initForce       bool
+	initInteractive bool
+	initDryRun      bool
 
 	initCmd = &cobra.Command{
 		Use:   "init",
-		Short: "Generate a .whq.json template in the repo root",
+		Short: "Generate a .whq.json for this repository",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) != 0 {
 				return errors.New("Usage: whq init")
 			}
-			return runInit(initForce, os.Stdout, os.Stderr)
+			return runInit(initOptions{force: initForce, interactive: initInteractive, dryRun: initDryRun}, os.Stdout, os.Stderr)
 		},
 	}
 )
 
 const defaultWHQTemplate = `{
+  "version": 1,
   "post_add": {
     "copy": [],
     "commands": []
@@ -34,34 +39,203 @@ const defaultWHQTemplate = `{
 }
 `
 
+// initTools maps marker files in the repo root to the setup command they
+// suggest, in the order the commands should run: toolchains first, then
+// dependencies.
+var initTools = []struct{ marker, command string }{
+	{"mise.toml", "mise install"},
+	{".envrc", "direnv allow"},
+	{"pnpm-lock.yaml", "pnpm install --frozen-lockfile"},
+	{"package-lock.json", "npm ci"},
+	{"go.mod", "go mod download"},
+	{"Gemfile.lock", "bundle install"},
+	{"poetry.lock", "poetry install"},
+	{"Cargo.toml", "cargo fetch"},
+}
+
+// initSkipIgnored lists ignored files that are never worth copying.
+var initSkipIgnored = map[string]bool{".DS_Store": true, "Thumbs.db": true}
+
+// initSkipExts lists extensions of ignored files that are build or editor
+// leftovers rather than local configuration.
+var initSkipExts = map[string]bool{".log": true, ".pyc": true, ".swp": true, ".tmp": true}
+
+type initOptions struct {
+	force       bool
+	interactive bool
+	dryRun      bool
+}
+
+// initSuggestion is one entry whq init proposes: a copy path or a command,
+// with the file that prompted it.
+type initSuggestion struct {
+	copy    string
+	command string
+	reason  string
+}
+
+func (s initSuggestion) String() string {
+	if s.copy != "" {
+		return fmt.Sprintf("copy %s (%s)", s.copy, s.reason)
+	}
+	return fmt.Sprintf("run %q (found %s)", s.command, s.reason)
+}
+
 func init() {
 	initCmd.Flags().BoolVarP(&initForce, "force", "f", false, "Overwrite existing .whq.json")
+	initCmd.Flags().BoolVarP(&initInteractive, "interactive", "i", false, "Ask about each detected suggestion")
+	initCmd.Flags().BoolVarP(&initDryRun, "dry-run", "n", false, "Print the proposed .whq.json instead of writing it")
 }
 
-func runInit(force bool, stdout, stderr io.Writer) error {
+func runInit(opts initOptions, stdout, stderr io.Writer) error {
 	repoRoot := env.RepoRoot
 	if strings.TrimSpace(repoRoot) == "" {
 		return errors.New("whq: not inside a Git repository")
 	}
 
-	configPath := filepath.Join(repoRoot, ".whq.json")
-	if info, err := os.Stat(configPath); err == nil {
-		if !force {
-			fmt.Fprintln(stderr, ".whq.json already exists; use --force to overwrite")
-			return errors.New("whq: .whq.json already exists (use --force to overwrite)")
+	// Checked before asking anything: the answers would be thrown away.
+	existing, err := findConfigFile(repoRoot, ".whq")
+	if err != nil {
+		return err
+	}
+	if existing != "" && !opts.dryRun {
+		name := filepath.Base(existing)
+		if !opts.force {
+			fmt.Fprintf(stderr, "%s already exists; use --force to overwrite\n", name)
+			return fmt.Errorf("whq: %s already exists (use --force to overwrite)", name)
 		}
-		if info.IsDir() {
-			return errors.New("whq: .whq.json exists and is a directory")
+		if info, err := os.Stat(existing); err != nil {
+			return fmt.Errorf("whq: failed to inspect %s: %w", name, err)
+		} else if info.IsDir() {
+			return fmt.Errorf("whq: %s exists and is a directory", name)
 		}
-		fmt.Fprintln(stderr, "Overwriting existing .whq.json (--force)")
-	} else if !errors.Is(err, os.ErrNotExist) {
-		return fmt.Errorf("whq: failed to inspect .whq.json: %w", err)
+		fmt.Fprintf(stderr, "Overwriting existing %s (--force)\n", name)
 	}
 
-	if err := os.WriteFile(configPath, []byte(defaultWHQTemplate), 0o644); err != nil {
+	suggestions, err := detectInitSuggestions(repoRoot)
+	if err != nil {
+		return err
+	}
+	if opts.interactive {
+		if suggestions, err = chooseInitSuggestions(suggestions); err != nil {
+			return err
+		}
+	}
+	content, err := renderInitConfig(suggestions)
+	if err != nil {
+		return err
+	}
+	if opts.dryRun {
+		_, err := stdout.Write(content)
+		return err
+	}
+
+	configPath := filepath.Join(repoRoot, ".whq.json")
+	if err := os.WriteFile(configPath, content, 0o644); err != nil {
 		return fmt.Errorf("whq: failed to write .whq.json: %w", err)
 	}
+	// A .whq.yaml or .whq.toml next to it would make every load fail.
+	if existing != "" && existing != configPath {
+		if err := os.Remove(existing); err != nil {
+			return fmt.Errorf("whq: failed to remove %s: %w", filepath.Base(existing), err)
+		}
+	}
 
 	fmt.Fprintln(stdout, "Created .whq.json")
+	if !opts.interactive {
+		for _, s := range suggestions {
+			fmt.Fprintf(stdout, "  %s\n", s)
+		}
+	}
 	return nil
 }
+
+// detectInitSuggestions looks for tool markers in repoRoot and for files
+// git ignores there, which usually hold local settings a new worktree needs.
+func detectInitSuggestions(repoRoot string) ([]initSuggestion, error) {
+	var out []initSuggestion
+	ignored, err := ignoredInitFiles(repoRoot)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range ignored {
+		out = append(out, initSuggestion{copy: p, reason: "ignored by git"})
+	}
+	for _, tool := range initTools {
+		info, err := os.Stat(filepath.Join(repoRoot, tool.marker))
+		if err != nil || info.IsDir() {
+			continue
+		}
+		out = append(out, initSuggestion{command: tool.command, reason: tool.marker})
+	}
+	return out, nil
+}
+
+// ignoredInitFiles lists ignored files in repoRoot, leaving out ignored
+// directories (dependency and build trees) and obvious junk. Outside a Git
+// worktree it finds nothing.
+func ignoredInitFiles(repoRoot string) ([]string, error) {
+	if !isGitWorktree(repoRoot) {
+		return nil, nil
+	}
+	c := exec.Command("git", "ls-files", "-z", "--others", "--ignored", "--exclude-standard", "--directory")
+	c.Dir = repoRoot
+	out, err := c.Output()
+	if err != nil {
+		return nil, fmt.Errorf("whq: failed to list ignored files: %w", err)
+	}
+	var files []string
+	for _, p := range strings.Split(string(out), "\x00") {
+		base := path.Base(p)
+		switch {
+		case p == "", strings.HasSuffix(p, "/"):
+		case initSkipIgnored[base], initSkipExts[path.Ext(base)]:
+		case strings.HasPrefix(base, ".whq."):
+		default:
+			files = append(files, p)
+		}
+	}
+	return files, nil
+}
+
+// chooseInitSuggestions asks about each suggestion, defaulting to yes.
+func chooseInitSuggestions(suggestions []initSuggestion) ([]initSuggestion, error) {
+	if !promptInteractive() {
+		return nil, errNotInteractive
+	}
+	var kept []initSuggestion
+	for _, s := range suggestions {
+		ok, err := confirmDefault(fmt.Sprintf("Add: %s?", s), true)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			kept = append(kept, s)
+		}
+	}
+	return kept, nil
+}
+
+// renderInitConfig fills the default template with the accepted
+// suggestions.
+func renderInitConfig(suggestions []initSuggestion) ([]byte, error) {
+	doc, err := openJSONDocument([]byte(defaultWHQTemplate))
+	if err != nil {
+		return nil, err
+	}
+	copies, commands := []any{}, []any{}
+	for _, s := range suggestions {
+		if s.copy != "" {
+			copies = append(copies, s.copy)
+		} else {
+			commands = append(commands, s.command)
+		}
+	}
+	if err := doc.set([]string{"post_add", "copy"}, copies); err != nil {
+		return nil, err
+	}
+	if err := doc.set([]string{"post_add", "commands"}, commands); err != nil {
+		return nil, err
+	}
+	return doc.encode()
+}
@@ -15,7 +15,7 @@ func TestRunInitCreatesTemplate(t *testing.T) {
 	t.Cleanup(func() { env = origEnv })
 
 	var stdout, stderr bytes.Buffer
-	if err := runInit(false, &stdout, &stderr); err != nil {
+	if err := runInit(initOptions{}, &stdout, &stderr); err != nil {
 		t.Fatalf("runInit failed: %v", err)
 	}
 
@@ -46,7 +46,7 @@ func TestRunInitSkipsWhenFileExistsWithoutForce(t *testing.T) {
 	t.Cleanup(func() { env = origEnv })
 
 	var stdout, stderr bytes.Buffer
-	err := runInit(false, &stdout, &stderr)
+	err := runInit(initOptions{}, &stdout, &stderr)
 	if err == nil {
 		t.Fatalf("expected error when file exists")
 	}
@@ -66,6 +66,41 @@ func TestRunInitSkipsWhenFileExistsWithoutForce(t *testing.T) {
 	}
 }
 
+func TestRunInitChecksEveryFormatBeforeAsking(t *testing.T) {
+	repo := initGitRepo(t)
+	writeFile(t, filepath.Join(repo, "go.mod"), "module example\n")
+	writeFile(t, filepath.Join(repo, ".whq.yaml"), "post_add:\n  copy: [.env]\n")
+	origEnv := env
+	env.RepoRoot = repo
+	t.Cleanup(func() { env = origEnv })
+	prompts := stubPrompt(t, "y\n")
+
+	var stdout, stderr bytes.Buffer
+	err := runInit(initOptions{interactive: true}, &stdout, &stderr)
+	if err == nil || err.Error() != "whq: .whq.yaml already exists (use --force to overwrite)" {
+		t.Fatalf("expected an exists error, got %v", err)
+	}
+	if prompts.Len() != 0 {
+		t.Fatalf("expected no prompts, got %q", prompts.String())
+	}
+	if _, err := os.Stat(filepath.Join(repo, ".whq.json")); !os.IsNotExist(err) {
+		t.Fatalf(".whq.json must not be written (stat: %v)", err)
+	}
+
+	// --force replaces the file instead of adding a second one.
+	stdout.Reset()
+	stderr.Reset()
+	if err := runInit(initOptions{force: true}, &stdout, &stderr); err != nil {
+		t.Fatalf("runInit with force failed: %v", err)
+	}
+	if !strings.Contains(stderr.String(), "Overwriting existing .whq.yaml (--force)") {
+		t.Fatalf("stderr missing overwrite notice: %q", stderr.String())
+	}
+	if path, err := findConfigFile(repo, ".whq"); err != nil || path != filepath.Join(repo, ".whq.json") {
+		t.Fatalf("expected only .whq.json, got %q, %v", path, err)
+	}
+}
+
 func TestRunInitForceOverwritesExisting(t *testing.T) {
 	repo := t.TempDir()
 	path := filepath.Join(repo, ".whq.json")
@@ -77,7 +112,7 @@ func TestRunInitForceOverwritesExisting(t *testing.T) {
 	t.Cleanup(func() { env = origEnv })
 
 	var stdout, stderr bytes.Buffer
-	if err := runInit(true, &stdout, &stderr); err != nil {
+	if err := runInit(initOptions{force: true}, &stdout, &stderr); err != nil {
 		t.Fatalf("runInit with force failed: %v", err)
 	}
 
@@ -95,3 +130,71 @@ func TestRunInitForceOverwritesExisting(t *testing.T) {
 		t.Fatalf("stderr missing overwrite notice: %q", stderr.String())
 	}
 }
+
+func TestRunInitDetectsToolchain(t *testing.T) {
+	repo := initGitRepo(t)
+	writeFile(t, filepath.Join(repo, ".gitignore"), ".env\nnode_modules/\n*.log\n")
+	writeFile(t, filepath.Join(repo, ".env"), "TOKEN=x\n")
+	writeFile(t, filepath.Join(repo, "debug.log"), "noise\n")
+	writeFile(t, filepath.Join(repo, "node_modules", "pkg", "index.js"), "\n")
+	writeFile(t, filepath.Join(repo, "pnpm-lock.yaml"), "lockfileVersion: 9\n")
+	writeFile(t, filepath.Join(repo, "go.mod"), "module example\n")
+	origEnv := env
+	env.RepoRoot = repo
+	t.Cleanup(func() { env = origEnv })
+
+	var stdout, stderr bytes.Buffer
+	if err := runInit(initOptions{dryRun: true}, &stdout, &stderr); err != nil {
+		t.Fatalf("runInit failed: %v", err)
+	}
+	want := `{
+  "version": 1,
+  "post_add": {
+    "copy": [
+      ".env"
+    ],
+    "commands": [
+      "pnpm install --frozen-lockfile",
+      "go mod download"
+    ]
+  }
+}
+`
+	if stdout.String() != want {
+		t.Fatalf("unexpected config:\n%s", stdout.String())
+	}
+	if _, err := os.Stat(filepath.Join(repo, ".whq.json")); !os.IsNotExist(err) {
+		t.Fatalf("--dry-run must not write .whq.json (stat: %v)", err)
+	}
+}
+
+func TestRunInitInteractive(t *testing.T) {
+	repo := initGitRepo(t)
+	writeFile(t, filepath.Join(repo, ".gitignore"), ".env\n")
+	writeFile(t, filepath.Join(repo, ".env"), "TOKEN=x\n")
+	writeFile(t, filepath.Join(repo, "go.mod"), "module example\n")
+	writeFile(t, filepath.Join(repo, "Cargo.toml"), "[package]\n")
+	origEnv := env
+	env.RepoRoot = repo
+	t.Cleanup(func() { env = origEnv })
+	prompts := stubPrompt(t, "n\n\ny\n")
+
+	var stdout, stderr bytes.Buffer
+	if err := runInit(initOptions{interactive: true}, &stdout, &stderr); err != nil {
+		t.Fatalf("runInit failed: %v", err)
+	}
+	if got := strings.Count(prompts.String(), "[Y/n]"); got != 3 {
+		t.Fatalf("expected 3 prompts, got %q", prompts.String())
+	}
+	data, err := os.ReadFile(filepath.Join(repo, ".whq.json"))
+	if err != nil {
+		t.Fatalf(".whq.json not written: %v", err)
+	}
+	cfg, err := parseWHQConfig(data, ".whq.json")
+	if err != nil {
+		t.Fatalf("generated config is invalid: %v", err)
+	}
+	if len(cfg.PostAdd.Copy) != 0 || len(cfg.PostAdd.Commands) != 2 || cfg.PostAdd.Commands[1].Script != "cargo fetch" {
+		t.Fatalf("unexpected config: %s", data)
+	}
+}
@@ -32,9 +32,26 @@ var (
 		SilenceErrors: true,
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 			// Allow `whq help` to work outside a Git repo.
-			if cmd.Name() == "help" || cmd.Name() == "version" {
+			if cmd.Name() == "help" || cmd.Name() == "version" || cmd == configSchemaCmd || cmd == shellInitCmd {
 				return nil
 			}
+			// Validating or migrating explicit files needs no repository
+			// either.
+			if (cmd == configValidateCmd || cmd == configMigrateCmd) && len(args) > 0 {
+				return nil
+			}
+			// Completion scripts work anywhere; completion requests detect
+			// the repository themselves (see completionEnv).
+			if isCompletionCmd(cmd) {
+				return nil
+			}
+			// Recent worktrees span every repository under WHQ_ROOT; the
+			// prompt must stay fast and asks git only on a cache miss.
+			if cmd == recentCmd || cmd == jumpCmd || cmd == promptCmd {
+				root, err := detectWHQRoot()
+				env.WHQRoot = root
+				return err
+			}
 			return initEnv()
 		},
 	}
@@ -45,16 +62,34 @@ var (
 func main() {
 	// Subcommands
 	rootCmd.AddCommand(addCmd)
+	rootCmd.AddCommand(cdCmd)
+	rootCmd.AddCommand(configCmd)
+	rootCmd.AddCommand(currentCmd)
+	rootCmd.AddCommand(execCmd)
+	rootCmd.AddCommand(foreachCmd)
 	rootCmd.AddCommand(initCmd)
 	rootCmd.AddCommand(pathCmd)
+	rootCmd.AddCommand(pickCmd)
+	rootCmd.AddCommand(promptCmd)
 	rootCmd.AddCommand(listCmd)
 	rootCmd.AddCommand(lsCmd) // alias
 	rootCmd.AddCommand(rmCmd)
 	rootCmd.AddCommand(pruneCmd)
+	rootCmd.AddCommand(recentCmd)
+	rootCmd.AddCommand(jumpCmd)
 	rootCmd.AddCommand(rootPathCmd)
+	rootCmd.AddCommand(runCmd)
+	rootCmd.AddCommand(shellCmd)
+	rootCmd.AddCommand(shellInitCmd)
+	rootCmd.AddCommand(trustCmd)
 	rootCmd.AddCommand(versionCmd)
+	registerCompletions()
 
 	if err := rootCmd.Execute(); err != nil {
+		var exit *exitCodeError
+		if errors.As(err, &exit) {
+			os.Exit(exit.code)
+		}
 		if msg := strings.TrimSpace(err.Error()); msg != "" {
 			fmt.Fprintln(os.Stderr, msg)
 		}
@@ -77,11 +112,33 @@ func initEnv() error {
 	}
 	env.RepoRoot = repoRoot
 
+	whqRoot, err := detectWHQRoot()
+	if err != nil {
+		return err
+	}
+	env.WHQRoot = whqRoot
+
+	host, owner, project, err := detectRepoIdentity(repoRoot)
+	if err != nil {
+		return err
+	}
+	env.Host, env.Owner, env.Project = host, owner, project
+	env.RepoWHQRoot = filepath.Join(env.WHQRoot, env.Host, env.Owner, env.Project)
+
+	if err := os.MkdirAll(env.RepoWHQRoot, 0o755); err != nil {
+		return fmt.Errorf("whq: failed to prepare repo worktrees root: %w", err)
+	}
+
+	return nil
+}
+
+// detectWHQRoot returns the absolute WHQ_ROOT, defaulting to ~/whq.
+func detectWHQRoot() (string, error) {
 	whqRoot := os.Getenv("WHQ_ROOT")
 	if strings.TrimSpace(whqRoot) == "" {
 		home, _ := os.UserHomeDir()
 		if home == "" {
-			return errors.New("whq: cannot determine home directory for WHQ_ROOT default")
+			return "", errors.New("whq: cannot determine home directory for WHQ_ROOT default")
 		}
 		whqRoot = filepath.Join(home, "whq")
 	} else {
@@ -94,20 +151,39 @@ func initEnv() error {
 		}
 	}
 	absWHQ, _ := filepath.Abs(whqRoot)
-	env.WHQRoot = absWHQ
+	return absWHQ, nil
+}
 
-	host, owner, project, err := detectRepoIdentity(repoRoot)
-	if err != nil {
-		return err
+// userConfigDir returns $XDG_CONFIG_HOME/whq, defaulting to ~/.config/whq on
+// every platform so the location is easy to find and document.
+func userConfigDir() (string, error) {
+	base := os.Getenv("XDG_CONFIG_HOME")
+	if strings.TrimSpace(base) == "" {
+		home, _ := os.UserHomeDir()
+		if home == "" {
+			return "", errors.New("whq: cannot determine home directory for the user config directory")
+		}
+		base = filepath.Join(home, ".config")
 	}
-	env.Host, env.Owner, env.Project = host, owner, project
-	env.RepoWHQRoot = filepath.Join(env.WHQRoot, env.Host, env.Owner, env.Project)
+	return filepath.Join(base, "whq"), nil
+}
 
-	if err := os.MkdirAll(env.RepoWHQRoot, 0o755); err != nil {
-		return fmt.Errorf("whq: failed to prepare repo worktrees root: %w", err)
+// userFilesDir is the per-user store that `from_user` copy entries read from:
+// <user config dir>/files/<host>/<owner>/<project>.
+func userFilesDir(host, owner, project string) (string, error) {
+	if host == "" || owner == "" || project == "" {
+		return "", errors.New("whq: cannot determine repository identity (missing or invalid origin remote)")
 	}
-
-	return nil
+	for _, part := range []string{host, owner, project} {
+		if part == "." || part == ".." || strings.ContainsAny(part, `/\`) {
+			return "", fmt.Errorf("whq: refusing unsafe repository identity component %q", part)
+		}
+	}
+	dir, err := userConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "files", host, owner, project), nil
 }
 
 func detectRepoRoot() (string, error) {
@@ -214,7 +290,7 @@ func parseOriginURL(s string) (host, owner, project string, err error) {
 // ----------------------
 
 var addCmd = &cobra.Command{
-	Use:   "add <branch>",
+	Use:   "add [--cd] [--config-source <source>] <branch>",
 	Short: "Create a new worktree for a branch",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if len(args) != 1 {
@@ -228,10 +304,45 @@ var addCmd = &cobra.Command{
 			return err
 		}
 
+		mainCfg, err := loadWHQConfig(env.RepoRoot)
+		if err != nil {
+			return err
+		}
+		source, err := configSource(mainCfg)
+		if err != nil {
+			return err
+		}
+		rev, err := configRevision(env.RepoRoot, branch)
+		if err != nil {
+			return err
+		}
+		cfg, err := loadWorktreeConfig(source, mainCfg, env.RepoRoot, dest, rev)
+		if err != nil {
+			return err
+		}
+		// Ask before git creates anything, so that declining never relies on
+		// rolling the worktree back.
+		if err := ensureTrusted(cfg, env.RepoRoot); err != nil {
+			return err
+		}
+		if err := runHook(cfg, hookContext{
+			Hook:         hookPreAdd,
+			RepoRoot:     env.RepoRoot,
+			WorktreeRoot: dest,
+			Branch:       branch,
+			Dir:          env.RepoRoot,
+		}); err != nil {
+			return err
+		}
+
 		var c *exec.Cmd
-		if exists {
+		switch {
+		case exists:
 			c = exec.Command("git", "worktree", "add", dest, branch)
-		} else {
+		case rev != "HEAD":
+			// Only on a remote: start from there and track it.
+			c = exec.Command("git", "worktree", "add", "--track", "-b", branch, dest, rev)
+		default:
 			c = exec.Command("git", "worktree", "add", "-b", branch, dest)
 		}
 		c.Dir = env.RepoRoot
@@ -242,7 +353,13 @@ var addCmd = &cobra.Command{
 			return fmt.Errorf("")
 		}
 
-		if err := runPostAddActions(env.RepoRoot, dest); err != nil {
+		if source == configSourceNew {
+			cfg, err = loadWorktreeConfig(source, mainCfg, env.RepoRoot, dest, rev)
+		}
+		if err == nil {
+			err = runPostAdd(cfg, env.RepoRoot, dest, branch)
+		}
+		if err != nil {
 			if cleanupErr := cleanupFailedAdd(env.RepoRoot, dest, branch, !exists); cleanupErr != nil {
 				return fmt.Errorf("%w; cleanup failed: %v", err, cleanupErr)
 			}
@@ -250,26 +367,49 @@ var addCmd = &cobra.Command{
 		}
 
 		fmt.Fprintf(os.Stdout, "Created worktree: %s\n", dest)
+		if addCD {
+			recordWorktreeUse(dest)
+			if ok, err := requestCD(dest); err != nil {
+				return err
+			} else if !ok {
+				fmt.Fprintln(os.Stderr, "whq: --cd needs the shell integration (see whq shell-init)")
+			}
+		}
 		return nil
 	},
 }
 
+var addCD bool
+
+func init() {
+	addCmd.Flags().BoolVar(&addCD, "cd", false, "Change into the new worktree (needs whq shell-init)")
+	addCmd.Flags().StringVar(&configSourceOverride, "config-source", "", "Where hooks read .whq.json from: main-worktree, new-worktree or base-revision")
+}
+
 var pathCmd = &cobra.Command{
-	Use:   "path <branch|@>",
+	Use:   "path [<worktree>]",
 	Short: "Print absolute path to worktree or root",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if len(args) != 1 {
-			return errors.New("Usage: whq path <branch|@>")
+		var (
+			dest string
+			err  error
+		)
+		switch {
+		case len(args) == 1:
+			dest, err = worktreePath(args[0])
+		case len(args) > 1:
+			return errors.New("Usage: whq path [<worktree>]")
+		case promptInteractive():
+			return pickCmd.RunE(cmd, args)
+		default:
+			var wt worktreeItem
+			wt, err = currentWorktree()
+			dest = wt.path
 		}
-		arg := args[0]
-		if arg == "@" {
-			fmt.Fprintln(os.Stdout, env.RepoRoot)
-			return nil
-		}
-		dest := filepath.Join(env.RepoWHQRoot, arg)
-		if st, err := os.Stat(dest); err != nil || !st.IsDir() {
-			return fmt.Errorf("whq: worktree '%s' not found at %s", arg, dest)
+		if err != nil {
+			return err
 		}
+		recordWorktreeUse(dest)
 		fmt.Fprintln(os.Stdout, dest)
 		return nil
 	},
@@ -291,17 +431,7 @@ var listCmd = &cobra.Command{
 				fmt.Fprintln(os.Stdout, p)
 				continue
 			}
-			if i == 0 {
-				fmt.Fprintln(os.Stdout, "@")
-				continue
-			}
-			// Relative to repo_whq_root if possible
-			rel := tryRel(env.RepoWHQRoot, p)
-			if rel == "" || strings.HasPrefix(rel, "..") {
-				fmt.Fprintln(os.Stdout, p)
-			} else {
-				fmt.Fprintln(os.Stdout, rel)
-			}
+			fmt.Fprintln(os.Stdout, worktreeLabel(i, p))
 		}
 		return nil
 	},
@@ -323,26 +453,107 @@ var (
 	rmBranch bool
 )
 
+// resolveRmWorktree resolves the worktree to remove. Only an exact name or
+// branch is taken as is; a looser match is confirmed first, so a typo never
+// removes the wrong worktree.
+func resolveRmWorktree(arg string) (worktreeItem, error) {
+	wt, exact, err := resolveWorktreeMatch(arg)
+	if err != nil || exact {
+		return wt, err
+	}
+	ok, err := confirm(fmt.Sprintf("'%s' matches worktree %s; remove it?", arg, wt.text()))
+	if errors.Is(err, errNotInteractive) {
+		return worktreeItem{}, fmt.Errorf("whq: '%s' is not the exact name or branch of a worktree (it matches %s); pass the name or branch to remove it", arg, wt.label)
+	}
+	if err != nil {
+		return worktreeItem{}, err
+	}
+	if !ok {
+		return worktreeItem{}, fmt.Errorf("whq: worktree '%s' was not removed", wt.label)
+	}
+	return wt, nil
+}
+
 var rmCmd = &cobra.Command{
-	Use:   "rm [-f|--force] [-b|--branch] <branch>",
-	Short: "Remove a worktree (and optionally its branch)",
+	Use:   "rm [-f|--force] [-b|--branch] [<worktree>]",
+	Short: "Remove a worktree (and optionally its branch); default: the current one",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if len(args) != 1 {
-			return errors.New("Usage: whq rm [-f|--force] [-b|--branch] <branch>")
+		if len(args) > 1 {
+			return errors.New("Usage: whq rm [-f|--force] [-b|--branch] [<worktree>]")
+		}
+		var (
+			wt  worktreeItem
+			err error
+		)
+		if len(args) == 1 {
+			wt, err = resolveRmWorktree(args[0])
+		} else {
+			wt, err = currentWorktree()
+		}
+		if err != nil {
+			return err
+		}
+		if wt.path == env.RepoRoot {
+			return errors.New("whq: refusing to remove the main worktree")
+		}
+		dest, branch := wt.path, wt.branch
+		if branch == "" {
+			if rmBranch {
+				return fmt.Errorf("whq: worktree '%s' has no branch to delete (detached HEAD)", wt.label)
+			}
+			branch = wt.label
+		}
+
+		mainCfg, err := loadWHQConfig(env.RepoRoot)
+		if err != nil {
+			return err
+		}
+		source, err := configSource(mainCfg)
+		if err != nil {
+			return err
+		}
+		rev, err := configRevision(env.RepoRoot, branch)
+		if err != nil {
+			return err
+		}
+		// Resolve before removal: new-worktree reads the file being deleted.
+		cfg, err := loadWorktreeConfig(source, mainCfg, env.RepoRoot, dest, rev)
+		if err != nil {
+			return err
+		}
+		hc := hookContext{RepoRoot: env.RepoRoot, WorktreeRoot: dest, Branch: branch}
+		// A missing worktree has nothing to tear down; let git report it.
+		if st, err := os.Stat(dest); err == nil && st.IsDir() {
+			hc.Hook, hc.Dir = hookPreRm, dest
+			if err := runHook(cfg, hc); err != nil {
+				fmt.Fprintf(os.Stderr, "Pre-rm failed: keeping worktree %s\n", dest)
+				return err
+			}
 		}
-		branch := args[0]
-		dest := filepath.Join(env.RepoWHQRoot, branch)
 
+		// Checked first: the directory is gone afterwards.
+		leaving := cwdWithin(dest)
 		if err := removeWorktree(env.RepoRoot, dest, rmForce); err != nil {
 			return fmt.Errorf("")
 		}
+		if leaving {
+			ok, err := requestCD(env.RepoRoot)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				fmt.Fprintf(os.Stderr, "whq: the working directory was removed; cd %s\n", env.RepoRoot)
+			}
+		}
 
 		if rmBranch {
 			if err := deleteBranch(env.RepoRoot, branch); err != nil {
 				return fmt.Errorf("")
 			}
 		}
-		return nil
+
+		hc.Hook, hc.Dir = hookPostRm, env.RepoRoot
+		return runHook(cfg, hc)
 	},
 }
 
@@ -355,6 +566,11 @@ var pruneCmd = &cobra.Command{
 	Use:   "prune",
 	Short: "Run git worktree prune",
 	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadWHQConfig(env.RepoRoot)
+		if err != nil {
+			return err
+		}
+
 		c := exec.Command("git", "worktree", "prune")
 		c.Dir = env.RepoRoot
 		c.Stdout = os.Stdout
@@ -362,7 +578,7 @@ var pruneCmd = &cobra.Command{
 		if err := c.Run(); err != nil {
 			return fmt.Errorf("")
 		}
-		return nil
+		return runHook(cfg, hookContext{Hook: hookPostPrune, RepoRoot: env.RepoRoot, Dir: env.RepoRoot})
 	},
 }
 
@@ -392,7 +608,11 @@ var versionCmd = &cobra.Command{
 // ----------------------
 
 func branchExists(repoRoot, branch string) (bool, error) {
-	c := exec.Command("git", "show-ref", "--verify", "--quiet", "refs/heads/"+branch)
+	return refExists(repoRoot, "refs/heads/"+branch)
+}
+
+func refExists(repoRoot, ref string) (bool, error) {
+	c := exec.Command("git", "show-ref", "--verify", "--quiet", ref)
 	c.Dir = repoRoot
 	if err := c.Run(); err != nil {
 		// Non-zero exit likely means it doesn't exist
@@ -404,24 +624,89 @@ func branchExists(repoRoot, branch string) (bool, error) {
 	return true, nil
 }
 
+// remoteBranch returns <remote>/<branch> when branch exists on exactly one
+// remote, where `whq add` starts a missing local branch, as `git switch`
+// does; "" when no remote has it.
+func remoteBranch(repoRoot, branch string) (string, error) {
+	c := exec.Command("git", "remote")
+	c.Dir = repoRoot
+	out, err := c.Output()
+	if err != nil {
+		return "", fmt.Errorf("whq: failed to list remotes: %w", err)
+	}
+	var found []string
+	for _, remote := range strings.Fields(string(out)) {
+		ok, err := refExists(repoRoot, "refs/remotes/"+remote+"/"+branch)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			found = append(found, remote+"/"+branch)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", nil
+	case 1:
+		return found[0], nil
+	}
+	return "", fmt.Errorf("whq: branch '%s' exists on several remotes (%s); create the local branch first", branch, strings.Join(found, ", "))
+}
+
 func listWorktrees(repoRoot string) ([]string, error) {
+	infos, err := listWorktreeInfos(repoRoot)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, len(infos))
+	for i, wt := range infos {
+		paths[i] = wt.Path
+	}
+	return paths, nil
+}
+
+// worktreeInfo is one entry of `git worktree list --porcelain`. Branch is
+// empty for a detached HEAD.
+type worktreeInfo struct {
+	Path   string
+	Branch string
+}
+
+// listWorktreeInfos lists the worktrees of repoRoot, the main one first.
+func listWorktreeInfos(repoRoot string) ([]worktreeInfo, error) {
 	c := exec.Command("git", "worktree", "list", "--porcelain")
 	c.Dir = repoRoot
 	out, err := c.Output()
 	if err != nil {
 		return nil, errors.New("whq: not inside a Git repository")
 	}
-	var paths []string
+	var infos []worktreeInfo
 	scanner := bufio.NewScanner(strings.NewReader(string(out)))
 	for scanner.Scan() {
 		line := scanner.Text()
 		if strings.HasPrefix(line, "worktree ") {
 			path := strings.TrimSpace(strings.TrimPrefix(line, "worktree "))
 			abs, _ := filepath.Abs(path)
-			paths = append(paths, abs)
+			infos = append(infos, worktreeInfo{Path: abs})
+		} else if ref, ok := strings.CutPrefix(line, "branch "); ok && len(infos) > 0 {
+			infos[len(infos)-1].Branch = strings.TrimPrefix(ref, "refs/heads/")
 		}
 	}
-	return paths, nil
+	return infos, nil
+}
+
+// worktreeLabel names a worktree as `whq list` prints it: @ for the main
+// worktree, the path relative to repo_whq_root when inside it, else the
+// absolute path.
+func worktreeLabel(i int, path string) string {
+	if i == 0 {
+		return "@"
+	}
+	rel := tryRel(env.RepoWHQRoot, path)
+	if rel == "" || strings.HasPrefix(rel, "..") {
+		return path
+	}
+	return rel
 }
 
 func tryRel(base, target string) string {
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// TestMain isolates tests from the developer's user config (trust store,
+// per-user files) and pre-approves .whq.json commands; trust tests opt back
+// in explicitly.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "whq-config-")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("XDG_CONFIG_HOME", dir)
+	os.Setenv("WHQ_TRUST_ALL", "1")
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
@@ -0,0 +1,20 @@
+//go:build !unix
+
+package main
+
+import (
+	"io/fs"
+	"os"
+	"time"
+)
+
+func copyOwner(dest string, info fs.FileInfo) error {
+	return nil
+}
+
+func copyModTime(dest string, info fs.FileInfo, link bool) error {
+	if link {
+		return nil
+	}
+	return os.Chtimes(dest, time.Time{}, info.ModTime())
+}
@@ -0,0 +1,38 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyOwner applies the source uid/gid to dest. Lacking permission to chown
+// (the usual case for non-root users) is not an error.
+func copyOwner(dest string, info fs.FileInfo) error {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	err := os.Lchown(dest, int(st.Uid), int(st.Gid))
+	if errors.Is(err, fs.ErrPermission) {
+		return nil
+	}
+	return err
+}
+
+func copyModTime(dest string, info fs.FileInfo, link bool) error {
+	if !link {
+		return os.Chtimes(dest, time.Time{}, info.ModTime())
+	}
+	tv := []unix.Timeval{
+		unix.NsecToTimeval(time.Now().UnixNano()),
+		unix.NsecToTimeval(info.ModTime().UnixNano()),
+	}
+	return unix.Lutimes(dest, tv)
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/spf13/cobra"
+)
+
+var pickCmd = &cobra.Command{
+	Use:   "pick",
+	Short: "Choose a worktree interactively and print its path",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 0 {
+			return errors.New("Usage: whq pick")
+		}
+		dest, err := pickWorktree()
+		if err != nil {
+			return err
+		}
+		recordWorktreeUse(dest)
+		fmt.Fprintln(os.Stdout, dest)
+		return nil
+	},
+}
+
+// errPickCancelled ends a pick the user aborted; it prints nothing.
+var errPickCancelled = errors.New("")
+
+// pickerConfig is the top-level "picker" section.
+type pickerConfig struct {
+	// Command replaces the built-in picker: it reads one worktree name per
+	// line on stdin and prints the chosen one.
+	Command []string `json:"command"`
+}
+
+// pickWorktree lets the user choose a worktree and returns its path, using
+// picker.command when configured and the built-in picker otherwise.
+func pickWorktree() (string, error) {
+	cfg, err := loadWHQConfig(env.RepoRoot)
+	if err != nil {
+		return "", err
+	}
+	items, err := worktreeItems()
+	if err != nil {
+		return "", err
+	}
+	if cfg != nil && cfg.Picker != nil && len(cfg.Picker.Command) > 0 {
+		return runExternalPicker(cfg.Picker.Command, items)
+	}
+
+	// Draw on the terminal itself so stdout can be captured: cd "$(whq pick)".
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", errors.New("whq: the picker needs a terminal")
+	}
+	defer tty.Close()
+	fd := int(tty.Fd())
+	restore, err := makeRaw(fd)
+	if err != nil {
+		return "", fmt.Errorf("whq: failed to set up the terminal: %w", err)
+	}
+	defer restore()
+
+	size := func() (int, int) {
+		w, h, err := terminalSize(fd)
+		if err != nil || w <= 0 || h <= 0 {
+			return 80, 24
+		}
+		return w, h
+	}
+	item, err := newPicker(items, gitPreview).run(tty, tty, size)
+	if err != nil {
+		return "", err
+	}
+	return item.path, nil
+}
+
+// runExternalPicker feeds the worktree labels to command (e.g. fzf) and maps
+// its choice back. Exit status 1 or 130 (fzf's "no match" and "aborted")
+// count as a cancelled pick.
+func runExternalPicker(command []string, items []worktreeItem) (string, error) {
+	var labels strings.Builder
+	for _, item := range items {
+		labels.WriteString(item.label + "\n")
+	}
+	c := exec.Command(command[0], command[1:]...)
+	c.Stdin = strings.NewReader(labels.String())
+	c.Stderr = os.Stderr
+	out, err := c.Output()
+	if err != nil {
+		var ee *exec.ExitError
+		if errors.As(err, &ee) && (ee.ExitCode() == 1 || ee.ExitCode() == 130) {
+			return "", errPickCancelled
+		}
+		return "", fmt.Errorf("whq: picker %s failed: %w", command[0], err)
+	}
+	choice := strings.TrimSpace(string(out))
+	for _, item := range items {
+		if item.label == choice {
+			return item.path, nil
+		}
+	}
+	return "", fmt.Errorf("whq: picker %s returned unknown worktree %q", command[0], choice)
+}
+
+// gitPreview describes a worktree: branch, working tree status and last
+// commit.
+func gitPreview(item worktreeItem) []string {
+	branch := item.branch
+	if branch == "" {
+		branch = "(detached)"
+	}
+	lines := []string{"branch: " + branch, "path:   " + item.path}
+
+	status := "unknown"
+	c := exec.Command("git", "status", "--porcelain")
+	c.Dir = item.path
+	if out, err := c.Output(); err == nil {
+		changed, untracked := 0, 0
+		for _, line := range strings.Split(strings.TrimRight(string(out), "\n"), "\n") {
+			switch {
+			case line == "":
+			case strings.HasPrefix(line, "??"):
+				untracked++
+			default:
+				changed++
+			}
+		}
+		status = "clean"
+		if changed+untracked > 0 {
+			status = fmt.Sprintf("%d changed, %d untracked", changed, untracked)
+		}
+	}
+	lines = append(lines, "status: "+status)
+
+	c = exec.Command("git", "log", "-1", "--format=%h %s (%cr, %an)")
+	c.Dir = item.path
+	if out, err := c.Output(); err == nil {
+		lines = append(lines, "last:   "+strings.TrimSpace(string(out)))
+	}
+	return lines
+}
+
+// Keys the picker understands.
+type pickerKeyCode int
+
+const (
+	keyRune pickerKeyCode = iota
+	keyEnter
+	keyBackspace
+	keyClear
+	keyUp
+	keyDown
+	keyCancel
+	keyIgnore
+)
+
+type pickerKey struct {
+	code pickerKeyCode
+	r    rune
+}
+
+// parsePickerKeys decodes one read from a raw-mode terminal. A lone ESC is
+// a cancel; ESC [ or ESC O starts an arrow key or other sequence.
+func parsePickerKeys(b []byte) []pickerKey {
+	var keys []pickerKey
+	for len(b) > 0 {
+		switch c := b[0]; {
+		case c == 0x1b:
+			if len(b) == 1 {
+				keys = append(keys, pickerKey{code: keyCancel})
+				b = b[1:]
+				continue
+			}
+			if b[1] != '[' && b[1] != 'O' {
+				keys = append(keys, pickerKey{code: keyIgnore})
+				b = b[2:]
+				continue
+			}
+			// CSI: parameters, then one final byte in 0x40..0x7e.
+			i := 2
+			for i < len(b) && (b[i] < 0x40 || b[i] > 0x7e) {
+				i++
+			}
+			code := keyIgnore
+			if i < len(b) {
+				switch b[i] {
+				case 'A':
+					code = keyUp
+				case 'B':
+					code = keyDown
+				}
+				i++
+			}
+			keys = append(keys, pickerKey{code: code})
+			b = b[i:]
+		case c == '\r' || c == '\n':
+			keys = append(keys, pickerKey{code: keyEnter})
+			b = b[1:]
+		case c == 0x7f || c == 0x08:
+			keys = append(keys, pickerKey{code: keyBackspace})
+			b = b[1:]
+		case c == 0x03 || c == 0x07: // Ctrl-C, Ctrl-G
+			keys = append(keys, pickerKey{code: keyCancel})
+			b = b[1:]
+		case c == 0x15: // Ctrl-U
+			keys = append(keys, pickerKey{code: keyClear})
+			b = b[1:]
+		case c == 0x10: // Ctrl-P
+			keys = append(keys, pickerKey{code: keyUp})
+			b = b[1:]
+		case c == 0x0e: // Ctrl-N
+			keys = append(keys, pickerKey{code: keyDown})
+			b = b[1:]
+		case c < 0x20:
+			keys = append(keys, pickerKey{code: keyIgnore})
+			b = b[1:]
+		default:
+			r, n := utf8.DecodeRune(b)
+			keys = append(keys, pickerKey{code: keyRune, r: r})
+			b = b[n:]
+		}
+	}
+	return keys
+}
+
+// pickerPreviewLines is the height of the preview pane below the list.
+const pickerPreviewLines = 4
+
+// picker is the state of the built-in picker: the query, the matching items
+// and the highlighted one.
+type picker struct {
+	items   []worktreeItem
+	preview func(worktreeItem) []string
+	cache   map[string][]string
+
+	query   []rune
+	matches []fuzzyResult
+	cursor  int
+	offset  int
+}
+
+func newPicker(items []worktreeItem, preview func(worktreeItem) []string) *picker {
+	p := &picker{items: items, preview: preview, cache: map[string][]string{}}
+	p.filter()
+	return p
+}
+
+func (p *picker) filter() {
+	labels := make([]string, len(p.items))
+	for i, item := range p.items {
+		labels[i] = item.text()
+	}
+	p.matches = fuzzyFilter(string(p.query), labels)
+	p.cursor, p.offset = 0, 0
+}
+
+// handle applies key and reports the chosen item once the pick is over; a
+// nil item with done set means the pick was cancelled.
+func (p *picker) handle(key pickerKey) (item *worktreeItem, done bool) {
+	switch key.code {
+	case keyRune:
+		p.query = append(p.query, key.r)
+		p.filter()
+	case keyBackspace:
+		if len(p.query) > 0 {
+			p.query = p.query[:len(p.query)-1]
+			p.filter()
+		}
+	case keyClear:
+		p.query = nil
+		p.filter()
+	case keyUp:
+		if p.cursor > 0 {
+			p.cursor--
+		}
+	case keyDown:
+		if p.cursor < len(p.matches)-1 {
+			p.cursor++
+		}
+	case keyEnter:
+		if len(p.matches) == 0 {
+			return nil, false
+		}
+		return &p.items[p.matches[p.cursor].index], true
+	case keyCancel:
+		return nil, true
+	}
+	return nil, false
+}
+
+// run reads keys from in and draws on out until the user chooses or
+// cancels. size reports the terminal's width and height.
+func (p *picker) run(in io.Reader, out io.Writer, size func() (int, int)) (*worktreeItem, error) {
+	// Alternate screen: the shell's contents come back afterwards.
+	fmt.Fprint(out, "\x1b[?1049h")
+	defer fmt.Fprint(out, "\x1b[?1049l")
+	buf := make([]byte, 256)
+	for {
+		w, h := size()
+		if _, err := out.Write(p.render(w, h)); err != nil {
+			return nil, err
+		}
+		n, err := in.Read(buf)
+		for _, key := range parsePickerKeys(buf[:n]) {
+			if item, done := p.handle(key); done {
+				if item == nil {
+					return nil, errPickCancelled
+				}
+				return item, nil
+			}
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, errPickCancelled
+			}
+			return nil, err
+		}
+	}
+}
+
+// render draws the whole screen: the query line, the matches (the
+// highlighted one in reverse video, matched runes in bold) and the preview
+// of the highlighted worktree.
+func (p *picker) render(width, height int) []byte {
+	var b bytes.Buffer
+	b.WriteString("\x1b[H\x1b[2J")
+	listLines := max(1, height-2-pickerPreviewLines)
+
+	if p.cursor < p.offset {
+		p.offset = p.cursor
+	} else if p.cursor >= p.offset+listLines {
+		p.offset = p.cursor - listLines + 1
+	}
+
+	prompt := "> " + string(p.query)
+	counter := fmt.Sprintf("%d/%d", len(p.matches), len(p.items))
+	b.WriteString(truncateRunes(prompt, width-len(counter)-1))
+	if pad := width - utf8.RuneCountInString(prompt) - len(counter); pad > 0 {
+		b.WriteString(strings.Repeat(" ", pad))
+	} else {
+		b.WriteString(" ")
+	}
+	b.WriteString("\x1b[2m" + counter + "\x1b[0m\r\n")
+
+	for row := 0; row < listLines; row++ {
+		i := p.offset + row
+		if i < len(p.matches) {
+			m := p.matches[i]
+			marker := "  "
+			if i == p.cursor {
+				marker = "\x1b[7m> "
+			}
+			b.WriteString(marker + highlightRunes(p.items[m.index].text(), m.positions, width-2) + "\x1b[0m")
+		}
+		b.WriteString("\x1b[K\r\n")
+	}
+
+	b.WriteString("\x1b[2m" + strings.Repeat("─", max(0, width)) + "\x1b[0m\r\n")
+	if len(p.matches) > 0 {
+		item := p.items[p.matches[p.cursor].index]
+		lines, ok := p.cache[item.path]
+		if !ok {
+			lines = p.preview(item)
+			p.cache[item.path] = lines
+		}
+		for i, line := range lines {
+			if i == pickerPreviewLines {
+				break
+			}
+			b.WriteString(truncateRunes(line, width))
+			if i < pickerPreviewLines-1 {
+				b.WriteString("\r\n")
+			}
+		}
+	}
+	// Leave the cursor at the end of the query.
+	fmt.Fprintf(&b, "\x1b[1;%dH", min(width, utf8.RuneCountInString(prompt)+1))
+	return b.Bytes()
+}
+
+// truncateRunes cuts s to at most n runes.
+func truncateRunes(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+// highlightRunes renders s cut to n runes with the runes at positions in
+// bold, leaving other attributes (reverse video) alone.
+func highlightRunes(s string, positions []int, n int) string {
+	bold := map[int]bool{}
+	for _, i := range positions {
+		bold[i] = true
+	}
+	var b strings.Builder
+	for i, r := range []rune(truncateRunes(s, n)) {
+		if bold[i] {
+			b.WriteString("\x1b[1m" + string(r) + "\x1b[22m")
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestParsePickerKeys(t *testing.T) {
+	keys := parsePickerKeys([]byte("a\x1b[B\x1b[1;5A\x7fé\r\x15\x03\x1b"))
+	want := []pickerKey{
+		{code: keyRune, r: 'a'},
+		{code: keyDown},
+		{code: keyUp},
+		{code: keyBackspace},
+		{code: keyRune, r: 'é'},
+		{code: keyEnter},
+		{code: keyClear},
+		{code: keyCancel},
+		{code: keyCancel},
+	}
+	if len(keys) != len(want) {
+		t.Fatalf("got %+v, want %+v", keys, want)
+	}
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Fatalf("key %d: got %+v, want %+v", i, keys[i], want[i])
+		}
+	}
+}
+
+// chunkReader returns one chunk per Read, as a terminal delivers keys.
+type chunkReader []string
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(*r) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, (*r)[0])
+	*r = (*r)[1:]
+	return n, nil
+}
+
+func testPickerItems() []worktreeItem {
+	return []worktreeItem{
+		{label: "@", path: "/src/app", branch: "main"},
+		{label: "feature/JIRA-1234-login-fix", path: "/wt/feature/JIRA-1234-login-fix", branch: "feature/JIRA-1234-login-fix"},
+		{label: "feature/JIRA-1243-logout", path: "/wt/feature/JIRA-1243-logout", branch: "feature/JIRA-1243-logout"},
+	}
+}
+
+func TestPickerRun(t *testing.T) {
+	var previewed []string
+	p := newPicker(testPickerItems(), func(item worktreeItem) []string {
+		previewed = append(previewed, item.path)
+		return []string{"branch: " + item.branch}
+	})
+	size := func() (int, int) { return 60, 12 }
+
+	var screen bytes.Buffer
+	item, err := p.run(&chunkReader{"f", "eat", "\x1b[B", "\r"}, &screen, size)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	// The shorter name ranks first on a tie, so Down selects the other one.
+	if item.path != "/wt/feature/JIRA-1234-login-fix" {
+		t.Fatalf("unexpected pick: %+v", item)
+	}
+	out := screen.String()
+	if !strings.HasPrefix(out, "\x1b[?1049h") || !strings.HasSuffix(out, "\x1b[?1049l") {
+		t.Fatalf("expected the alternate screen to be entered and left")
+	}
+	if !strings.Contains(out, "2/3") || !strings.Contains(out, "branch: feature/JIRA-1234-login-fix") {
+		t.Fatalf("missing counter or preview in %q", out)
+	}
+	// Previews are computed once per worktree.
+	if len(previewed) != 3 {
+		t.Fatalf("unexpected previews: %q", previewed)
+	}
+
+	p = newPicker(testPickerItems(), func(worktreeItem) []string { return nil })
+	if _, err := p.run(strings.NewReader("zzz\r\x03"), &bytes.Buffer{}, size); err != errPickCancelled {
+		t.Fatalf("expected a cancelled pick, got %v", err)
+	}
+	p = newPicker(testPickerItems(), func(worktreeItem) []string { return nil })
+	if _, err := p.run(strings.NewReader("main"), &bytes.Buffer{}, size); err != errPickCancelled {
+		t.Fatalf("expected end of input to cancel, got %v", err)
+	}
+}
+
+func TestPickerMatchesBranchOfMainWorktree(t *testing.T) {
+	p := newPicker(testPickerItems(), func(worktreeItem) []string { return nil })
+	for _, r := range "main" {
+		p.handle(pickerKey{code: keyRune, r: r})
+	}
+	item, done := p.handle(pickerKey{code: keyEnter})
+	if !done || item == nil || item.label != "@" {
+		t.Fatalf("unexpected pick: %+v", item)
+	}
+}
+
+func TestRunExternalPicker(t *testing.T) {
+	items := testPickerItems()
+	path, err := runExternalPicker([]string{"sh", "-c", "grep 1243"}, items)
+	if err != nil || path != "/wt/feature/JIRA-1243-logout" {
+		t.Fatalf("unexpected result: %q, %v", path, err)
+	}
+	if _, err := runExternalPicker([]string{"sh", "-c", "exit 130"}, items); err != errPickCancelled {
+		t.Fatalf("expected a cancelled pick, got %v", err)
+	}
+	if _, err := runExternalPicker([]string{"sh", "-c", "echo nope"}, items); err == nil || !strings.Contains(err.Error(), "unknown worktree") {
+		t.Fatalf("expected an unknown worktree error, got %v", err)
+	}
+}
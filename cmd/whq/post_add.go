@@ -4,28 +4,112 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"io/fs"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 )
 
 type whqConfig struct {
-	PostAdd *postAddConfig `json:"post_add"`
+	// Version is the schema version the file was written for; older files
+	// are migrated when loaded (see config_version.go).
+	Version int `json:"version"`
+	// ConfigSource chooses which .whq.json worktree hooks use; only honored
+	// in the main worktree's file. See config_source.go.
+	ConfigSource string `json:"config_source"`
+	// Picker configures `whq pick`; see picker.go.
+	Picker *pickerConfig `json:"picker"`
+	// Scripts are the commands `whq run` runs by name; see scripts.go.
+	Scripts map[string]*scriptEntry `json:"scripts"`
+
+	PreAdd    *hookConfig    `json:"pre_add"`
+	PostAdd   *postAddConfig `json:"post_add"`
+	PreRm     *hookConfig    `json:"pre_rm"`
+	PostRm    *hookConfig    `json:"post_rm"`
+	PostPrune *hookConfig    `json:"post_prune"`
 }
 
 type postAddConfig struct {
-	Copy     []string `json:"copy"`
-	Commands []string `json:"commands"`
+	Copy        []copyEntry  `json:"copy"`
+	CopyOptions copySettings `json:"copy_options"`
+	hookConfig
+}
+
+// copySettings apply to every copy entry of post_add.
+type copySettings struct {
+	copyMetaOptions
+	// OnTracked decides what happens when a copy would overwrite files
+	// tracked in the new worktree: "warn" (default) or "refuse".
+	OnTracked string `json:"on_tracked"`
+	// ExcludeUntracked decides whether copies that git neither tracks nor
+	// ignores are added to info/exclude: "ask" (default), "always", "never".
+	ExcludeUntracked string `json:"exclude_untracked"`
+}
+
+// copyEntry is a post-add copy item. It is written either as a bare relative
+// path or as an object whose options override post_add.copy_options.
+type copyEntry struct {
+	// Path is relative to the repository root.
+	Path string `json:"path"`
+	// FromUser is relative to the per-user files directory (see
+	// userFilesDir) and is used instead of Path.
+	FromUser string `json:"from_user"`
+	// Optional skips the entry when its source does not exist.
+	Optional bool           `json:"optional"`
+	When     *whenCondition `json:"when"`
+	copyMetaOptions
+}
+
+// copyMetaOptions controls which metadata survives a copy. Unset fields
+// inherit from the enclosing scope.
+type copyMetaOptions struct {
+	PreserveTimes  *bool `json:"preserve_times,omitempty"`
+	PreserveXattrs *bool `json:"preserve_xattrs,omitempty"`
+	PreserveOwner  *bool `json:"preserve_owner,omitempty"`
+	Dereference    *bool `json:"dereference,omitempty"`
 }
 
-func runPostAddActions(repoRoot, worktreeRoot string) error {
+func (e *copyEntry) UnmarshalJSON(data []byte) error {
+	var path string
+	if err := json.Unmarshal(data, &path); err == nil {
+		*e = copyEntry{Path: path}
+		return nil
+	}
+	type plain copyEntry
+	var p plain
+	if err := json.Unmarshal(data, &p); err != nil {
+		return fmt.Errorf("copy entry must be a path or an object: %w", err)
+	}
+	*e = copyEntry(p)
+	return nil
+}
+
+func (o copyMetaOptions) apply(base copyOptions) copyOptions {
+	if o.PreserveTimes != nil {
+		base.PreserveTimes = *o.PreserveTimes
+	}
+	if o.PreserveXattrs != nil {
+		base.PreserveXattrs = *o.PreserveXattrs
+	}
+	if o.PreserveOwner != nil {
+		base.PreserveOwner = *o.PreserveOwner
+	}
+	if o.Dereference != nil {
+		base.Dereference = *o.Dereference
+	}
+	return base
+}
+
+func runPostAddActions(repoRoot, worktreeRoot, branch string) error {
 	cfg, err := loadWHQConfig(repoRoot)
 	if err != nil {
 		return err
 	}
+	return runPostAdd(cfg, repoRoot, worktreeRoot, branch)
+}
+
+// runPostAdd runs the post_add section of cfg, wherever it was loaded from.
+// Copy sources stay relative to the main worktree at repoRoot.
+func runPostAdd(cfg *whqConfig, repoRoot, worktreeRoot, branch string) error {
 	if cfg == nil || cfg.PostAdd == nil {
 		return nil
 	}
@@ -36,12 +120,36 @@ func runPostAddActions(repoRoot, worktreeRoot string) error {
 		return nil
 	}
 
+	// Reject malformed conditions and step graphs before touching the
+	// worktree.
+	for _, entry := range cfg.PostAdd.Copy {
+		if err := entry.When.validate(); err != nil {
+			return err
+		}
+	}
+	graph, err := cfg.PostAdd.plan()
+	if err != nil {
+		return err
+	}
+	if cmdTotal > 0 {
+		if err := ensureTrusted(cfg, repoRoot); err != nil {
+			return err
+		}
+	}
+	hc := hookContext{
+		Hook:         hookPostAdd,
+		RepoRoot:     repoRoot,
+		WorktreeRoot: worktreeRoot,
+		Branch:       branch,
+		Dir:          worktreeRoot,
+	}
+
 	fmt.Fprintf(os.Stdout, "Post-add (.whq.json): starting (copy=%d, commands=%d)\n", copyTotal, cmdTotal)
 
-	if err := executePostAddCopies(cfg.PostAdd.Copy, repoRoot, worktreeRoot); err != nil {
+	if err := executePostAddCopies(cfg.PostAdd, hc); err != nil {
 		return err
 	}
-	if err := executePostAddCommands(cfg.PostAdd.Commands, worktreeRoot); err != nil {
+	if err := cfg.PostAdd.run(graph, hookPhases[hookPostAdd], hc); err != nil {
 		return err
 	}
 
@@ -49,38 +157,55 @@ func runPostAddActions(repoRoot, worktreeRoot string) error {
 	return nil
 }
 
+// loadWHQConfig returns the merged configuration of repoRoot (see config.go),
+// or nil when no configuration file exists.
 func loadWHQConfig(repoRoot string) (*whqConfig, error) {
-	cfgPath := filepath.Join(repoRoot, ".whq.json")
-	data, err := os.ReadFile(cfgPath)
-	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return nil, nil
-		}
-		return nil, fmt.Errorf("whq: failed to read .whq.json: %w", err)
-	}
+	return loadConfigLayers(repoRoot, nil)
+}
+
+// parseWHQConfig decodes .whq.json content; origin names the file in errors.
+func parseWHQConfig(data []byte, origin string) (*whqConfig, error) {
 	var cfg whqConfig
 	if err := json.Unmarshal(data, &cfg); err != nil {
-		return nil, fmt.Errorf("whq: invalid .whq.json: %w", err)
+		return nil, fmt.Errorf("whq: invalid %s: %w", origin, err)
 	}
 	return &cfg, nil
 }
 
-func executePostAddCopies(entries []string, repoRoot, worktreeRoot string) error {
-	if len(entries) == 0 {
+func executePostAddCopies(cfg *postAddConfig, hc hookContext) error {
+	if len(cfg.Copy) == 0 {
 		return nil
 	}
-	for _, raw := range entries {
-		item := strings.TrimSpace(raw)
-		if item == "" {
-			return errors.New("whq: post-add copy entry cannot be empty")
+	repoRoot, worktreeRoot := hc.RepoRoot, hc.WorktreeRoot
+	if err := validateCopyHygiene(cfg); err != nil {
+		return err
+	}
+	checkGit := isGitWorktree(worktreeRoot)
+	var untracked []string
+
+	base := cfg.CopyOptions.apply(copyOptions{})
+	for _, entry := range cfg.Copy {
+		item, srcRoot, label, err := resolveCopySource(entry, repoRoot)
+		if err != nil {
+			return err
+		}
+		if ok, reason := entry.When.check(hc); !ok {
+			fmt.Fprintf(os.Stdout, "Post-add copy: %s (skipped: %s)\n", label, reason)
+			continue
 		}
 
-		src, err := safeJoin(repoRoot, item)
+		src, err := safeJoin(srcRoot, item)
 		if err != nil {
 			return fmt.Errorf("whq: invalid post-add copy path %q: %w", item, err)
 		}
-		if _, err := os.Lstat(src); err != nil {
-			return fmt.Errorf("whq: post-add copy source %q not found: %w", item, err)
+		opts := entry.apply(base)
+		srcInfo, err := opts.stat(src)
+		if err != nil {
+			if entry.Optional && errors.Is(err, os.ErrNotExist) {
+				fmt.Fprintf(os.Stdout, "Post-add copy: %s (skipped: not found)\n", label)
+				continue
+			}
+			return fmt.Errorf("whq: post-add copy source %q not found: %w", label, err)
 		}
 
 		dest, err := safeJoin(worktreeRoot, item)
@@ -88,34 +213,52 @@ func executePostAddCopies(entries []string, repoRoot, worktreeRoot string) error
 			return fmt.Errorf("whq: invalid destination for copy %q: %w", item, err)
 		}
 
-		fmt.Fprintf(os.Stdout, "Post-add copy: %s\n", item)
-		if err := copyPath(src, dest); err != nil {
+		if checkGit {
+			state, err := classifyCopyDest(worktreeRoot, item, srcInfo.IsDir())
+			if err != nil {
+				return fmt.Errorf("whq: failed to inspect copy destination %q: %w", item, err)
+			}
+			switch state {
+			case copyDestTracked:
+				if cfg.CopyOptions.OnTracked == onTrackedRefuse {
+					return fmt.Errorf("whq: post-add copy %q would overwrite files tracked in the new worktree", item)
+				}
+				fmt.Fprintf(os.Stderr, "Post-add copy: warning: %s is tracked in the new worktree and will be overwritten\n", item)
+			case copyDestUntracked:
+				untracked = append(untracked, excludePattern(item, srcInfo.IsDir()))
+			}
+		}
+
+		fmt.Fprintf(os.Stdout, "Post-add copy: %s\n", label)
+		c := newCopier(opts)
+		stopProgress := c.reportProgress(os.Stdout, item, copyProgressInterval)
+		err = c.copyPath(src, dest)
+		stopProgress()
+		if err != nil {
 			return fmt.Errorf("whq: failed to copy %q: %w", item, err)
 		}
 	}
-	return nil
+	return excludeUntrackedCopies(worktreeRoot, cfg.CopyOptions.ExcludeUntracked, untracked)
 }
 
-func executePostAddCommands(commands []string, worktreeRoot string) error {
-	if len(commands) == 0 {
-		return nil
-	}
-	for i, raw := range commands {
-		cmdStr := strings.TrimSpace(raw)
-		if cmdStr == "" {
-			return fmt.Errorf("whq: post-add command %d is empty", i+1)
-		}
-
-		fmt.Fprintf(os.Stdout, "Post-add cmd %d/%d: %s\n", i+1, len(commands), cmdStr)
-		cmd := exec.Command("bash", "-lc", cmdStr)
-		cmd.Dir = worktreeRoot
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("whq: post-add command failed (%s): %w", cmdStr, err)
+// resolveCopySource returns the entry's relative path, the root it is
+// relative to, and the label used in progress output.
+func resolveCopySource(entry copyEntry, repoRoot string) (item, root, label string, err error) {
+	path := strings.TrimSpace(entry.Path)
+	fromUser := strings.TrimSpace(entry.FromUser)
+	switch {
+	case path != "" && fromUser != "":
+		return "", "", "", fmt.Errorf("whq: post-add copy entry %q cannot set both path and from_user", path)
+	case fromUser != "":
+		root, err := userFilesDir(env.Host, env.Owner, env.Project)
+		if err != nil {
+			return "", "", "", err
 		}
+		return fromUser, root, fromUser + " (from user files)", nil
+	case path != "":
+		return path, repoRoot, path, nil
 	}
-	return nil
+	return "", "", "", errors.New("whq: post-add copy entry cannot be empty")
 }
 
 func safeJoin(root, rel string) (string, error) {
@@ -127,111 +270,11 @@ func safeJoin(root, rel string) (string, error) {
 		return "", fmt.Errorf("path resolves to root")
 	}
 	if strings.HasPrefix(clean, ".."+string(filepath.Separator)) || clean == ".." {
-		return "", fmt.Errorf("path escapes repository root")
+		return "", fmt.Errorf("path escapes %s", root)
 	}
 	return filepath.Join(root, clean), nil
 }
 
-func copyPath(src, dest string) error {
-	info, err := os.Lstat(src)
-	if err != nil {
-		return err
-	}
-	if info.Mode()&os.ModeSymlink != 0 {
-		return copySymlink(src, dest)
-	}
-	if info.IsDir() {
-		return copyDirectory(src, dest)
-	}
-	return copyFile(src, dest, info.Mode().Perm())
-}
-
-func copySymlink(src, dest string) error {
-	if err := os.RemoveAll(dest); err != nil && !errors.Is(err, os.ErrNotExist) {
-		return err
-	}
-	target, err := os.Readlink(src)
-	if err != nil {
-		return err
-	}
-	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
-		return err
-	}
-	return os.Symlink(target, dest)
-}
-
-func copyDirectory(src, dest string) error {
-	info, err := os.Stat(src)
-	if err != nil {
-		return err
-	}
-	if !info.IsDir() {
-		return fmt.Errorf("source is not a directory: %s", src)
-	}
-	if err := os.RemoveAll(dest); err != nil && !errors.Is(err, os.ErrNotExist) {
-		return err
-	}
-	if err := os.MkdirAll(dest, info.Mode().Perm()); err != nil {
-		return err
-	}
-	return filepath.WalkDir(src, func(path string, d fs.DirEntry, walkErr error) error {
-		if walkErr != nil {
-			return walkErr
-		}
-		if path == src {
-			return nil
-		}
-		rel, err := filepath.Rel(src, path)
-		if err != nil {
-			return err
-		}
-		target := filepath.Join(dest, rel)
-		if d.IsDir() {
-			info, err := d.Info()
-			if err != nil {
-				return err
-			}
-			return os.MkdirAll(target, info.Mode().Perm())
-		}
-
-		info, err := d.Info()
-		if err != nil {
-			return err
-		}
-		if info.Mode()&os.ModeSymlink != 0 {
-			return copySymlink(path, target)
-		}
-		return copyFile(path, target, info.Mode().Perm())
-	})
-}
-
-func copyFile(src, dest string, perm fs.FileMode) error {
-	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
-		return err
-	}
-	if err := os.RemoveAll(dest); err != nil && !errors.Is(err, os.ErrNotExist) {
-		return err
-	}
-	in, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer in.Close()
-
-	out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
-	if err != nil {
-		return err
-	}
-	defer func() {
-		_ = out.Close()
-	}()
-
-	if _, err := io.Copy(out, in); err != nil {
-		return err
-	}
-	return nil
-}
-
 func cleanupFailedAdd(repoRoot, worktreeRoot, branch string, removeBranch bool) error {
 	fmt.Fprintf(os.Stderr, "Post-add failed: removing worktree %s\n", worktreeRoot)
 
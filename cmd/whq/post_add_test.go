@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestRunPostAddActionsCopiesAndCommands(t *testing.T) {
@@ -21,7 +22,7 @@ func TestRunPostAddActionsCopiesAndCommands(t *testing.T) {
 	writeFile(t, filepath.Join(repoRoot, "configs", "app.env"), "FOO=bar\n")
 	writeFile(t, filepath.Join(repoRoot, "scripts", "setup.sh"), "#!/bin/sh\necho ok\n")
 
-	if err := runPostAddActions(repoRoot, worktreeRoot); err != nil {
+	if err := runPostAddActions(repoRoot, worktreeRoot, "demo"); err != nil {
 		t.Fatalf("expected success, got error: %v", err)
 	}
 
@@ -46,6 +47,82 @@ func TestRunPostAddActionsCopiesAndCommands(t *testing.T) {
 	}
 }
 
+func TestRunPostAddActionsCopyEntryOptions(t *testing.T) {
+	repoRoot := t.TempDir()
+	worktreeRoot := t.TempDir()
+
+	writeFile(t, filepath.Join(repoRoot, ".whq.json"), `{
+		"post_add": {
+			"copy_options": {"preserve_times": true},
+			"copy": [
+				"stamp.txt",
+				{"path": "link.txt", "dereference": true, "preserve_times": false}
+			]
+		}
+	}`)
+	writeFile(t, filepath.Join(repoRoot, "stamp.txt"), "stamp")
+	writeFile(t, filepath.Join(repoRoot, "real.txt"), "real")
+	if err := os.Symlink("real.txt", filepath.Join(repoRoot, "link.txt")); err != nil {
+		t.Fatalf("symlink failed: %v", err)
+	}
+	old := time.Date(2021, 6, 7, 8, 9, 10, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(repoRoot, "stamp.txt"), old, old); err != nil {
+		t.Fatalf("chtimes failed: %v", err)
+	}
+
+	if err := runPostAddActions(repoRoot, worktreeRoot, "demo"); err != nil {
+		t.Fatalf("expected success, got error: %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(worktreeRoot, "stamp.txt"))
+	if err != nil || !info.ModTime().Equal(old) {
+		t.Fatalf("expected preserved mtime, got %v, %v", info, err)
+	}
+	linkInfo, err := os.Lstat(filepath.Join(worktreeRoot, "link.txt"))
+	if err != nil || linkInfo.Mode()&os.ModeSymlink != 0 {
+		t.Fatalf("expected dereferenced regular file, got %v, %v", linkInfo, err)
+	}
+}
+
+func TestRunPostAddActionsCopiesFromUserFiles(t *testing.T) {
+	repoRoot := t.TempDir()
+	worktreeRoot := t.TempDir()
+	configHome := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configHome)
+	origEnv := env
+	env.Host, env.Owner, env.Project = "github.com", "acme", "app"
+	t.Cleanup(func() { env = origEnv })
+
+	writeFile(t, filepath.Join(repoRoot, ".whq.json"), `{
+		"post_add": {
+			"copy": [
+				{"from_user": ".env", "optional": true},
+				{"from_user": "missing.key", "optional": true}
+			]
+		}
+	}`)
+	writeFile(t, filepath.Join(configHome, "whq", "files", "github.com", "acme", "app", ".env"), "TOKEN=secret\n")
+
+	if err := runPostAddActions(repoRoot, worktreeRoot, "demo"); err != nil {
+		t.Fatalf("expected success, got error: %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(worktreeRoot, ".env"))
+	if err != nil || string(got) != "TOKEN=secret\n" {
+		t.Fatalf("user file mismatch: %q, %v", got, err)
+	}
+	if _, err := os.Stat(filepath.Join(worktreeRoot, "missing.key")); !os.IsNotExist(err) {
+		t.Fatalf("optional missing entry should be skipped, err=%v", err)
+	}
+
+	writeFile(t, filepath.Join(repoRoot, ".whq.json"), `{
+		"post_add": {"copy": [{"from_user": "../../other/app/.env"}]}
+	}`)
+	err = runPostAddActions(repoRoot, worktreeRoot, "demo")
+	if err == nil || !strings.Contains(err.Error(), "invalid post-add copy path") {
+		t.Fatalf("expected traversal error, got %v", err)
+	}
+}
+
 func TestRunPostAddActionsMissingCopy(t *testing.T) {
 	repoRoot := t.TempDir()
 	worktreeRoot := t.TempDir()
@@ -56,7 +133,7 @@ func TestRunPostAddActionsMissingCopy(t *testing.T) {
 		}
 	}`)
 
-	err := runPostAddActions(repoRoot, worktreeRoot)
+	err := runPostAddActions(repoRoot, worktreeRoot, "demo")
 	if err == nil || !strings.Contains(err.Error(), "not found") {
 		t.Fatalf("expected not found error, got %v", err)
 	}
@@ -72,13 +149,13 @@ func TestRunPostAddActionsCommandFailure(t *testing.T) {
 		}
 	}`)
 
-	err := runPostAddActions(repoRoot, worktreeRoot)
+	err := runPostAddActions(repoRoot, worktreeRoot, "demo")
 	if err == nil || !strings.Contains(err.Error(), "post-add command failed") {
 		t.Fatalf("expected command failure, got %v", err)
 	}
 }
 
-func writeFile(t *testing.T, path, content string) {
+func writeFile(t testing.TB, path, content string) {
 	t.Helper()
 	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
 		t.Fatalf("mkdir failed: %v", err)
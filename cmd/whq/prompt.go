@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+var errNotInteractive = errors.New("whq: confirmation required but stdin is not a terminal")
+
+// Prompt I/O is swapped out by tests.
+var (
+	promptIn          io.Reader = os.Stdin
+	promptOut         io.Writer = os.Stderr
+	promptInteractive           = func() bool { return isTerminal(os.Stdin) }
+)
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// confirm asks a yes/no question, defaulting to no. It returns
+// errNotInteractive when there is nobody to ask.
+func confirm(question string) (bool, error) {
+	return confirmDefault(question, false)
+}
+
+// confirmDefault is confirm with def as the answer to an empty line.
+func confirmDefault(question string, def bool) (bool, error) {
+	if !promptInteractive() {
+		return false, errNotInteractive
+	}
+	hint := "[y/N]"
+	if def {
+		hint = "[Y/n]"
+	}
+	fmt.Fprintf(promptOut, "%s %s ", question, hint)
+	line, err := readPromptLine(promptIn)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return true, nil
+	case "":
+		return def && err == nil, nil
+	}
+	return false, nil
+}
+
+// readPromptLine reads up to a newline one byte at a time, so consecutive
+// prompts sharing promptIn never lose buffered input.
+func readPromptLine(r io.Reader) (string, error) {
+	var line []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if buf[0] == '\n' {
+				return string(line), nil
+			}
+			line = append(line, buf[0])
+		}
+		if err != nil {
+			return string(line), err
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	defaultPromptFormat = "%n%d (%c)"
+	// promptCacheTTL bounds how long a file edited without running git can
+	// go unnoticed by the dirty marker.
+	promptCacheTTL = 10 * time.Second
+)
+
+var (
+	promptFormat string
+
+	promptCmd = &cobra.Command{
+		Use:   "prompt [--format <format>]",
+		Short: "Print a short worktree status for shell prompts",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 0 {
+				return errors.New("Usage: whq prompt [--format <format>]")
+			}
+			if err := checkPromptFormat(promptFormat); err != nil {
+				return err
+			}
+			return runPrompt(promptFormat, time.Now(), os.Stdout)
+		},
+	}
+)
+
+func init() {
+	promptCmd.Flags().StringVar(&promptFormat, "format", defaultPromptFormat, "Segment format: %n name, %b branch, %d dirty marker, %c worktree count, %% a percent sign")
+}
+
+// promptInfo is what `whq prompt` shows about the worktree containing the
+// working directory.
+type promptInfo struct {
+	Key     string    `json:"key"`
+	Checked time.Time `json:"checked"`
+	Name    string    `json:"name"`
+	Branch  string    `json:"branch,omitempty"`
+	Dirty   bool      `json:"dirty,omitempty"`
+	Count   int       `json:"count"`
+}
+
+// promptCache maps a worktree's git directory to its promptInfo.
+type promptCache map[string]*promptInfo
+
+func promptCachePath() string {
+	return filepath.Join(env.WHQRoot, ".whq", "prompt.json")
+}
+
+// runPrompt prints the segment for the worktree containing the working
+// directory, and nothing outside a repository. The answer comes from the
+// cache while neither the worktree's git directory nor the repository's
+// list of worktrees changed and it is younger than promptCacheTTL; only
+// then does whq run git.
+func runPrompt(format string, now time.Time, stdout io.Writer) error {
+	gitDir, key, ok := promptKey()
+	if !ok {
+		return nil
+	}
+	cache := loadPromptCache()
+	info := cache[gitDir]
+	if info == nil || info.Key != key || now.Sub(info.Checked) > promptCacheTTL || now.Before(info.Checked) {
+		var err error
+		if info, err = collectPromptInfo(); err != nil {
+			// A prompt has nowhere to show errors.
+			return nil
+		}
+		info.Key, info.Checked = key, now
+		cache[gitDir] = info
+		cache.save()
+	}
+	fmt.Fprintln(stdout, formatPrompt(format, info))
+	return nil
+}
+
+// promptKey finds the git directory of the worktree containing the working
+// directory without running git, and derives the cache key from its mtime
+// and that of the repository's worktrees directory.
+func promptKey() (gitDir, key string, ok bool) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", "", false
+	}
+	for {
+		dotGit := filepath.Join(dir, ".git")
+		if st, err := os.Stat(dotGit); err == nil {
+			if st.IsDir() {
+				gitDir = dotGit
+			} else if gitDir = readGitFile(dotGit); gitDir == "" {
+				return "", "", false
+			}
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", "", false
+		}
+		dir = parent
+	}
+
+	commonDir := gitDir
+	if data, err := os.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+		commonDir = strings.TrimSpace(string(data))
+		if !filepath.IsAbs(commonDir) {
+			commonDir = filepath.Join(gitDir, commonDir)
+		}
+	}
+	st, err := os.Stat(gitDir)
+	if err != nil {
+		return "", "", false
+	}
+	key = strconv.FormatInt(st.ModTime().UnixNano(), 10)
+	if st, err := os.Stat(filepath.Join(commonDir, "worktrees")); err == nil {
+		key += ":" + strconv.FormatInt(st.ModTime().UnixNano(), 10)
+	}
+	return gitDir, key, true
+}
+
+// readGitFile returns the git directory a linked worktree's .git file
+// points to, or "" when it is not one.
+func readGitFile(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	dir, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir: ")
+	if !ok {
+		return ""
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(filepath.Dir(path), dir)
+	}
+	return filepath.Clean(dir)
+}
+
+// collectPromptInfo asks git, the slow way.
+func collectPromptInfo() (*promptInfo, error) {
+	if err := initEnv(); err != nil && env.RepoRoot == "" {
+		return nil, err
+	}
+	items, err := worktreeItems()
+	if err != nil {
+		return nil, err
+	}
+	wt, err := currentWorktree()
+	if err != nil {
+		return nil, err
+	}
+	// Without optional locks, git status neither touches the git directory
+	// (which would invalidate the cache) nor gets in the user's way.
+	c := exec.Command("git", "status", "--porcelain")
+	c.Dir = wt.path
+	c.Env = append(os.Environ(), "GIT_OPTIONAL_LOCKS=0")
+	status, err := c.Output()
+	if err != nil {
+		return nil, fmt.Errorf("whq: git status failed in %s: %w", wt.path, err)
+	}
+	name := wt.label
+	if filepath.IsAbs(name) {
+		// Outside repo_whq_root, or its identity is unknown.
+		name = filepath.Base(wt.path)
+	}
+	return &promptInfo{Name: name, Branch: wt.branch, Dirty: len(bytes.TrimSpace(status)) > 0, Count: len(items)}, nil
+}
+
+func checkPromptFormat(format string) error {
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		i++
+		if i == len(format) || !strings.ContainsRune("nbdc%", rune(format[i])) {
+			return fmt.Errorf("whq: invalid --format %q: use %%n, %%b, %%d, %%c or %%%%", format)
+		}
+	}
+	return nil
+}
+
+// formatPrompt expands the placeholders of a format checkPromptFormat
+// accepted.
+func formatPrompt(format string, info *promptInfo) string {
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			b.WriteByte(format[i])
+			continue
+		}
+		i++
+		switch format[i] {
+		case 'n':
+			b.WriteString(info.Name)
+		case 'b':
+			b.WriteString(info.Branch)
+		case 'd':
+			if info.Dirty {
+				b.WriteByte('*')
+			}
+		case 'c':
+			b.WriteString(strconv.Itoa(info.Count))
+		default:
+			b.WriteByte(format[i])
+		}
+	}
+	return b.String()
+}
+
+// loadPromptCache reads the cache; a missing or broken one is empty.
+func loadPromptCache() promptCache {
+	cache := promptCache{}
+	if data, err := os.ReadFile(promptCachePath()); err == nil {
+		_ = json.Unmarshal(data, &cache)
+	}
+	return cache
+}
+
+// save writes the cache, forgetting worktrees that no longer exist. Failures
+// only cost speed, so they are ignored.
+func (c promptCache) save() {
+	for gitDir := range c {
+		if !isDir(gitDir) {
+			delete(c, gitDir)
+		}
+	}
+	path := promptCachePath()
+	data, err := json.Marshal(c)
+	if err != nil || os.MkdirAll(filepath.Dir(path), 0o755) != nil {
+		return
+	}
+	// Prompts of several shells may write at once.
+	f, err := os.CreateTemp(filepath.Dir(path), "prompt-*.tmp")
+	if err != nil {
+		return
+	}
+	_, werr := f.Write(data)
+	if cerr := f.Close(); werr != nil || cerr != nil || os.Rename(f.Name(), path) != nil {
+		os.Remove(f.Name())
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// setupPromptRepo is setupResolveRepo with an origin, so that whq can work
+// out the repository's identity on a cache miss.
+func setupPromptRepo(t testing.TB) (repoRoot, whqRoot string) {
+	t.Helper()
+	repoRoot, whqRoot = setupResolveRepo(t)
+	runGit(t, repoRoot, "remote", "add", "origin", "https://example.com/acme/app.git")
+	t.Setenv("WHQ_ROOT", whqRoot)
+	return repoRoot, whqRoot
+}
+
+func TestPromptSegment(t *testing.T) {
+	repoRoot, whqRoot := setupPromptRepo(t)
+	hotfix := filepath.Join(whqRoot, "repo", "hotfix")
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	prompt := func(at time.Time) string {
+		t.Helper()
+		var out bytes.Buffer
+		if err := runPrompt("%n%d %b (%c)", at, &out); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+
+	t.Chdir(hotfix)
+	if got := prompt(now); got != "hotfix release/2.0-hotfix (4)\n" {
+		t.Fatalf("unexpected segment %q", got)
+	}
+	// Editing files does not touch the git directory: the cache answers
+	// until it expires.
+	writeFile(t, filepath.Join(hotfix, "new.txt"), "x")
+	if got := prompt(now.Add(time.Second)); got != "hotfix release/2.0-hotfix (4)\n" {
+		t.Fatalf("expected the cached segment, got %q", got)
+	}
+	if got := prompt(now.Add(promptCacheTTL + time.Second)); got != "hotfix* release/2.0-hotfix (4)\n" {
+		t.Fatalf("expected a dirty segment, got %q", got)
+	}
+	// A cache hit runs no git at all.
+	path := os.Getenv("PATH")
+	t.Setenv("PATH", "")
+	if got := prompt(now.Add(promptCacheTTL + 2*time.Second)); got != "hotfix* release/2.0-hotfix (4)\n" {
+		t.Fatalf("expected the cached segment without git, got %q", got)
+	}
+	t.Setenv("PATH", path)
+
+	// A new worktree changes the repository's worktrees directory.
+	runGit(t, repoRoot, "worktree", "add", "-q", "-b", "other", filepath.Join(whqRoot, "repo", "other"))
+	if got := prompt(now.Add(promptCacheTTL + 3*time.Second)); got != "hotfix* release/2.0-hotfix (5)\n" {
+		t.Fatalf("expected a new count, got %q", got)
+	}
+
+	t.Chdir(repoRoot)
+	if got := prompt(now); got != "@ main (5)\n" {
+		t.Fatalf("unexpected segment %q", got)
+	}
+	t.Chdir(t.TempDir())
+	if got := prompt(now); got != "" {
+		t.Fatalf("expected nothing outside a repository, got %q", got)
+	}
+}
+
+func TestPromptFormat(t *testing.T) {
+	info := &promptInfo{Name: "feature/x", Branch: "feature/x", Dirty: true, Count: 3}
+	for format, want := range map[string]string{
+		defaultPromptFormat: "feature/x* (3)",
+		"[%b|%c] 100%%":     "[feature/x|3] 100%",
+	} {
+		if err := checkPromptFormat(format); err != nil {
+			t.Fatalf("format %q: %v", format, err)
+		}
+		if got := formatPrompt(format, info); got != want {
+			t.Errorf("format %q: got %q, want %q", format, got, want)
+		}
+	}
+	for _, format := range []string{"%x", "50%"} {
+		if err := checkPromptFormat(format); err == nil {
+			t.Errorf("format %q should be rejected", format)
+		}
+	}
+}
+
+// BenchmarkPromptCached measures what a shell prompt pays on every command:
+// a cache hit, which must stay in the tens of microseconds.
+func BenchmarkPromptCached(b *testing.B) {
+	_, whqRoot := setupPromptRepo(b)
+	b.Chdir(filepath.Join(whqRoot, "repo", "hotfix"))
+	now := time.Now()
+	if err := runPrompt(defaultPromptFormat, now, io.Discard); err != nil {
+		b.Fatal(err)
+	}
+	for b.Loop() {
+		if err := runPrompt(defaultPromptFormat, now, io.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
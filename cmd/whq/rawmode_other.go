@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package main
+
+import "errors"
+
+func makeRaw(fd int) (func() error, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func terminalSize(fd int) (int, int, error) {
+	return 0, 0, errors.ErrUnsupported
+}
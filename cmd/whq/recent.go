@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	recentPaths  bool
+	recentScores bool
+
+	recentCmd = &cobra.Command{
+		Use:   "recent [-p] [-s]",
+		Short: "List used worktrees of every repository, most frecent first",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 0 {
+				return errors.New("Usage: whq recent [-p] [-s]")
+			}
+			state, err := loadWorktreeHistory()
+			if err != nil {
+				return err
+			}
+			for _, r := range rankWorktrees(state, nil, time.Now()) {
+				line := r.visits.display()
+				if recentPaths {
+					line = r.path
+				}
+				if recentScores {
+					line = fmt.Sprintf("%8.1f  %s", r.score, line)
+				}
+				fmt.Fprintln(os.Stdout, line)
+			}
+			return nil
+		},
+	}
+
+	jumpCmd = &cobra.Command{
+		Use:   "jump <query...>",
+		Short: "Print the path of the most frecent worktree matching the query",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("Usage: whq jump <query...>")
+			}
+			return runJump(args, time.Now(), os.Stdout)
+		},
+	}
+)
+
+func init() {
+	recentCmd.Flags().BoolVarP(&recentPaths, "paths", "p", false, "Print absolute paths")
+	recentCmd.Flags().BoolVarP(&recentScores, "score", "s", false, "Print each worktree's frecency score")
+}
+
+// loadWorktreeHistory is loadWorktreeState for `whq recent` and `whq jump`,
+// which can do without history: a corrupt file counts as empty, with a
+// warning, and the next save replaces it.
+func loadWorktreeHistory() (*worktreeState, error) {
+	state, err := loadWorktreeState()
+	if errors.Is(err, errInvalidState) {
+		warnStateError(err)
+		return &worktreeState{Repos: map[string]*repoWorktreeState{}, Worktrees: map[string]*worktreeVisits{}}, nil
+	}
+	return state, err
+}
+
+// runJump prints the best match for terms and records the visit.
+func runJump(terms []string, now time.Time, stdout io.Writer) error {
+	state, err := loadWorktreeHistory()
+	if err != nil {
+		return err
+	}
+	ranked := rankWorktrees(state, terms, now)
+	if len(ranked) == 0 {
+		return fmt.Errorf("whq: no recent worktree matches '%s'", strings.Join(terms, " "))
+	}
+	// Like cd-ing to where you already are, the worktree you are in is only
+	// picked when nothing else matches.
+	best := ranked[0]
+	if len(ranked) > 1 && cwdWithin(best.path) {
+		best = ranked[1]
+	}
+
+	state.forgetMissing()
+	state.use(best.visits.RepoRoot, best.path)
+	state.visit(*best.visits, best.path, now)
+	if err := state.save(); err != nil {
+		warnStateError(err)
+	}
+	fmt.Fprintln(stdout, best.path)
+	return nil
+}
+
+// maxVisitRank bounds the sum of all ranks: past it, every rank is scaled
+// down and worktrees falling below 1 are forgotten, so old habits fade.
+const maxVisitRank = 1000
+
+// worktreeVisits is how often and how recently a worktree was used.
+type worktreeVisits struct {
+	// Repo is <host>/<owner>/<project>.
+	Repo     string `json:"repo"`
+	RepoRoot string `json:"repo_root"`
+	// Name is the worktree as `whq list` prints it.
+	Name       string    `json:"name"`
+	Rank       float64   `json:"rank"`
+	LastAccess time.Time `json:"last_access"`
+}
+
+// display names the worktree across repositories: <repo>:<name>.
+func (v *worktreeVisits) display() string {
+	return v.Repo + ":" + v.Name
+}
+
+// frecency weighs the rank by how recently the worktree was used, as zoxide
+// does.
+func (v *worktreeVisits) frecency(now time.Time) float64 {
+	switch age := now.Sub(v.LastAccess); {
+	case age < time.Hour:
+		return v.Rank * 4
+	case age < 24*time.Hour:
+		return v.Rank * 2
+	case age < 7*24*time.Hour:
+		return v.Rank / 2
+	}
+	return v.Rank / 4
+}
+
+// visit counts one use of the worktree at path, described by v.
+func (s *worktreeState) visit(v worktreeVisits, path string, now time.Time) {
+	v.Rank = 1
+	if old := s.Worktrees[path]; old != nil {
+		v.Rank = old.Rank + 1
+	}
+	v.LastAccess = now.UTC()
+	s.Worktrees[path] = &v
+
+	total := 0.0
+	for _, w := range s.Worktrees {
+		total += w.Rank
+	}
+	if total <= maxVisitRank {
+		return
+	}
+	factor := 0.9 * maxVisitRank / total
+	for p, w := range s.Worktrees {
+		w.Rank *= factor
+		if w.Rank < 1 {
+			delete(s.Worktrees, p)
+		}
+	}
+}
+
+// forgetMissing drops the worktrees that no longer exist.
+func (s *worktreeState) forgetMissing() {
+	for p := range s.Worktrees {
+		if !isDir(p) {
+			delete(s.Worktrees, p)
+		}
+	}
+}
+
+// rankedWorktree is a recorded worktree with its frecency.
+type rankedWorktree struct {
+	path   string
+	visits *worktreeVisits
+	score  float64
+}
+
+// rankWorktrees returns the existing recorded worktrees whose display name
+// contains every term, in order and ignoring case, best first.
+func rankWorktrees(s *worktreeState, terms []string, now time.Time) []rankedWorktree {
+	var out []rankedWorktree
+	for p, v := range s.Worktrees {
+		if !matchTerms(v.display(), terms) || !isDir(p) {
+			continue
+		}
+		out = append(out, rankedWorktree{path: p, visits: v, score: v.frecency(now)})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].score != out[j].score {
+			return out[i].score > out[j].score
+		}
+		if !out[i].visits.LastAccess.Equal(out[j].visits.LastAccess) {
+			return out[i].visits.LastAccess.After(out[j].visits.LastAccess)
+		}
+		return out[i].path < out[j].path
+	})
+	return out
+}
+
+// matchTerms reports whether the terms occur in s one after the other,
+// ignoring case.
+func matchTerms(s string, terms []string) bool {
+	s = strings.ToLower(s)
+	for _, term := range terms {
+		term = strings.ToLower(term)
+		i := strings.Index(s, term)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(term):]
+	}
+	return true
+}
+
+func isDir(path string) bool {
+	st, err := os.Stat(path)
+	return err == nil && st.IsDir()
+}
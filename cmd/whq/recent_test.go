@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFrecencyRanking(t *testing.T) {
+	root := t.TempDir()
+	dir := func(name string) string {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(p, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	app, login, logout, lib := dir("app"), dir("login"), dir("logout"), dir("lib")
+	gone := filepath.Join(root, "gone")
+
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	s := &worktreeState{Repos: map[string]*repoWorktreeState{}, Worktrees: map[string]*worktreeVisits{}}
+	visit := func(path, repo, name string, at time.Time) {
+		s.visit(worktreeVisits{Repo: repo, RepoRoot: "/src/" + repo, Name: name}, path, at)
+	}
+	// Often, but two weeks ago.
+	for range 6 {
+		visit(login, "github.com/acme/app", "feature/login", now.Add(-14*24*time.Hour))
+	}
+	// Three times this morning.
+	visit(logout, "github.com/acme/app", "feature/logout", now.Add(-4*time.Hour))
+	visit(logout, "github.com/acme/app", "feature/logout", now.Add(-3*time.Hour))
+	visit(logout, "github.com/acme/app", "feature/logout", now.Add(-2*time.Hour))
+	visit(app, "github.com/acme/app", "@", now.Add(-10*time.Minute))
+	visit(lib, "github.com/acme/lib", "fix", now.Add(-30*time.Minute))
+	visit(gone, "github.com/acme/app", "old", now)
+
+	if got := s.Worktrees[logout].Rank; got != 3 {
+		t.Fatalf("unexpected rank %v", got)
+	}
+	var got []string
+	for _, r := range rankWorktrees(s, nil, now) {
+		got = append(got, r.visits.display())
+	}
+	want := []string{"github.com/acme/app:feature/logout", "github.com/acme/app:@", "github.com/acme/lib:fix", "github.com/acme/app:feature/login"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	got = got[:0]
+	for _, r := range rankWorktrees(s, []string{"ACME", "log"}, now) {
+		got = append(got, r.path)
+	}
+	if !slices.Equal(got, []string{logout, login}) {
+		t.Fatalf("unexpected matches %q", got)
+	}
+	if r := rankWorktrees(s, []string{"log", "acme"}, now); len(r) != 0 {
+		t.Fatalf("terms must match in order, got %v", r)
+	}
+
+	s.forgetMissing()
+	if _, ok := s.Worktrees[gone]; ok {
+		t.Fatalf("missing worktree should be forgotten")
+	}
+}
+
+func TestFrecencyAging(t *testing.T) {
+	now := time.Now()
+	s := &worktreeState{Worktrees: map[string]*worktreeVisits{
+		"/busy": {Rank: maxVisitRank - 1},
+		"/rare": {Rank: 1},
+	}}
+	s.visit(worktreeVisits{}, "/busy", now)
+	if _, ok := s.Worktrees["/rare"]; ok {
+		t.Fatalf("rarely used worktree should be forgotten")
+	}
+	if got := s.Worktrees["/busy"].Rank; got >= maxVisitRank || got < 0.8*maxVisitRank {
+		t.Fatalf("unexpected aged rank %v", got)
+	}
+}
+
+func TestJumpRecordsVisit(t *testing.T) {
+	repoRoot, whqRoot := setupResolveRepo(t)
+	hotfix := filepath.Join(whqRoot, "repo", "hotfix")
+	env.Host, env.Owner, env.Project = "github.com", "acme", "app"
+	recordWorktreeUse(hotfix)
+	recordWorktreeUse(repoRoot)
+
+	var out bytes.Buffer
+	if err := runJump([]string{"hot"}, time.Now(), &out); err != nil {
+		t.Fatalf("jump failed: %v", err)
+	}
+	if out.String() != hotfix+"\n" {
+		t.Fatalf("unexpected output %q", out.String())
+	}
+	state, err := loadWorktreeState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := state.Worktrees[hotfix]; v == nil || v.Rank != 2 || v.Name != "hotfix" || v.Repo != "github.com/acme/app" {
+		t.Fatalf("unexpected visits %+v", v)
+	}
+	if rs := state.Repos[repoRoot]; rs.Current != hotfix || rs.Previous != repoRoot {
+		t.Fatalf("unexpected current/previous %+v", rs)
+	}
+	if err := runJump([]string{"nothing"}, time.Now(), &out); err == nil || err.Error() != "whq: no recent worktree matches 'nothing'" {
+		t.Fatalf("expected no match, got %v", err)
+	}
+}
+
+func TestRecentAndJumpTolerateCorruptState(t *testing.T) {
+	setupResolveRepo(t)
+	writeFile(t, worktreeStatePath(), `{"repos": {`)
+
+	if err := recentCmd.RunE(recentCmd, nil); err != nil {
+		t.Fatalf("recent failed: %v", err)
+	}
+	var out bytes.Buffer
+	if err := runJump([]string{"hot"}, time.Now(), &out); err == nil || err.Error() != "whq: no recent worktree matches 'hot'" {
+		t.Fatalf("expected no match, got %v", err)
+	}
+	// Commands that need the state still report it.
+	if _, err := loadWorktreeState(); !errors.Is(err, errInvalidState) {
+		t.Fatalf("expected an invalid state error, got %v", err)
+	}
+}
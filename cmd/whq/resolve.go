@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// worktreeItem is one worktree as users refer to it: by the name `whq list`
+// prints (its label) or by its branch.
+type worktreeItem struct {
+	label  string
+	path   string
+	branch string
+}
+
+// text is what the picker shows and matches, and what ambiguity errors list:
+// the label, plus the branch when the label does not already say it.
+func (item worktreeItem) text() string {
+	if item.branch == "" || item.branch == item.label {
+		return item.label
+	}
+	return item.label + "  " + item.branch
+}
+
+// name is the label when arguments may match it: not for @, which only
+// matches itself, nor for worktrees outside repo_whq_root, labelled by their
+// absolute path.
+func (item worktreeItem) name() string {
+	if item.label == "@" || filepath.IsAbs(item.label) {
+		return ""
+	}
+	return item.label
+}
+
+func worktreeItems() ([]worktreeItem, error) {
+	infos, err := listWorktreeInfos(env.RepoRoot)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]worktreeItem, len(infos))
+	for i, wt := range infos {
+		items[i] = worktreeItem{label: worktreeLabel(i, wt.Path), path: wt.Path, branch: wt.Branch}
+	}
+	return items, nil
+}
+
+// worktreePath resolves a worktree argument to its path; see
+// resolveWorktree.
+func worktreePath(arg string) (string, error) {
+	item, err := resolveWorktree(arg)
+	if err != nil {
+		return "", err
+	}
+	return item.path, nil
+}
+
+// resolveWorktree finds the worktree an argument names: @ is the main
+// worktree and - the previously used one (see recordWorktreeUse). Anything
+// else is tried, in order, as an exact name, an exact branch, then a prefix,
+// a substring and a fuzzy match of either; the first rule that matches
+// anything must match exactly one worktree.
+func resolveWorktree(arg string) (worktreeItem, error) {
+	item, _, err := resolveWorktreeMatch(arg)
+	return item, err
+}
+
+// resolveWorktreeMatch is resolveWorktree, also reporting whether arg named
+// the worktree exactly (@, -, its name or its branch) rather than through a
+// prefix, substring or fuzzy match.
+func resolveWorktreeMatch(arg string) (worktreeItem, bool, error) {
+	items, err := worktreeItems()
+	if err != nil {
+		return worktreeItem{}, false, err
+	}
+	switch arg {
+	case "@":
+		return items[0], true, nil
+	case "-":
+		item, err := previousWorktree(items)
+		return item, err == nil, err
+	}
+
+	rules := []func(item worktreeItem) bool{
+		func(item worktreeItem) bool { return item.name() == arg },
+		func(item worktreeItem) bool { return item.branch == arg },
+		func(item worktreeItem) bool {
+			return matchWorktreeNames(item, func(s string) bool { return strings.HasPrefix(s, arg) })
+		},
+		func(item worktreeItem) bool {
+			return matchWorktreeNames(item, func(s string) bool { return strings.Contains(s, arg) })
+		},
+		func(item worktreeItem) bool {
+			return matchWorktreeNames(item, func(s string) bool {
+				_, _, ok := fuzzyMatch(arg, s)
+				return ok
+			})
+		},
+	}
+	if arg != "" {
+		for i, rule := range rules {
+			var found []worktreeItem
+			for _, item := range items {
+				if rule(item) {
+					found = append(found, item)
+				}
+			}
+			switch len(found) {
+			case 0:
+				continue
+			case 1:
+				return found[0], i < 2, nil
+			}
+			var b strings.Builder
+			fmt.Fprintf(&b, "whq: worktree '%s' is ambiguous; it matches:", arg)
+			for _, item := range found {
+				b.WriteString("\n  " + item.text())
+			}
+			return worktreeItem{}, false, errors.New(b.String())
+		}
+	}
+	return worktreeItem{}, false, fmt.Errorf("whq: worktree '%s' not found at %s", arg, filepath.Join(env.RepoWHQRoot, arg))
+}
+
+// matchWorktreeNames reports whether match accepts the item's name or branch.
+func matchWorktreeNames(item worktreeItem, match func(string) bool) bool {
+	for _, s := range []string{item.name(), item.branch} {
+		if s != "" && match(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func previousWorktree(items []worktreeItem) (worktreeItem, error) {
+	state, err := loadWorktreeState()
+	if err != nil {
+		return worktreeItem{}, err
+	}
+	prev := ""
+	if rs := state.Repos[env.RepoRoot]; rs != nil {
+		prev = rs.Previous
+	}
+	if prev == "" {
+		return worktreeItem{}, errors.New("whq: no previous worktree")
+	}
+	for _, item := range items {
+		if item.path == prev {
+			return item, nil
+		}
+	}
+	return worktreeItem{}, fmt.Errorf("whq: previous worktree %s no longer exists", prev)
+}
+
+// worktreeState is whq's own bookkeeping, kept under WHQ_ROOT/.whq: per
+// repository root, the worktree last used through `whq path`, `whq cd` or
+// `whq pick`, and the one used before it (what - resolves to); and, per
+// worktree path, the visits `whq recent` and `whq jump` rank by.
+type worktreeState struct {
+	Repos     map[string]*repoWorktreeState `json:"repos"`
+	Worktrees map[string]*worktreeVisits    `json:"worktrees,omitempty"`
+}
+
+type repoWorktreeState struct {
+	Current  string `json:"current,omitempty"`
+	Previous string `json:"previous,omitempty"`
+}
+
+var errInvalidState = errors.New("invalid state file")
+
+func worktreeStatePath() string {
+	return filepath.Join(env.WHQRoot, ".whq", "state.json")
+}
+
+func loadWorktreeState() (*worktreeState, error) {
+	path := worktreeStatePath()
+	state := &worktreeState{Repos: map[string]*repoWorktreeState{}, Worktrees: map[string]*worktreeVisits{}}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return state, nil
+		}
+		return nil, fmt.Errorf("whq: failed to read %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("whq: %w %s: %v", errInvalidState, path, err)
+	}
+	if state.Repos == nil {
+		state.Repos = map[string]*repoWorktreeState{}
+	}
+	if state.Worktrees == nil {
+		state.Worktrees = map[string]*worktreeVisits{}
+	}
+	return state, nil
+}
+
+func (s *worktreeState) save() error {
+	path := worktreeStatePath()
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("whq: failed to write %s: %w", path, err)
+	}
+	// Several whq processes may save at once; each renames its own file.
+	f, err := os.CreateTemp(filepath.Dir(path), "state-*.tmp")
+	if err != nil {
+		return fmt.Errorf("whq: failed to write %s: %w", path, err)
+	}
+	_, err = f.Write(append(data, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("whq: failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// use makes path the current worktree of repoRoot.
+func (s *worktreeState) use(repoRoot, path string) {
+	rs := s.Repos[repoRoot]
+	if rs == nil {
+		rs = &repoWorktreeState{}
+		s.Repos[repoRoot] = rs
+	}
+	if rs.Current != path {
+		rs.Previous, rs.Current = rs.Current, path
+	}
+}
+
+// recordWorktreeUse notes that the user went to path, a worktree of the
+// current repository. Failing to record is only worth a warning: the command
+// itself succeeded.
+func recordWorktreeUse(path string) {
+	state, err := loadWorktreeState()
+	if err == nil {
+		name := "@"
+		if path != env.RepoRoot {
+			name = worktreeLabel(1, path)
+		}
+		state.use(env.RepoRoot, path)
+		state.visit(worktreeVisits{
+			Repo:     env.Host + "/" + env.Owner + "/" + env.Project,
+			RepoRoot: env.RepoRoot,
+			Name:     name,
+		}, path, time.Now())
+		err = state.save()
+	}
+	if err != nil {
+		warnStateError(err)
+	}
+}
+
+func warnStateError(err error) {
+	fmt.Fprintf(os.Stderr, "whq: warning: %s\n", strings.TrimPrefix(err.Error(), "whq: "))
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// setupResolveRepo creates a repository with worktrees under a fresh
+// repo_whq_root: two feature branches and a "hotfix" directory holding
+// branch release/2.0-hotfix.
+func setupResolveRepo(t testing.TB) (repoRoot, whqRoot string) {
+	t.Helper()
+	repoRoot = initGitRepo(t)
+	runGit(t, repoRoot, "branch", "-m", "main")
+	whqRoot = t.TempDir()
+	for dir, branch := range map[string]string{
+		"feature/JIRA-1234-login-fix": "feature/JIRA-1234-login-fix",
+		"feature/JIRA-1243-logout":    "feature/JIRA-1243-logout",
+		"hotfix":                      "release/2.0-hotfix",
+	} {
+		runGit(t, repoRoot, "worktree", "add", "-q", "-b", branch, filepath.Join(whqRoot, "repo", dir))
+	}
+	origEnv := env
+	env.RepoRoot, env.WHQRoot, env.RepoWHQRoot = repoRoot, whqRoot, filepath.Join(whqRoot, "repo")
+	t.Cleanup(func() { env = origEnv })
+	return repoRoot, whqRoot
+}
+
+func TestResolveWorktree(t *testing.T) {
+	repoRoot, whqRoot := setupResolveRepo(t)
+	wt := func(name string) string { return filepath.Join(whqRoot, "repo", name) }
+
+	for arg, want := range map[string]string{
+		"@":                           repoRoot,
+		"main":                        repoRoot,
+		"hotfix":                      wt("hotfix"),
+		"release/2.0-hotfix":          wt("hotfix"),
+		"release":                     wt("hotfix"),
+		"hot":                         wt("hotfix"),
+		"1234":                        wt("feature/JIRA-1234-login-fix"),
+		"logout":                      wt("feature/JIRA-1243-logout"),
+		"lgnfx":                       wt("feature/JIRA-1234-login-fix"),
+		"feature/JIRA-1243-logout":    wt("feature/JIRA-1243-logout"),
+		"feature/JIRA-1234-login-fix": wt("feature/JIRA-1234-login-fix"),
+	} {
+		got, err := worktreePath(arg)
+		if err != nil || got != want {
+			t.Errorf("worktreePath(%q) = %q, %v; want %q", arg, got, err, want)
+		}
+	}
+
+	_, err := worktreePath("feature/JIRA")
+	want := "whq: worktree 'feature/JIRA' is ambiguous; it matches:\n  feature/JIRA-1234-login-fix\n  feature/JIRA-1243-logout"
+	if err == nil || err.Error() != want {
+		t.Fatalf("expected an ambiguity error, got %v", err)
+	}
+	if _, err := worktreePath("nope"); err == nil || !strings.Contains(err.Error(), "worktree 'nope' not found") {
+		t.Fatalf("expected a not found error, got %v", err)
+	}
+}
+
+func TestResolvePreviousWorktree(t *testing.T) {
+	repoRoot, whqRoot := setupResolveRepo(t)
+	hotfix := filepath.Join(whqRoot, "repo", "hotfix")
+
+	if _, err := worktreePath("-"); err == nil || err.Error() != "whq: no previous worktree" {
+		t.Fatalf("expected no previous worktree, got %v", err)
+	}
+	recordWorktreeUse(hotfix)
+	recordWorktreeUse(hotfix)
+	recordWorktreeUse(repoRoot)
+	if got, err := worktreePath("-"); err != nil || got != hotfix {
+		t.Fatalf("got %q, %v; want %q", got, err, hotfix)
+	}
+	// Going to the previous worktree swaps the two, like cd -.
+	recordWorktreeUse(hotfix)
+	if got, err := worktreePath("-"); err != nil || got != repoRoot {
+		t.Fatalf("got %q, %v; want %q", got, err, repoRoot)
+	}
+	if _, err := os.Stat(filepath.Join(whqRoot, ".whq", "state.json")); err != nil {
+		t.Fatalf("state not written: %v", err)
+	}
+
+	runGit(t, repoRoot, "worktree", "remove", hotfix)
+	recordWorktreeUse(repoRoot)
+	if _, err := worktreePath("-"); err == nil || !strings.Contains(err.Error(), "no longer exists") {
+		t.Fatalf("expected a removed previous worktree error, got %v", err)
+	}
+}
+
+func TestRmResolvesArgument(t *testing.T) {
+	repoRoot, whqRoot := setupResolveRepo(t)
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	origForce, origBranch := rmForce, rmBranch
+	t.Cleanup(func() { rmForce, rmBranch = origForce, origBranch })
+	rmForce, rmBranch = false, true
+
+	if err := rmCmd.RunE(rmCmd, []string{"main"}); err == nil || err.Error() != "whq: refusing to remove the main worktree" {
+		t.Fatalf("expected a refusal, got %v", err)
+	}
+	// A match that is not an exact name or branch is confirmed first.
+	stubPrompt(t, "y\n")
+	if err := rmCmd.RunE(rmCmd, []string{"release/2.0"}); err != nil {
+		t.Fatalf("rm failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(whqRoot, "repo", "hotfix")); !os.IsNotExist(err) {
+		t.Fatalf("worktree should be removed, err=%v", err)
+	}
+	if out := runGit(t, repoRoot, "branch", "--list", "release/2.0-hotfix"); out != "" {
+		t.Fatalf("branch should be deleted, got %q", out)
+	}
+}
+
+func TestRmKeepsInexactMatchWithoutConfirmation(t *testing.T) {
+	repoRoot, whqRoot := setupResolveRepo(t)
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	origForce, origBranch := rmForce, rmBranch
+	t.Cleanup(func() { rmForce, rmBranch = origForce, origBranch })
+	rmForce, rmBranch = false, true
+	dir := filepath.Join(whqRoot, "repo", "feature", "JIRA-1234-login-fix")
+	kept := func() {
+		t.Helper()
+		if _, err := os.Stat(dir); err != nil {
+			t.Fatalf("worktree should be kept: %v", err)
+		}
+		if out := runGit(t, repoRoot, "branch", "--list", "feature/JIRA-1234-login-fix"); out == "" {
+			t.Fatal("branch should be kept")
+		}
+	}
+
+	// "lgnfx" only fuzzily matches; with nobody to ask, nothing is removed.
+	out := stubPrompt(t, "n\n")
+	promptInteractive = func() bool { return false }
+	err := rmCmd.RunE(rmCmd, []string{"lgnfx"})
+	if err == nil || !strings.Contains(err.Error(), "not the exact name or branch") {
+		t.Fatalf("expected a refusal, got %v", err)
+	}
+	kept()
+
+	promptInteractive = func() bool { return true }
+	if err := rmCmd.RunE(rmCmd, []string{"lgnfx"}); err == nil || !strings.Contains(err.Error(), "was not removed") {
+		t.Fatalf("expected the removal to be declined, got %v", err)
+	}
+	if !strings.Contains(out.String(), "'lgnfx' matches worktree feature/JIRA-1234-login-fix; remove it?") {
+		t.Fatalf("unexpected prompt %q", out.String())
+	}
+	kept()
+}
+
+func TestWorktreeStateConcurrentSaves(t *testing.T) {
+	_, whqRoot := setupResolveRepo(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s := &worktreeState{Repos: map[string]*repoWorktreeState{}}
+			s.use(fmt.Sprintf("/repo%d", i), strings.Repeat("/worktree", i+1))
+			if err := s.save(); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	// Each save renames its own file, so the result is always whole.
+	if _, err := loadWorktreeState(); err != nil {
+		t.Fatalf("state corrupt after concurrent saves: %v", err)
+	}
+	if tmp, _ := filepath.Glob(filepath.Join(whqRoot, ".whq", "*.tmp")); len(tmp) != 0 {
+		t.Fatalf("temporary files left behind: %v", tmp)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+var runCmd = &cobra.Command{
+	Use:   "run [<script> [<worktree>] [-- args...]]",
+	Short: "Run a script from .whq.json in a worktree (list scripts without arguments)",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var extra []string
+		if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+			args, extra = args[:dash], args[dash:]
+		}
+		if len(args) > 2 || (len(args) == 0 && len(extra) > 0) {
+			return errors.New("Usage: whq run [<script> [<worktree>] [-- args...]]")
+		}
+		cfg, err := loadWHQConfig(env.RepoRoot)
+		if err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			return listScripts(cfg, os.Stdout)
+		}
+
+		script, ok := cfg.script(args[0])
+		if !ok {
+			return unknownScriptError(cfg, args[0])
+		}
+		var wt worktreeItem
+		if len(args) == 2 {
+			wt, err = resolveWorktree(args[1])
+		} else {
+			wt, err = currentWorktree()
+		}
+		if err != nil {
+			return err
+		}
+		if err := ensureTrusted(cfg, env.RepoRoot); err != nil {
+			return err
+		}
+		c, err := script.command(wt, extra)
+		if err != nil {
+			return fmt.Errorf("whq: script %s: %w", args[0], err)
+		}
+		c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+		return runForeground(c)
+	},
+}
+
+// scriptEntry is one named command of the top-level "scripts" section:
+// like a hook command, a string run through the shell, an argv array, or
+// an object with "run" and options.
+type scriptEntry struct {
+	commandEntry
+	Description string
+}
+
+func (s *scriptEntry) UnmarshalJSON(data []byte) error {
+	*s = scriptEntry{}
+	if err := s.setRun(data); err == nil {
+		return nil
+	}
+	var obj struct {
+		Run         json.RawMessage   `json:"run"`
+		Description string            `json:"description"`
+		Cwd         string            `json:"cwd"`
+		Env         map[string]string `json:"env"`
+	}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return errors.New("script must be a string, an argv array or an object with \"run\"")
+	}
+	if len(obj.Run) == 0 {
+		return errors.New("script object requires \"run\"")
+	}
+	if err := s.setRun(obj.Run); err != nil {
+		return err
+	}
+	s.Description, s.Cwd, s.Env = obj.Description, obj.Cwd, obj.Env
+	return nil
+}
+
+// script returns the script called name. An upper layer removes a script
+// of a lower one by setting it to null.
+func (c *whqConfig) script(name string) (*scriptEntry, bool) {
+	if c == nil || c.Scripts[name] == nil {
+		return nil, false
+	}
+	return c.Scripts[name], true
+}
+
+// scriptNames lists the defined scripts, sorted.
+func (c *whqConfig) scriptNames() []string {
+	var names []string
+	if c != nil {
+		for name, s := range c.Scripts {
+			if s != nil {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func listScripts(cfg *whqConfig, stdout io.Writer) error {
+	names := cfg.scriptNames()
+	if len(names) == 0 {
+		fmt.Fprintln(stdout, "No scripts in .whq.json")
+		return nil
+	}
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	for _, name := range names {
+		s := cfg.Scripts[name]
+		desc := s.Description
+		if desc == "" {
+			desc = s.label()
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", name, desc)
+	}
+	return tw.Flush()
+}
+
+func unknownScriptError(cfg *whqConfig, name string) error {
+	names := cfg.scriptNames()
+	if len(names) == 0 {
+		return fmt.Errorf("whq: unknown script '%s' (.whq.json defines no scripts)", name)
+	}
+	return fmt.Errorf("whq: unknown script '%s' (available: %s)", name, strings.Join(names, ", "))
+}
+
+// command prepares the script to run in wt with extra arguments: appended,
+// shell-quoted, to a script string, or to an argv. It runs with the
+// environment of `whq exec` plus the script's env.
+func (s *scriptEntry) command(wt worktreeItem, extra []string) (*exec.Cmd, error) {
+	var argv []string
+	if s.Argv != nil {
+		if len(s.Argv) == 0 || s.Argv[0] == "" {
+			return nil, errors.New("argv command is empty")
+		}
+		argv = append(append([]string{}, s.Argv...), extra...)
+	} else {
+		script := strings.TrimSpace(s.Script)
+		if script == "" {
+			return nil, errors.New("command is empty")
+		}
+		for _, a := range extra {
+			script += " " + shellQuote(a)
+		}
+		argv = append(append([]string{}, defaultShell...), script)
+	}
+
+	dir := wt.path
+	if cwd := strings.TrimSpace(s.Cwd); cwd != "" && filepath.Clean(cwd) != "." {
+		var err error
+		if dir, err = safeJoin(wt.path, cwd); err != nil {
+			return nil, fmt.Errorf("invalid cwd %q: %w", cwd, err)
+		}
+	}
+	c := exec.Command(argv[0], argv[1:]...)
+	c.Dir = dir
+	c.Env = worktreeEnv(wt)
+	keys := make([]string, 0, len(s.Env))
+	for k := range s.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		c.Env = append(c.Env, k+"="+s.Env[k])
+	}
+	return c, nil
+}
+
+// shellQuote quotes s for a POSIX shell when it needs it.
+func shellQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n\"'\\$`|&;<>()*?[]#~={}!") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestScriptsConfig(t *testing.T) {
+	repo := setRepoRoot(t)
+	writeFile(t, filepath.Join(repo, ".whq.json"), `{
+		"scripts": {
+			"test": "go test ./...",
+			"lint": ["golangci-lint", "run"],
+			"dev": {"run": "pnpm dev", "description": "Start the dev server", "cwd": "web", "env": {"PORT": "3001"}},
+			"old": "make old"
+		}
+	}`)
+	writeFile(t, filepath.Join(repo, ".whq.local.json"), `{"scripts": {"old": null, "mine": "make mine"}}`)
+	cfg, err := loadWHQConfig(repo)
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if got := cfg.scriptNames(); !slices.Equal(got, []string{"dev", "lint", "mine", "test"}) {
+		t.Fatalf("unexpected scripts %q", got)
+	}
+
+	var out bytes.Buffer
+	if err := listScripts(cfg, &out); err != nil {
+		t.Fatal(err)
+	}
+	want := "dev   Start the dev server\nlint  golangci-lint run\nmine  make mine\ntest  go test ./...\n"
+	if out.String() != want {
+		t.Fatalf("unexpected list:\n%s", out.String())
+	}
+
+	err = unknownScriptError(cfg, "old")
+	if err.Error() != "whq: unknown script 'old' (available: dev, lint, mine, test)" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var desc bytes.Buffer
+	describeCommands(cfg, &desc)
+	if !strings.Contains(desc.String(), "  scripts.dev: pnpm dev\n") {
+		t.Fatalf("scripts missing from trusted commands:\n%s", desc.String())
+	}
+}
+
+func TestScriptsNeedRun(t *testing.T) {
+	repo := setRepoRoot(t)
+	writeFile(t, filepath.Join(repo, ".whq.json"), `{"scripts": {"dev": {"description": "x"}}}`)
+	if _, err := loadWHQConfig(repo); err == nil || !strings.Contains(err.Error(), `scripts.dev: missing required field "run"`) {
+		t.Fatalf("expected a schema error, got %v", err)
+	}
+}
+
+func TestScriptCommand(t *testing.T) {
+	wt := worktreeItem{label: "feature/x", path: "/wt/feature/x", branch: "feature/x"}
+
+	s := &scriptEntry{commandEntry: commandEntry{Script: "go test ./...", Cwd: "web", Env: map[string]string{"B": "2", "A": "1"}}}
+	c, err := s.command(wt, []string{"-run", "Test Foo", "it's"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append(slices.Clone(defaultShell), `go test ./... -run 'Test Foo' 'it'\''s'`)
+	if !slices.Equal(c.Args, want) {
+		t.Fatalf("got %q, want %q", c.Args, want)
+	}
+	if c.Dir != "/wt/feature/x/web" {
+		t.Fatalf("unexpected dir %q", c.Dir)
+	}
+	n := len(c.Env)
+	if !slices.Equal(c.Env[n-2:], []string{"A=1", "B=2"}) || !slices.Contains(c.Env, "WHQ_BRANCH=feature/x") {
+		t.Fatalf("unexpected environment %q", c.Env[n-4:])
+	}
+
+	s = &scriptEntry{commandEntry: commandEntry{Argv: []string{"make", "test"}}}
+	if c, err = s.command(wt, []string{"V=1"}); err != nil || !slices.Equal(c.Args, []string{"make", "test", "V=1"}) {
+		t.Fatalf("unexpected argv %q, %v", c.Args, err)
+	}
+	s = &scriptEntry{commandEntry: commandEntry{Script: "make", Cwd: "../x"}}
+	if _, err := s.command(wt, nil); err == nil || !strings.Contains(err.Error(), "invalid cwd") {
+		t.Fatalf("expected a cwd error, got %v", err)
+	}
+}
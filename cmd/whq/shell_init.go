@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// cdFileEnv names the file through which whq asks the shell wrapper from
+// `whq shell-init` to change directory: whq writes one absolute path to it
+// and the wrapper cds there once whq exits.
+const cdFileEnv = "WHQ_CD_FILE"
+
+var shellInitCmd = &cobra.Command{
+	Use:       "shell-init <bash|zsh|fish>",
+	Short:     "Print shell integration (a whq wrapper that can change directory)",
+	ValidArgs: []string{"bash", "zsh", "fish"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("Usage: whq shell-init <bash|zsh|fish>")
+		}
+		return writeShellInit(args[0], os.Stdout)
+	},
+}
+
+var cdCmd = &cobra.Command{
+	Use:   "cd [<worktree>]",
+	Short: "Change the shell's directory to a worktree (needs whq shell-init)",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var (
+			dest string
+			err  error
+		)
+		switch {
+		case len(args) == 1:
+			dest, err = worktreePath(args[0])
+		case len(args) == 0 && promptInteractive():
+			dest, err = pickWorktree()
+		default:
+			return errors.New("Usage: whq cd <worktree>")
+		}
+		if err != nil {
+			return err
+		}
+		recordWorktreeUse(dest)
+		ok, err := requestCD(dest)
+		if err != nil || ok {
+			return err
+		}
+		// Without the wrapper, still usable as cd "$(whq cd x)".
+		fmt.Fprintln(os.Stdout, dest)
+		fmt.Fprintln(os.Stderr, `whq: cd needs the shell integration; add eval "$(whq shell-init bash)" (or zsh/fish) to your shell startup file`)
+		return nil
+	},
+}
+
+// requestCD asks the shell wrapper to change to dir. It reports false when
+// whq does not run under the wrapper.
+func requestCD(dir string) (bool, error) {
+	path := os.Getenv(cdFileEnv)
+	if path == "" {
+		return false, nil
+	}
+	if err := os.WriteFile(path, []byte(dir), 0o600); err != nil {
+		return false, fmt.Errorf("whq: failed to write %s: %w", cdFileEnv, err)
+	}
+	return true, nil
+}
+
+// cwdWithin reports whether the working directory is dir or below it,
+// comparing paths with symlinks resolved.
+func cwdWithin(dir string) bool {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return false
+	}
+	if resolved, err := filepath.EvalSymlinks(cwd); err == nil {
+		cwd = resolved
+	}
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+	rel, err := filepath.Rel(dir, cwd)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func writeShellInit(shell string, w io.Writer) error {
+	var script string
+	switch shell {
+	case "bash", "zsh":
+		script = strings.ReplaceAll(posixShellInit, "@SHELL@", shell)
+	case "fish":
+		script = fishShellInit
+	default:
+		return fmt.Errorf("whq: unsupported shell %q (want bash, zsh or fish)", shell)
+	}
+	_, err := io.WriteString(w, script)
+	return err
+}
+
+const posixShellInit = `# whq shell integration for @SHELL@. Load it from your startup file with:
+#   eval "$(whq shell-init @SHELL@)"
+
+# whq runs the real whq and then changes to the directory it asked for
+# through $WHQ_CD_FILE (whq cd, whq add --cd, whq rm of the current
+# worktree).
+whq() {
+  local __whq_cd __whq_status
+  __whq_cd="$(mktemp -t whq-cd.XXXXXX)" || {
+    command whq "$@"
+    return
+  }
+  WHQ_CD_FILE="$__whq_cd" command whq "$@"
+  __whq_status=$?
+  if [ -s "$__whq_cd" ]; then
+    cd -- "$(cat "$__whq_cd")" || __whq_status=$?
+  fi
+  rm -f -- "$__whq_cd"
+  return "$__whq_status"
+}
+
+# __whq_ps1 prints the branch of the linked worktree the shell is in, using
+# the printf format $1 (default " (%s)"), and nothing elsewhere. Example:
+#   PS1='\w$(__whq_ps1 " [wt:%s]")\$ '
+__whq_ps1() {
+  local __whq_git_dir __whq_branch
+  __whq_git_dir="$(git rev-parse --git-dir 2>/dev/null)" || return 0
+  case "$__whq_git_dir" in
+    */worktrees/*) ;;
+    *) return 0 ;;
+  esac
+  __whq_branch="$(git branch --show-current 2>/dev/null)"
+  printf -- "${1:- (%s)}" "${__whq_branch:-detached}"
+}
+`
+
+const fishShellInit = `# whq shell integration for fish. Load it from config.fish with:
+#   whq shell-init fish | source
+
+# whq runs the real whq and then changes to the directory it asked for
+# through $WHQ_CD_FILE (whq cd, whq add --cd, whq rm of the current
+# worktree).
+function whq --description 'whq, changing directory when asked to'
+    set -l cd_file (mktemp -t whq-cd.XXXXXX)
+    or begin
+        command whq $argv
+        return
+    end
+    WHQ_CD_FILE=$cd_file command whq $argv
+    set -l whq_status $status
+    if test -s $cd_file
+        cd (cat $cd_file)
+        or set whq_status $status
+    end
+    rm -f -- $cd_file
+    return $whq_status
+end
+
+# __whq_ps1 prints the branch of the linked worktree the shell is in, using
+# the printf format $argv[1] (default " (%s)"), and nothing elsewhere.
+# Example, in fish_prompt:
+#   printf '%s%s> ' (prompt_pwd) (__whq_ps1 ' [wt:%s]')
+function __whq_ps1 --description 'Print the branch of the current linked worktree'
+    set -l git_dir (git rev-parse --git-dir 2>/dev/null)
+    or return 0
+    string match -q -- '*/worktrees/*' $git_dir
+    or return 0
+    set -l branch (git branch --show-current 2>/dev/null)
+    test -n "$branch"
+    or set branch detached
+    set -l format ' (%s)'
+    set -q argv[1]
+    and set format $argv[1]
+    printf -- $format $branch
+end
+`
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite golden files under testdata")
+
+func TestShellInitGolden(t *testing.T) {
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		t.Run(shell, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := writeShellInit(shell, &out); err != nil {
+				t.Fatalf("shell-init failed: %v", err)
+			}
+			golden := filepath.Join("testdata", "shell-init", shell+".golden")
+			if *updateGolden {
+				writeFile(t, golden, out.String())
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("read golden: %v", err)
+			}
+			if out.String() != string(want) {
+				t.Fatalf("output differs from %s (run go test -update):\n%s", golden, out.String())
+			}
+		})
+	}
+	if err := writeShellInit("tcsh", &bytes.Buffer{}); err == nil || !strings.Contains(err.Error(), "unsupported shell") {
+		t.Fatalf("expected an unsupported shell error, got %v", err)
+	}
+}
+
+// TestShellInitBashChangesDirectory runs the bash wrapper against a stand-in
+// whq that writes its first argument to $WHQ_CD_FILE.
+func TestShellInitBashChangesDirectory(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not available")
+	}
+	bin := t.TempDir()
+	writeFile(t, filepath.Join(bin, "whq"), "#!/bin/sh\nprintf %s \"$1\" > \"$WHQ_CD_FILE\"\nexit \"${2:-0}\"\n")
+	if err := os.Chmod(filepath.Join(bin, "whq"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	target := t.TempDir()
+
+	var script bytes.Buffer
+	if err := writeShellInit("bash", &script); err != nil {
+		t.Fatal(err)
+	}
+	script.WriteString("whq " + target + " 3; echo \"status=$? pwd=$PWD\"\n")
+	c := exec.Command("bash", "--noprofile", "--norc", "-c", script.String())
+	c.Env = append(os.Environ(), "PATH="+bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	out, err := c.CombinedOutput()
+	if err != nil {
+		t.Fatalf("bash failed: %v\n%s", err, out)
+	}
+	if got := strings.TrimSpace(string(out)); got != "status=3 pwd="+target {
+		t.Fatalf("unexpected result: %q", got)
+	}
+}
+
+func TestRequestCD(t *testing.T) {
+	t.Setenv(cdFileEnv, "")
+	if ok, err := requestCD("/x"); ok || err != nil {
+		t.Fatalf("expected no handoff without %s, got %v, %v", cdFileEnv, ok, err)
+	}
+	path := filepath.Join(t.TempDir(), "cd")
+	t.Setenv(cdFileEnv, path)
+	if ok, err := requestCD("/x/y"); !ok || err != nil {
+		t.Fatalf("handoff failed: %v, %v", ok, err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "/x/y" {
+		t.Fatalf("unexpected handoff file: %q", data)
+	}
+}
+
+func TestCwdWithin(t *testing.T) {
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "a", "b")
+	if err := os.MkdirAll(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(sub)
+	if !cwdWithin(dir) || !cwdWithin(sub) {
+		t.Fatalf("expected %s to be within %s", sub, dir)
+	}
+	if cwdWithin(filepath.Join(dir, "a", "bc")) || cwdWithin(t.TempDir()) {
+		t.Fatalf("unexpected match")
+	}
+}
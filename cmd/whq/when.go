@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// stringList accepts either a single string or an array of strings.
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*l = stringList{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return fmt.Errorf("expected a string or an array of strings")
+	}
+	*l = many
+	return nil
+}
+
+// whenCondition gates a copy or command entry. Every field that is set must
+// hold. Within branch, os and arch any listed value may match; every exists
+// and env item must hold, and a leading "!" negates an item. An env item is
+// either NAME (set and non-empty) or NAME=value (exactly that value).
+type whenCondition struct {
+	Branch      stringList `json:"branch"`
+	BranchRegex string     `json:"branch_regex"`
+	Exists      stringList `json:"exists"`
+	Env         stringList `json:"env"`
+	OS          stringList `json:"os"`
+	Arch        stringList `json:"arch"`
+}
+
+func (w *whenCondition) validate() error {
+	if w == nil {
+		return nil
+	}
+	for _, pattern := range w.Branch {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("whq: invalid when.branch pattern %q: %w", pattern, err)
+		}
+	}
+	if w.BranchRegex != "" {
+		if _, err := regexp.Compile(w.BranchRegex); err != nil {
+			return fmt.Errorf("whq: invalid when.branch_regex %q: %w", w.BranchRegex, err)
+		}
+	}
+	for _, item := range w.Exists {
+		if _, err := safeJoin(".", strings.TrimPrefix(item, "!")); err != nil {
+			return fmt.Errorf("whq: invalid when.exists path %q: %w", item, err)
+		}
+	}
+	for _, item := range w.Env {
+		if name, _, _ := strings.Cut(strings.TrimPrefix(item, "!"), "="); name == "" {
+			return fmt.Errorf("whq: invalid when.env item %q: missing variable name", item)
+		}
+	}
+	return nil
+}
+
+// check reports whether w holds for the hook described by t and, if not, a
+// short reason naming the first failing condition. A nil condition always
+// holds.
+func (w *whenCondition) check(t hookContext) (bool, string) {
+	if w == nil {
+		return true, ""
+	}
+	if len(w.Branch) > 0 && !anyMatch(w.Branch, func(p string) bool {
+		ok, _ := path.Match(p, t.Branch)
+		return ok
+	}) {
+		return false, fmt.Sprintf("branch %s does not match %s", t.Branch, strings.Join(w.Branch, ", "))
+	}
+	if w.BranchRegex != "" {
+		if re, err := regexp.Compile(w.BranchRegex); err != nil || !re.MatchString(t.Branch) {
+			return false, fmt.Sprintf("branch %s does not match /%s/", t.Branch, w.BranchRegex)
+		}
+	}
+	for _, item := range w.Exists {
+		rel, negate := strings.CutPrefix(item, "!")
+		p, err := safeJoin(t.Dir, rel)
+		if err != nil {
+			return false, fmt.Sprintf("invalid path %s", rel)
+		}
+		_, statErr := os.Stat(p)
+		if exists := statErr == nil; exists == negate {
+			if negate {
+				return false, rel + " exists"
+			}
+			return false, rel + " not found"
+		}
+	}
+	for _, item := range w.Env {
+		spec, negate := strings.CutPrefix(item, "!")
+		name, want, compare := strings.Cut(spec, "=")
+		value := os.Getenv(name)
+		if !compare {
+			if set := value != ""; set == negate {
+				if negate {
+					return false, "$" + name + " is set"
+				}
+				return false, "$" + name + " is not set"
+			}
+			continue
+		}
+		if equal := value == want; equal == negate {
+			if negate {
+				return false, fmt.Sprintf("$%s is %q", name, want)
+			}
+			return false, fmt.Sprintf("$%s is not %q", name, want)
+		}
+	}
+	if len(w.OS) > 0 && !anyMatch(w.OS, func(v string) bool { return v == runtime.GOOS }) {
+		return false, "os is " + runtime.GOOS
+	}
+	if len(w.Arch) > 0 && !anyMatch(w.Arch, func(v string) bool { return v == runtime.GOARCH }) {
+		return false, "arch is " + runtime.GOARCH
+	}
+	return true, ""
+}
+
+func anyMatch(values []string, match func(string) bool) bool {
+	for _, v := range values {
+		if match(strings.TrimSpace(v)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestWhenConditionCheck(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "package.json"), "{}")
+	t.Setenv("WHQ_TEST_SET", "1")
+	t.Setenv("WHQ_TEST_EMPTY", "")
+
+	for _, tc := range []struct {
+		when   string
+		branch string
+		ok     bool
+		reason string
+	}{
+		{`{"branch": "feature/*"}`, "feature/login", true, ""},
+		{`{"branch": ["release/*", "hotfix/*"]}`, "feature/login", false, "does not match release/*, hotfix/*"},
+		{`{"branch_regex": "^JIRA-[0-9]+"}`, "JIRA-12-fix", true, ""},
+		{`{"branch_regex": "^JIRA-[0-9]+"}`, "main", false, "does not match /^JIRA-[0-9]+/"},
+		{`{"exists": "package.json"}`, "main", true, ""},
+		{`{"exists": ["package.json", "Gemfile"]}`, "main", false, "Gemfile not found"},
+		{`{"exists": "!package.json"}`, "main", false, "package.json exists"},
+		{`{"env": "WHQ_TEST_SET"}`, "main", true, ""},
+		{`{"env": "WHQ_TEST_EMPTY"}`, "main", false, "$WHQ_TEST_EMPTY is not set"},
+		{`{"env": "!WHQ_TEST_SET"}`, "main", false, "$WHQ_TEST_SET is set"},
+		{`{"env": "WHQ_TEST_SET=1"}`, "main", true, ""},
+		{`{"env": "WHQ_TEST_SET=12"}`, "main", false, `$WHQ_TEST_SET is not "12"`},
+		{`{"env": "WHQ_TEST_EMPTY="}`, "main", true, ""},
+		{`{"env": "WHQ_TEST_UNSET="}`, "main", true, ""},
+		{`{"env": "!WHQ_TEST_SET=1"}`, "main", false, `$WHQ_TEST_SET is "1"`},
+		{`{"env": ["!WHQ_TEST_SET=2", "WHQ_TEST_SET"]}`, "main", true, ""},
+		{`{"os": ["` + runtime.GOOS + `", "plan9"], "arch": "` + runtime.GOARCH + `"}`, "main", true, ""},
+		{`{"os": "plan9"}`, "main", false, "os is " + runtime.GOOS},
+	} {
+		var w whenCondition
+		if err := json.Unmarshal([]byte(tc.when), &w); err != nil {
+			t.Fatalf("unmarshal %s failed: %v", tc.when, err)
+		}
+		ok, reason := w.check(hookContext{Branch: tc.branch, Dir: root})
+		if ok != tc.ok || !strings.Contains(reason, tc.reason) {
+			t.Errorf("%s on %s: got (%v, %q), want (%v, %q)", tc.when, tc.branch, ok, reason, tc.ok, tc.reason)
+		}
+	}
+}
+
+func TestRunPostAddActionsSkipsUnmetConditions(t *testing.T) {
+	repoRoot := t.TempDir()
+	worktreeRoot := t.TempDir()
+	writeFile(t, filepath.Join(repoRoot, ".whq.json"), `{
+		"post_add": {
+			"copy": [
+				{"path": "seed.sql", "when": {"branch": "feature/*"}},
+				{"path": "prod.env", "when": {"branch": "release/*"}}
+			],
+			"commands": [
+				{"name": "install", "run": "touch installed", "when": {"exists": "package.json"}},
+				{"name": "seed", "needs": ["install"], "run": "touch seeded", "when": {"branch": "feature/*"}}
+			]
+		}
+	}`)
+	writeFile(t, filepath.Join(repoRoot, "seed.sql"), "insert;")
+	writeFile(t, filepath.Join(repoRoot, "prod.env"), "PROD=1")
+
+	if err := runPostAddActions(repoRoot, worktreeRoot, "feature/login"); err != nil {
+		t.Fatalf("expected success, got error: %v", err)
+	}
+	for file, want := range map[string]bool{
+		"seed.sql":  true,
+		"prod.env":  false,
+		"installed": false,
+		"seeded":    true,
+	} {
+		_, err := os.Stat(filepath.Join(worktreeRoot, file))
+		if (err == nil) != want {
+			t.Errorf("%s: exists=%v, want %v", file, err == nil, want)
+		}
+	}
+}
+
+func TestRunPostAddActionsRejectsInvalidCondition(t *testing.T) {
+	repoRoot := t.TempDir()
+	writeFile(t, filepath.Join(repoRoot, ".whq.json"), `{
+		"post_add": {"commands": [{"run": "true", "when": {"branch_regex": "("}}]}
+	}`)
+	err := runPostAddActions(repoRoot, t.TempDir(), "main")
+	if err == nil || !strings.Contains(err.Error(), "invalid when.branch_regex") {
+		t.Fatalf("expected regex error, got %v", err)
+	}
+}
+
+func TestWhenConditionValidateEnv(t *testing.T) {
+	for item, valid := range map[string]bool{"CI": true, "!CI=true": true, "MODE=": true, "=x": false, "!": false} {
+		w := whenCondition{Env: stringList{item}}
+		if err := w.validate(); (err == nil) != valid {
+			t.Errorf("%q: got %v, want valid=%v", item, err, valid)
+		}
+	}
+}
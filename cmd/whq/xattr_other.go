@@ -0,0 +1,7 @@
+//go:build !linux && !darwin
+
+package main
+
+func copyXattrs(src, dest string, link bool) error {
+	return nil
+}
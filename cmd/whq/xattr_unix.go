@@ -0,0 +1,70 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"bytes"
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyXattrs copies every extended attribute of src to dest. Filesystems
+// without xattr support and attributes the caller may not set (for example
+// the trusted.* or security.* namespaces) are skipped.
+func copyXattrs(src, dest string, link bool) error {
+	list, get, set := unix.Listxattr, unix.Getxattr, unix.Setxattr
+	if link {
+		list, get, set = unix.Llistxattr, unix.Lgetxattr, unix.Lsetxattr
+	}
+
+	names, err := readXattr(func(buf []byte) (int, error) { return list(src, buf) })
+	if err != nil {
+		if ignorableXattrErr(err) {
+			return nil
+		}
+		return err
+	}
+	for _, name := range bytes.Split(names, []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		attr := string(name)
+		value, err := readXattr(func(buf []byte) (int, error) { return get(src, attr, buf) })
+		if err != nil {
+			if ignorableXattrErr(err) {
+				continue
+			}
+			return err
+		}
+		if err := set(dest, attr, value, 0); err != nil && !ignorableXattrErr(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// readXattr calls fn with a nil buffer to learn the size, then reads into a
+// buffer of that size.
+func readXattr(fn func([]byte) (int, error)) ([]byte, error) {
+	for {
+		n, err := fn(nil)
+		if err != nil || n == 0 {
+			return nil, err
+		}
+		buf := make([]byte, n)
+		n, err = fn(buf)
+		if errors.Is(err, unix.ERANGE) {
+			// Grew between the two calls; try again.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
+
+func ignorableXattrErr(err error) bool {
+	return errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EPERM) || errors.Is(err, unix.EACCES) || errors.Is(err, unix.ENODATA)
+}
@@ -1,8 +1,13 @@
 module github.com/nomnel/whq
 
-go 1.25
+go 1.25.0
 
-require github.com/spf13/cobra v1.8.0
+require (
+	github.com/pelletier/go-toml/v2 v2.4.3
+	github.com/spf13/cobra v1.8.0
+	golang.org/x/sys v0.47.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect